@@ -26,7 +26,7 @@ func main() {
 	defer client.Close()
 
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("Type a command with arguments and press Enter (available commands: SET, GET, DEL).")
+	fmt.Println("Type a command with arguments and press Enter (available commands: SET, GET, DEL, EXISTS, TYPE, RENAME, RENAMENX, COPY, EXPIRE, DBSIZE, RANGE, PREFIX, VADD, VSEARCH, LOCK, REFRESH, UNLOCK, THROTTLE, ENQUEUE, DEQUEUE, ACK, NACK).")
 	for {
 		fmt.Print("> ")
 		request, err := reader.ReadString('\n')
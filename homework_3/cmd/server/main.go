@@ -2,14 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 )
 
+// shutdownTimeout - time given to write pending changes on shutdown
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	cfgPath := flag.String("cfg_path", "config.yaml", "Config file path")
 	flag.Parse()
@@ -34,10 +41,20 @@ func main() {
 	}
 	defer func() { _ = logger.Close() }()
 
-	server, err := internal.Setup(cfg, logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server, err := internal.Setup(ctx, cfg, logger)
 	if err != nil {
 		log.Fatal("error setting up a server:", err.Error())
 	}
 
-	server.Run()
+	server.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to shut down: %s", err)
+	}
 }
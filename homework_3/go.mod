@@ -4,4 +4,4 @@ go 1.23.2
 
 require github.com/sadovnikoff/GoConcurrencyCourse/homework_2 v0.0.0-20240925233541-2dde926d3ff7
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require gopkg.in/yaml.v3 v3.0.1
@@ -20,12 +20,24 @@ const (
 	batchSize            = 10
 	maxSegmentSize       = "10MB"
 	defaultDirPath       = "data/wal"
-	loggingLevel         = "debug"
+	// defaultSnapshotDirPath - snapshots are kept apart from WAL segments
+	defaultSnapshotDirPath = "data/snapshots"
+	loggingLevel           = "debug"
 )
 
 // EngineConfig - engine config
 type EngineConfig struct {
-	Type string `yaml:"type"`
+	Type           string `yaml:"type"`
+	ShardsCount    int    `yaml:"shards_count"`
+	MaxMemory      string `yaml:"max_memory"`
+	EvictionPolicy string `yaml:"eviction_policy"`
+	DataDir        string `yaml:"data_dir"`
+	MemtableSize   string `yaml:"memtable_size"`
+	DataFileSize   string `yaml:"data_file_size"`
+	MemoryBudget   string `yaml:"memory_budget"`
+	Compression    string `yaml:"compression"`
+	// CompressionThreshold - values smaller than it are not compressed
+	CompressionThreshold string `yaml:"compression_threshold"`
 }
 
 // WalConfig - WAL config
@@ -34,6 +46,19 @@ type WalConfig struct {
 	FlushingTimeout string `yaml:"flushing_timeout"`
 	SegmentSize     string `yaml:"max_segment_size"`
 	DirPath         string `yaml:"dir_path"`
+	// KeyFile - keys segments and snapshots are encrypted with, they are not encrypted without it
+	KeyFile string `yaml:"key_file"`
+	// AllowPlaintext - segments and snapshots written before the keyfile was configured are read,
+	// they are rejected with a keyfile otherwise
+	AllowPlaintext bool `yaml:"allow_plaintext"`
+	// SnapshotInterval - snapshots are not taken if it is empty
+	SnapshotInterval string `yaml:"snapshot_interval"`
+	SnapshotDirPath  string `yaml:"snapshot_dir_path"`
+	// SyncMode - always, interval or none, batches are synced before they are acknowledged by default
+	SyncMode     string `yaml:"sync_mode"`
+	SyncInterval string `yaml:"sync_interval"`
+	// PreallocateSegments - segments reserve max segment size on disk and removed segments are reused
+	PreallocateSegments bool `yaml:"preallocate_segments"`
 }
 
 // NetworkConfig - network config
@@ -122,8 +147,12 @@ func ParseConfig(reader io.Reader) (*Config, error) {
 		if config.Wal.DirPath == "" {
 			config.Wal.DirPath = defaultDirPath
 		}
+
+		if config.Wal.SnapshotDirPath == "" {
+			config.Wal.SnapshotDirPath = defaultSnapshotDirPath
+		}
 	}
-	
+
 	if config.Logging.Level == "" {
 		config.Logging.Level = loggingLevel
 	}
@@ -11,6 +11,15 @@ import (
 const testConfig = `
 engine:
   type: "in_memory_test"
+  shards_count: 8
+  max_memory: "64MB"
+  eviction_policy: "allkeys-lru"
+  data_dir: "/test/data"
+  memtable_size: "1MB"
+  data_file_size: "16MB"
+  memory_budget: "32MB"
+  compression: "flate"
+  compression_threshold: "1KB"
 network:
   address: "127.0.0.1:9999"
   max_connections: 50
@@ -34,7 +43,16 @@ func TestParseConfig(t *testing.T) {
 			reader: strings.NewReader(testConfig),
 			expectedCfg: &Config{
 				Engine: &EngineConfig{
-					Type: "in_memory_test",
+					Type:                 "in_memory_test",
+					ShardsCount:          8,
+					MaxMemory:            "64MB",
+					EvictionPolicy:       "allkeys-lru",
+					DataDir:              "/test/data",
+					MemtableSize:         "1MB",
+					DataFileSize:         "16MB",
+					MemoryBudget:         "32MB",
+					Compression:          "flate",
+					CompressionThreshold: "1KB",
 				},
 				Network: &NetworkConfig{
 					Address:        "127.0.0.1:9999",
@@ -2,7 +2,10 @@ package compute
 
 import (
 	"errors"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 )
@@ -11,8 +14,72 @@ const (
 	SetCommand = "SET"
 	GetCommand = "GET"
 	DelCommand = "DEL"
+
+	ExistsCommand   = "EXISTS"
+	TypeCommand     = "TYPE"
+	RenameCommand   = "RENAME"
+	RenameNXCommand = "RENAMENX"
+	CopyCommand     = "COPY"
+	DBSizeCommand   = "DBSIZE"
+	ExpireCommand   = "EXPIRE"
+
+	RangeCommand  = "RANGE"
+	PrefixCommand = "PREFIX"
+
+	// EvictCommand - written to WAL only, keys evicted by the engine to free memory
+	EvictCommand = "EVICT"
+
+	VAddCommand    = "VADD"
+	VSearchCommand = "VSEARCH"
+
+	LockCommand    = "LOCK"
+	UnlockCommand  = "UNLOCK"
+	RefreshCommand = "REFRESH"
+
+	ThrottleCommand = "THROTTLE"
+
+	EnqueueCommand = "ENQUEUE"
+	DequeueCommand = "DEQUEUE"
+	AckCommand     = "ACK"
+	NackCommand    = "NACK"
+
+	ReplaceOption    = "REPLACE"
+	PrefixOption     = "PREFIX"
+	DelayOption      = "DELAY"
+	AttemptsOption   = "ATTEMPTS"
+	VisibilityOption = "VISIBILITY"
+	LimitOption      = "LIMIT"
+	// AsyncOption - trails key changes which do not wait for WAL to sync them
+	AsyncOption = "ASYNC"
+	// LSNOption - trails changes which return the sequence number they are logged with
+	LSNOption = "LSN"
 )
 
+// flaggedCommands - changes accepting the trailing ASYNC and LSN flags with the least number of their
+// tokens, flags among them are arguments
+var flaggedCommands = map[string]int{
+	SetCommand:      3,
+	DelCommand:      2,
+	RenameCommand:   3,
+	RenameNXCommand: 3,
+	CopyCommand:     3,
+	ExpireCommand:   3,
+	VAddCommand:     4,
+	EnqueueCommand:  3,
+	AckCommand:      3,
+	NackCommand:     3,
+}
+
+// sequencedCommands - changes accepting only the trailing LSN flag with the least number of their tokens,
+// lock changes always wait for the sync to keep fencing tokens unique after a crash, every logged change
+// accepts LSN
+var sequencedCommands = map[string]int{
+	LockCommand:    4,
+	RefreshCommand: 4,
+	UnlockCommand:  3,
+	DequeueCommand: 2,
+}
+
 type Parser struct {
 	logger *common.Logger
 }
@@ -32,7 +99,11 @@ func NewParser(logger *common.Logger) (*Parser, error) {
 }
 
 func (p *Parser) Parse(request string) (Query, error) {
-	tokens := strings.Fields(request)
+	tokens, async, lsn := trimFlags(strings.Fields(request))
+
+	if len(tokens) == 1 && tokens[0] == DBSizeCommand {
+		return NewQuery(tokens[0]), nil
+	}
 
 	if len(tokens) < 2 {
 		p.logger.Debug("%s [%s]", errInvalidRequest.Error(), request)
@@ -41,22 +112,268 @@ func (p *Parser) Parse(request string) (Query, error) {
 
 	var query Query
 	switch tokens[0] {
-	case GetCommand, DelCommand:
+	case GetCommand, DelCommand, ExistsCommand, TypeCommand:
 		if len(tokens) != 2 {
 			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
 			return Query{}, errInvalidArguments
 		}
 		query = NewQuery(tokens[0], tokens[1], "")
-	case SetCommand:
+	case SetCommand, RenameCommand, RenameNXCommand:
 		if len(tokens) != 3 {
 			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
 			return Query{}, errInvalidArguments
 		}
 		query = NewQuery(tokens[0], tokens[1], tokens[2])
+	case CopyCommand:
+		// COPY source destination [REPLACE]
+		if len(tokens) != 3 && (len(tokens) != 4 || tokens[3] != ReplaceOption) {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+		query = NewQuery(tokens[0], tokens[1:]...)
+	case DBSizeCommand:
+		p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+		return Query{}, errInvalidArguments
+	case VAddCommand:
+		// VADD index key x1 ... xN
+		if len(tokens) < 4 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+
+		if _, err := ParseVector(tokens[3:]); err != nil {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+		query = NewQuery(tokens[0], tokens[1:]...)
+	case VSearchCommand:
+		// VSEARCH index metric limit x1 ... xN [PREFIX prefix]
+		var err error
+		query, err = p.parseVectorSearch(tokens)
+		if err != nil {
+			p.logger.Debug("%s [%s]", err.Error(), request)
+			return Query{}, err
+		}
+	case ExpireCommand:
+		// EXPIRE key ttl
+		if len(tokens) != 3 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+
+		if ttl, err := time.ParseDuration(tokens[2]); err != nil || ttl <= 0 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+		query = NewQuery(tokens[0], tokens[1:]...)
+	case RangeCommand:
+		// RANGE start end [LIMIT count]
+		if len(tokens) < 3 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+
+		options, err := parseOptions(tokens[3:], map[string]string{LimitOption: "0"})
+		if err != nil {
+			p.logger.Debug("%s [%s]", err.Error(), request)
+			return Query{}, err
+		}
+		query = NewQuery(tokens[0], tokens[1], tokens[2], options[LimitOption])
+	case PrefixCommand:
+		// PREFIX prefix [LIMIT count]
+		options, err := parseOptions(tokens[2:], map[string]string{LimitOption: "0"})
+		if err != nil {
+			p.logger.Debug("%s [%s]", err.Error(), request)
+			return Query{}, err
+		}
+		query = NewQuery(tokens[0], tokens[1], options[LimitOption])
+	case LockCommand, RefreshCommand:
+		// LOCK name owner ttl
+		if len(tokens) != 4 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+
+		if ttl, err := time.ParseDuration(tokens[3]); err != nil || ttl <= 0 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+		query = NewQuery(tokens[0], tokens[1:]...)
+	case ThrottleCommand:
+		// THROTTLE key max_burst count period [algorithm]
+		if err := p.validateThrottle(tokens); err != nil {
+			p.logger.Debug("%s [%s]", err.Error(), request)
+			return Query{}, err
+		}
+		query = NewQuery(tokens[0], tokens[1:]...)
+	case EnqueueCommand:
+		// ENQUEUE queue payload [DELAY duration] [ATTEMPTS count]
+		if len(tokens) < 3 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+
+		options, err := parseOptions(tokens[3:], map[string]string{DelayOption: "0s", AttemptsOption: "0"})
+		if err != nil {
+			p.logger.Debug("%s [%s]", err.Error(), request)
+			return Query{}, err
+		}
+		query = NewQuery(tokens[0], tokens[1], tokens[2], options[DelayOption], options[AttemptsOption])
+	case DequeueCommand:
+		// DEQUEUE queue [VISIBILITY duration]
+		options, err := parseOptions(tokens[2:], map[string]string{VisibilityOption: "0s"})
+		if err != nil {
+			p.logger.Debug("%s [%s]", err.Error(), request)
+			return Query{}, err
+		}
+		query = NewQuery(tokens[0], tokens[1], options[VisibilityOption])
+	case UnlockCommand, AckCommand, NackCommand:
+		// UNLOCK name owner, ACK queue receipt
+		if len(tokens) != 3 {
+			p.logger.Debug("%s [%s]", errInvalidArguments.Error(), request)
+			return Query{}, errInvalidArguments
+		}
+		query = NewQuery(tokens[0], tokens[1:]...)
 	default:
 		p.logger.Debug("%s [%s]", errInvalidCommand.Error(), request)
 		return Query{}, errInvalidCommand
 	}
 
+	query.async, query.lsn = async, lsn
 	return query, nil
 }
+
+// trimFlags removes the trailing ASYNC and LSN flags of changes accepting them, they follow in any order
+func trimFlags(tokens []string) ([]string, bool, bool) {
+	if len(tokens) == 0 {
+		return tokens, false, false
+	}
+
+	size, asyncAccepted := flaggedCommands[tokens[0]]
+	if !asyncAccepted {
+		var ok bool
+		if size, ok = sequencedCommands[tokens[0]]; !ok {
+			return tokens, false, false
+		}
+	}
+
+	var async, lsn bool
+	for len(tokens) > size {
+		switch last := tokens[len(tokens)-1]; {
+		case last == AsyncOption && asyncAccepted && !async:
+			async = true
+		case last == LSNOption && !lsn:
+			lsn = true
+		default:
+			return tokens, async, lsn
+		}
+
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	return tokens, async, lsn
+}
+
+// parseVectorSearch returns a query with arguments in the order: index, metric, limit, prefix, x1 ... xN
+func (p *Parser) parseVectorSearch(tokens []string) (Query, error) {
+	prefix := ""
+	if len(tokens) > 2 && tokens[len(tokens)-2] == PrefixOption {
+		prefix = tokens[len(tokens)-1]
+		tokens = tokens[:len(tokens)-2]
+	}
+
+	if len(tokens) < 5 {
+		return Query{}, errInvalidArguments
+	}
+
+	if limit, err := strconv.Atoi(tokens[3]); err != nil || limit <= 0 {
+		return Query{}, errInvalidArguments
+	}
+
+	if _, err := ParseVector(tokens[4:]); err != nil {
+		return Query{}, errInvalidArguments
+	}
+
+	args := append([]string{tokens[1], tokens[2], tokens[3], prefix}, tokens[4:]...)
+	return NewQuery(tokens[0], args...), nil
+}
+
+func (p *Parser) validateThrottle(tokens []string) error {
+	if len(tokens) != 5 && len(tokens) != 6 {
+		return errInvalidArguments
+	}
+
+	if maxBurst, err := strconv.Atoi(tokens[2]); err != nil || maxBurst < 0 {
+		return errInvalidArguments
+	}
+
+	count, err := strconv.Atoi(tokens[3])
+	if err != nil || count <= 0 {
+		return errInvalidArguments
+	}
+
+	// the interval between requests must be at least a nanosecond
+	if period, err := time.ParseDuration(tokens[4]); err != nil || period <= 0 || period/time.Duration(count) == 0 {
+		return errInvalidArguments
+	}
+
+	return nil
+}
+
+// parseOptions returns values of the named options, absent options get the defaults,
+// durations and limits must be positive and counts must be non-negative integers
+func parseOptions(tokens []string, defaults map[string]string) (map[string]string, error) {
+	if len(tokens)%2 != 0 {
+		return nil, errInvalidArguments
+	}
+
+	options := make(map[string]string, len(defaults))
+	for name, value := range defaults {
+		options[name] = value
+	}
+
+	for i := 0; i < len(tokens); i += 2 {
+		name, value := tokens[i], tokens[i+1]
+		if _, ok := defaults[name]; !ok {
+			return nil, errInvalidArguments
+		}
+
+		switch name {
+		case DelayOption, VisibilityOption:
+			if duration, err := time.ParseDuration(value); err != nil || duration <= 0 {
+				return nil, errInvalidArguments
+			}
+		case AttemptsOption:
+			if count, err := strconv.Atoi(value); err != nil || count < 0 {
+				return nil, errInvalidArguments
+			}
+		case LimitOption:
+			if count, err := strconv.Atoi(value); err != nil || count <= 0 {
+				return nil, errInvalidArguments
+			}
+		}
+
+		options[name] = value
+	}
+
+	return options, nil
+}
+
+// ParseVector converts vector coordinates from their text representation
+func ParseVector(tokens []string) ([]float32, error) {
+	vector := make([]float32, 0, len(tokens))
+	for _, token := range tokens {
+		value, err := strconv.ParseFloat(token, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, errInvalidArguments
+		}
+
+		vector = append(vector, float32(value))
+	}
+
+	return vector, nil
+}
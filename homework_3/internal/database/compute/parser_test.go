@@ -2,6 +2,7 @@ package compute
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
@@ -128,6 +129,204 @@ func TestParser_Parse(t *testing.T) {
 			expectedQuery: NewQuery("", "", ""),
 			expectedErr:   errInvalidArguments,
 		},
+		{
+			name:          "Valid EXISTS request",
+			request:       "EXISTS some_key",
+			expectedQuery: NewQuery("EXISTS", "some_key", ""),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Valid RENAMENX request",
+			request:       "RENAMENX some_key new_key",
+			expectedQuery: NewQuery("RENAMENX", "some_key", "new_key"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid RENAME request - not enough args",
+			request:       "RENAME some_key",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid COPY request with REPLACE",
+			request:       "COPY some_key new_key REPLACE",
+			expectedQuery: NewQuery("COPY", "some_key", "new_key"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid COPY request - unknown option",
+			request:       "COPY some_key new_key FORCE",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid EXPIRE request",
+			request:       "EXPIRE some_key 10s",
+			expectedQuery: NewQuery("EXPIRE", "some_key", "10s"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid EXPIRE request - non-positive ttl",
+			request:       "EXPIRE some_key 0s",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid DBSIZE request",
+			request:       "DBSIZE",
+			expectedQuery: NewQuery("DBSIZE", "", ""),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid DBSIZE request - too many args",
+			request:       "DBSIZE some_key",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid LOCK request",
+			request:       "LOCK resource owner_1 30s",
+			expectedQuery: NewQuery("LOCK", "resource", "owner_1", "30s"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid LOCK request - wrong ttl",
+			request:       "LOCK resource owner_1 30",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Invalid REFRESH request - negative ttl",
+			request:       "REFRESH resource owner_1 -1s",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid UNLOCK request",
+			request:       "UNLOCK resource owner_1",
+			expectedQuery: NewQuery("UNLOCK", "resource", "owner_1"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid UNLOCK request - not enough args",
+			request:       "UNLOCK resource",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid THROTTLE request",
+			request:       "THROTTLE user_1 15 30 60s",
+			expectedQuery: NewQuery("THROTTLE", "user_1", "15", "30", "60s"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Valid THROTTLE request with algorithm",
+			request:       "THROTTLE user_1 0 30 1m WINDOW",
+			expectedQuery: NewQuery("THROTTLE", "user_1", "0", "30", "1m", "WINDOW"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid THROTTLE request - zero count",
+			request:       "THROTTLE user_1 15 0 60s",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Invalid THROTTLE request - wrong period",
+			request:       "THROTTLE user_1 15 30 60",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Invalid THROTTLE request - period shorter than a nanosecond per request",
+			request:       "THROTTLE user_1 0 10 5ns",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid ENQUEUE request",
+			request:       "ENQUEUE jobs payload",
+			expectedQuery: NewQuery("ENQUEUE", "jobs", "payload", "0s", "0"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Valid ENQUEUE request with options",
+			request:       "ENQUEUE jobs payload ATTEMPTS 3 DELAY 5s",
+			expectedQuery: NewQuery("ENQUEUE", "jobs", "payload", "5s", "3"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid ENQUEUE request - unknown option",
+			request:       "ENQUEUE jobs payload VISIBILITY 5s",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Invalid ENQUEUE request - option without value",
+			request:       "ENQUEUE jobs payload DELAY",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid DEQUEUE request",
+			request:       "DEQUEUE jobs VISIBILITY 1m",
+			expectedQuery: NewQuery("DEQUEUE", "jobs", "1m"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid DEQUEUE request - wrong visibility",
+			request:       "DEQUEUE jobs VISIBILITY 0s",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid RANGE request",
+			request:       "RANGE order:2024-01 order:2024-02",
+			expectedQuery: NewQuery("RANGE", "order:2024-01", "order:2024-02", "0"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Valid RANGE request with limit",
+			request:       "RANGE a b LIMIT 10",
+			expectedQuery: NewQuery("RANGE", "a", "b", "10"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid RANGE request - no end",
+			request:       "RANGE a",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Invalid RANGE request - wrong limit",
+			request:       "RANGE a b LIMIT 0",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid PREFIX request",
+			request:       "PREFIX user: LIMIT 5",
+			expectedQuery: NewQuery("PREFIX", "user:", "5"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid PREFIX request - unknown option",
+			request:       "PREFIX user: COUNT 5",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
+		{
+			name:          "Valid ACK request",
+			request:       "ACK jobs 12.1",
+			expectedQuery: NewQuery("ACK", "jobs", "12.1"),
+			expectedErr:   nil,
+		},
+		{
+			name:          "Invalid NACK request - not enough args",
+			request:       "NACK jobs",
+			expectedQuery: NewQuery("", "", ""),
+			expectedErr:   errInvalidArguments,
+		},
 		{
 			name:          "Invalid command request - lowercase SET",
 			request:       "set some_key some_value",
@@ -169,3 +368,209 @@ func TestParser_Parse(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_Parse_VectorCommands(t *testing.T) {
+	tests := []struct {
+		name         string
+		request      string
+		expectedArgs []string
+		expectedErr  error
+	}{
+		{
+			name:         "Valid VADD request",
+			request:      "VADD items item_1 0.5 -1 2e-3",
+			expectedArgs: []string{"items", "item_1", "0.5", "-1", "2e-3"},
+		},
+		{
+			name:        "Invalid VADD request - no coordinates",
+			request:     "VADD items item_1",
+			expectedErr: errInvalidArguments,
+		},
+		{
+			name:        "Invalid VADD request - not a number coordinate",
+			request:     "VADD items item_1 0.5 abc",
+			expectedErr: errInvalidArguments,
+		},
+		{
+			name:        "Invalid VADD request - NaN coordinate",
+			request:     "VADD items item_1 0.5 NaN",
+			expectedErr: errInvalidArguments,
+		},
+		{
+			name:         "Valid VSEARCH request",
+			request:      "VSEARCH items COSINE 10 0.5 1",
+			expectedArgs: []string{"items", "COSINE", "10", "", "0.5", "1"},
+		},
+		{
+			name:         "Valid VSEARCH request with prefix",
+			request:      "VSEARCH items L2 3 0.5 1 PREFIX user:",
+			expectedArgs: []string{"items", "L2", "3", "user:", "0.5", "1"},
+		},
+		{
+			name:        "Invalid VSEARCH request - no coordinates",
+			request:     "VSEARCH items L2 3 PREFIX user:",
+			expectedErr: errInvalidArguments,
+		},
+		{
+			name:        "Invalid VSEARCH request - wrong limit",
+			request:     "VSEARCH items DOT 0 0.5 1",
+			expectedErr: errInvalidArguments,
+		},
+	}
+
+	logger, _ := common.NewLogger("", "")
+	parser, err := NewParser(logger)
+	if err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parser.Parse(tt.request)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("want %q; got %q", tt.expectedErr, err)
+			}
+
+			if !reflect.DeepEqual(query.Arguments(), tt.expectedArgs) {
+				t.Errorf("want %q; got %q", tt.expectedArgs, query.Arguments())
+			}
+		})
+	}
+}
+
+func TestParser_Parse_Async(t *testing.T) {
+	tests := []struct {
+		name          string
+		request       string
+		expectedArgs  []string
+		expectedAsync bool
+		expectedLSN   bool
+		expectedErr   error
+	}{
+		{
+			name:          "SET with ASYNC",
+			request:       "SET key value ASYNC",
+			expectedArgs:  []string{"key", "value"},
+			expectedAsync: true,
+		},
+		{
+			name:         "SET of the ASYNC value",
+			request:      "SET key ASYNC",
+			expectedArgs: []string{"key", "ASYNC"},
+		},
+		{
+			name:          "DEL with ASYNC",
+			request:       "DEL key ASYNC",
+			expectedArgs:  []string{"key", ""},
+			expectedAsync: true,
+		},
+		{
+			name:          "COPY with REPLACE and ASYNC",
+			request:       "COPY key new_key REPLACE ASYNC",
+			expectedArgs:  []string{"key", "new_key", "REPLACE"},
+			expectedAsync: true,
+		},
+		{
+			name:         "SET with LSN",
+			request:      "SET key value LSN",
+			expectedArgs: []string{"key", "value"},
+			expectedLSN:  true,
+		},
+		{
+			name:          "SET with LSN and ASYNC",
+			request:       "SET key value LSN ASYNC",
+			expectedArgs:  []string{"key", "value"},
+			expectedAsync: true,
+			expectedLSN:   true,
+		},
+		{
+			name:        "SET with repeated LSN",
+			request:     "SET key value LSN LSN",
+			expectedErr: errInvalidArguments,
+		},
+		{
+			name:          "VADD with ASYNC",
+			request:       "VADD index key 1 2 ASYNC",
+			expectedArgs:  []string{"index", "key", "1", "2"},
+			expectedAsync: true,
+		},
+		{
+			name:          "ENQUEUE with options and ASYNC",
+			request:       "ENQUEUE jobs payload DELAY 1s ASYNC",
+			expectedArgs:  []string{"jobs", "payload", "1s", "0"},
+			expectedAsync: true,
+		},
+		{
+			name:          "ACK with ASYNC",
+			request:       "ACK jobs 1.1 ASYNC",
+			expectedArgs:  []string{"jobs", "1.1"},
+			expectedAsync: true,
+		},
+		{
+			name:          "NACK with ASYNC",
+			request:       "NACK jobs 1.1 ASYNC",
+			expectedArgs:  []string{"jobs", "1.1"},
+			expectedAsync: true,
+		},
+		{
+			name:         "LOCK with LSN",
+			request:      "LOCK name owner 1s LSN",
+			expectedArgs: []string{"name", "owner", "1s"},
+			expectedLSN:  true,
+		},
+		{
+			name:         "DEQUEUE with LSN",
+			request:      "DEQUEUE jobs LSN",
+			expectedArgs: []string{"jobs", "0s"},
+			expectedLSN:  true,
+		},
+		{
+			name:         "UNLOCK with LSN",
+			request:      "UNLOCK name owner LSN",
+			expectedArgs: []string{"name", "owner"},
+			expectedLSN:  true,
+		},
+		{
+			name:        "LOCK with ASYNC",
+			request:     "LOCK name owner 1s ASYNC",
+			expectedErr: errInvalidArguments,
+		},
+		{
+			name:        "GET with ASYNC",
+			request:     "GET key ASYNC",
+			expectedErr: errInvalidArguments,
+		},
+		{
+			name:        "ASYNC not trailing",
+			request:     "SET key ASYNC value",
+			expectedErr: errInvalidArguments,
+		},
+	}
+
+	logger, _ := common.NewLogger("", "")
+	parser, _ := NewParser(logger)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parser.Parse(tt.request)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("want %q; got %q", tt.expectedErr, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(query.Arguments(), tt.expectedArgs) {
+				t.Errorf("want %+v; got %+v", tt.expectedArgs, query.Arguments())
+			}
+
+			if query.Async() != tt.expectedAsync {
+				t.Errorf("want %+v; got %+v", tt.expectedAsync, query.Async())
+			}
+
+			if query.ReturnsLSN() != tt.expectedLSN {
+				t.Errorf("want %+v; got %+v", tt.expectedLSN, query.ReturnsLSN())
+			}
+		})
+	}
+}
@@ -2,22 +2,47 @@ package compute
 
 type Query struct {
 	cmd  string
-	arg1 string
-	arg2 string
+	args []string
+	// async - the change is acknowledged without waiting for it to be synced to disk
+	async bool
+	// lsn - the sequence number the change is logged with is returned
+	lsn bool
 }
 
-func NewQuery(cmd, arg1, arg2 string) Query {
-	return Query{cmd, arg1, arg2}
+func NewQuery(cmd string, args ...string) Query {
+	return Query{cmd: cmd, args: args}
 }
 
 func (q *Query) Command() string {
 	return q.cmd
 }
 
+func (q *Query) Arguments() []string {
+	return q.args
+}
+
+// Async reports whether the change was sent with the ASYNC flag
+func (q *Query) Async() bool {
+	return q.async
+}
+
+// ReturnsLSN reports whether the change was sent with the LSN flag
+func (q *Query) ReturnsLSN() bool {
+	return q.lsn
+}
+
 func (q *Query) KeyArgument() string {
-	return q.arg1
+	return q.argument(0)
 }
 
 func (q *Query) ValueArgument() string {
-	return q.arg2
+	return q.argument(1)
+}
+
+func (q *Query) argument(idx int) string {
+	if idx >= len(q.args) {
+		return ""
+	}
+
+	return q.args[idx]
 }
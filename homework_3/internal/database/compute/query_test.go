@@ -1,6 +1,7 @@
 package compute
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -87,3 +88,35 @@ func TestQuery_ValueArgument(t *testing.T) {
 		})
 	}
 }
+
+func TestQuery_Arguments(t *testing.T) {
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{
+			name: "Empty",
+			q:    NewQuery("CMD"),
+			want: nil,
+		},
+		{
+			name: "NotEmpty",
+			q:    NewQuery("CMD", "arg1", "arg2", "arg3"),
+			want: []string{"arg1", "arg2", "arg3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.q.Arguments()
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("want %q; got %q", tt.want, args)
+			}
+
+			if tt.q.ValueArgument() != "" && len(tt.want) < 2 {
+				t.Errorf("want %q; got %q", "", tt.q.ValueArgument())
+			}
+		})
+	}
+}
@@ -3,9 +3,16 @@ package database
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/queue"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/vector"
 )
 
 type computeLayer interface {
@@ -16,6 +23,35 @@ type storageLayer interface {
 	Set(string, string) error
 	Get(string) (string, error)
 	Del(string) error
+	Exists(string) (bool, error)
+	Type(string) (string, error)
+	Rename(string, string) error
+	RenameNX(string, string) (bool, error)
+	Copy(string, string, bool) (bool, error)
+	Expire(string, time.Duration) (bool, error)
+	Size() (int, error)
+	Range(string, string, int) ([]storage.KeyValue, error)
+	Prefix(string, int) ([]storage.KeyValue, error)
+	VAdd(string, string, []float32) error
+	VSearch(string, string, int, []float32, string) ([]vector.Result, error)
+	Lock(string, string, time.Duration) (uint64, error)
+	Refresh(string, string, time.Duration) error
+	Unlock(string, string) error
+	Throttle(string, throttle.Limit) (throttle.Result, error)
+	Enqueue(string, string, time.Duration, int) (uint64, error)
+	Dequeue(string, time.Duration) (queue.Lease, error)
+	Ack(string, string) error
+	Nack(string, string) error
+}
+
+// asyncStorage - storage able to acknowledge changes before they are synced to disk
+type asyncStorage interface {
+	Async() *storage.Storage
+}
+
+// sequencedStorage - storage able to report sequence numbers changes are logged with
+type sequencedStorage interface {
+	Sequenced(*uint64) *storage.Storage
 }
 
 type Database struct {
@@ -44,6 +80,22 @@ func NewDatabase(computeLayer computeLayer, storageLayer storageLayer, logger *c
 	}, nil
 }
 
+// writer returns the storage applying changes of the query, changes sent with ASYNC are served durably
+// if the storage cannot acknowledge them early, the sequence number of changes sent with LSN is stored
+// into lsn, it stays zero if the change is not logged
+func (d *Database) writer(query compute.Query, lsn *uint64) storageLayer {
+	writer := d.storageLayer
+	if storage, ok := writer.(asyncStorage); ok && query.Async() {
+		writer = storage.Async()
+	}
+
+	if storage, ok := writer.(sequencedStorage); ok && query.ReturnsLSN() {
+		writer = storage.Sequenced(lsn)
+	}
+
+	return writer
+}
+
 func (d *Database) HandleQuery(request string) (string, error) {
 	d.logger.Info("handling request [%s]", request)
 
@@ -53,10 +105,13 @@ func (d *Database) HandleQuery(request string) (string, error) {
 		return "", err
 	}
 
+	var lsn uint64
+	writer := d.writer(query, &lsn)
+
 	var response string
 	switch query.Command() {
 	case compute.SetCommand:
-		err := d.storageLayer.Set(query.KeyArgument(), query.ValueArgument())
+		err := writer.Set(query.KeyArgument(), query.ValueArgument())
 		if err != nil {
 			return "", err
 		}
@@ -68,14 +123,254 @@ func (d *Database) HandleQuery(request string) (string, error) {
 		}
 		response = fmt.Sprintf("[ok] %s", val)
 	case compute.DelCommand:
-		err := d.storageLayer.Del(query.KeyArgument())
+		err := writer.Del(query.KeyArgument())
 		if err != nil {
 			return "", err
 		}
 		response = fmt.Sprintf("[ok]")
+	case compute.ExistsCommand:
+		exists, err := d.storageLayer.Exists(query.KeyArgument())
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %d", boolToInt(exists))
+	case compute.TypeCommand:
+		valueType, err := d.storageLayer.Type(query.KeyArgument())
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %s", valueType)
+	case compute.RenameCommand:
+		err := writer.Rename(query.KeyArgument(), query.ValueArgument())
+		if err != nil {
+			return "", err
+		}
+		response = "[ok]"
+	case compute.RenameNXCommand:
+		renamed, err := writer.RenameNX(query.KeyArgument(), query.ValueArgument())
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %d", boolToInt(renamed))
+	case compute.CopyCommand:
+		replace := len(query.Arguments()) > 2
+		copied, err := writer.Copy(query.KeyArgument(), query.ValueArgument(), replace)
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %d", boolToInt(copied))
+	case compute.ExpireCommand:
+		ttl, err := time.ParseDuration(query.ValueArgument())
+		if err != nil {
+			return "", err
+		}
+
+		expired, err := writer.Expire(query.KeyArgument(), ttl)
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %d", boolToInt(expired))
+	case compute.DBSizeCommand:
+		size, err := d.storageLayer.Size()
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %d", size)
+	case compute.RangeCommand:
+		args := query.Arguments()
+		limit, err := strconv.Atoi(args[2])
+		if err != nil {
+			return "", err
+		}
+
+		entries, err := d.storageLayer.Range(args[0], args[1], limit)
+		if err != nil {
+			return "", err
+		}
+		response = formatEntries(entries)
+	case compute.PrefixCommand:
+		limit, err := strconv.Atoi(query.ValueArgument())
+		if err != nil {
+			return "", err
+		}
+
+		entries, err := d.storageLayer.Prefix(query.KeyArgument(), limit)
+		if err != nil {
+			return "", err
+		}
+		response = formatEntries(entries)
+	case compute.VAddCommand:
+		args := query.Arguments()
+		coordinates, err := compute.ParseVector(args[2:])
+		if err != nil {
+			return "", err
+		}
+
+		err = writer.VAdd(args[0], args[1], coordinates)
+		if err != nil {
+			return "", err
+		}
+		response = "[ok]"
+	case compute.VSearchCommand:
+		results, err := d.vectorSearch(query.Arguments())
+		if err != nil {
+			return "", err
+		}
+		response = strings.Join(append([]string{"[ok]"}, results...), " ")
+	case compute.LockCommand:
+		args := query.Arguments()
+		ttl, err := time.ParseDuration(args[2])
+		if err != nil {
+			return "", err
+		}
+
+		token, err := writer.Lock(args[0], args[1], ttl)
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %d", token)
+	case compute.RefreshCommand:
+		args := query.Arguments()
+		ttl, err := time.ParseDuration(args[2])
+		if err != nil {
+			return "", err
+		}
+
+		err = writer.Refresh(args[0], args[1], ttl)
+		if err != nil {
+			return "", err
+		}
+		response = "[ok]"
+	case compute.UnlockCommand:
+		args := query.Arguments()
+		err := writer.Unlock(args[0], args[1])
+		if err != nil {
+			return "", err
+		}
+		response = "[ok]"
+	case compute.ThrottleCommand:
+		result, err := d.throttle(query.Arguments())
+		if err != nil {
+			return "", err
+		}
+
+		response = fmt.Sprintf("[ok] %d %d %s", boolToInt(result.Allowed), result.Remaining, result.RetryAfter)
+	case compute.EnqueueCommand:
+		id, err := d.enqueue(writer, query.Arguments())
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %d", id)
+	case compute.DequeueCommand:
+		visibility, err := time.ParseDuration(query.ValueArgument())
+		if err != nil {
+			return "", err
+		}
+
+		lease, err := writer.Dequeue(query.KeyArgument(), visibility)
+		if err != nil {
+			return "", err
+		}
+		response = fmt.Sprintf("[ok] %s %s", lease.Receipt, lease.Job.Payload)
+	case compute.AckCommand:
+		err := writer.Ack(query.KeyArgument(), query.ValueArgument())
+		if err != nil {
+			return "", err
+		}
+		response = "[ok]"
+	case compute.NackCommand:
+		err := writer.Nack(query.KeyArgument(), query.ValueArgument())
+		if err != nil {
+			return "", err
+		}
+		response = "[ok]"
 	default:
 		return "", errors.New("unknown command")
 	}
 
+	// clients wait for the sequence number to be reached elsewhere to read their writes there
+	if query.ReturnsLSN() {
+		response = fmt.Sprintf("%s [lsn %d]", response, lsn)
+	}
+
 	return response, nil
 }
+
+func (d *Database) enqueue(writer storageLayer, args []string) (uint64, error) {
+	delay, err := time.ParseDuration(args[2])
+	if err != nil {
+		return 0, err
+	}
+
+	maxAttempts, err := strconv.Atoi(args[3])
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.Enqueue(args[0], args[1], delay, maxAttempts)
+}
+
+func (d *Database) throttle(args []string) (throttle.Result, error) {
+	limit := throttle.Limit{}
+	if len(args) > 4 {
+		limit.Algorithm = args[4]
+	}
+
+	var err error
+	if limit.MaxBurst, err = strconv.Atoi(args[1]); err != nil {
+		return throttle.Result{}, err
+	}
+
+	if limit.Count, err = strconv.Atoi(args[2]); err != nil {
+		return throttle.Result{}, err
+	}
+
+	if limit.Period, err = time.ParseDuration(args[3]); err != nil {
+		return throttle.Result{}, err
+	}
+
+	return d.storageLayer.Throttle(args[0], limit)
+}
+
+func (d *Database) vectorSearch(args []string) ([]string, error) {
+	limit, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	coordinates, err := compute.ParseVector(args[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := d.storageLayer.VSearch(args[0], args[1], limit, coordinates, args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	formatted := make([]string, 0, len(results))
+	for _, result := range results {
+		formatted = append(formatted, fmt.Sprintf("%s:%g", result.Key, result.Score))
+	}
+
+	return formatted, nil
+}
+
+// formatEntries returns keys with their values one after another
+func formatEntries(entries []storage.KeyValue) string {
+	formatted := make([]string, 0, 1+2*len(entries))
+	formatted = append(formatted, "[ok]")
+	for _, entry := range entries {
+		formatted = append(formatted, entry.Key, entry.Value)
+	}
+
+	return strings.Join(formatted, " ")
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
@@ -2,8 +2,13 @@ package database
 
 import (
 	"errors"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/queue"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/vector"
 )
 
 // MockComputeLayer is mock of ComputeLayer interface
@@ -38,6 +43,36 @@ func (m *MockComputeLayer) Parse(cmd string) (compute.Query, error) {
 		return compute.NewQuery(cmd, "key", ""), nil
 	case compute.DelCommand:
 		return compute.NewQuery(cmd, "key", ""), nil
+	case compute.ExistsCommand, compute.TypeCommand:
+		return compute.NewQuery(cmd, "key"), nil
+	case compute.RenameCommand, compute.RenameNXCommand:
+		return compute.NewQuery(cmd, "key", "new_key"), nil
+	case compute.CopyCommand:
+		return compute.NewQuery(cmd, "key", "new_key", compute.ReplaceOption), nil
+	case compute.DBSizeCommand:
+		return compute.NewQuery(cmd), nil
+	case compute.ExpireCommand:
+		return compute.NewQuery(cmd, "key", "10s"), nil
+	case compute.RangeCommand:
+		return compute.NewQuery(cmd, "key1", "key3", "0"), nil
+	case compute.PrefixCommand:
+		return compute.NewQuery(cmd, "key", "2"), nil
+	case compute.VAddCommand:
+		return compute.NewQuery(cmd, "index", "key", "1", "0.5"), nil
+	case compute.VSearchCommand:
+		return compute.NewQuery(cmd, "index", "COSINE", "2", "", "1", "0.5"), nil
+	case compute.LockCommand, compute.RefreshCommand:
+		return compute.NewQuery(cmd, "name", "owner", "10s"), nil
+	case compute.UnlockCommand:
+		return compute.NewQuery(cmd, "name", "owner"), nil
+	case compute.ThrottleCommand:
+		return compute.NewQuery(cmd, "key", "15", "30", "60s"), nil
+	case compute.EnqueueCommand:
+		return compute.NewQuery(cmd, "queue", "payload", "0s", "0"), nil
+	case compute.DequeueCommand:
+		return compute.NewQuery(cmd, "queue", "0s"), nil
+	case compute.AckCommand, compute.NackCommand:
+		return compute.NewQuery(cmd, "queue", "7.1"), nil
 	}
 
 	return compute.Query{}, errors.New("some error")
@@ -57,3 +92,98 @@ func (m *MockStorageLayer) Get(key string) (string, error) {
 func (m *MockStorageLayer) Set(key, value string) error {
 	return nil
 }
+
+// Exists mocks method
+func (m *MockStorageLayer) Exists(key string) (bool, error) {
+	return true, nil
+}
+
+// Type mocks method
+func (m *MockStorageLayer) Type(key string) (string, error) {
+	return "string", nil
+}
+
+// Rename mocks method
+func (m *MockStorageLayer) Rename(source, destination string) error {
+	return nil
+}
+
+// RenameNX mocks method
+func (m *MockStorageLayer) RenameNX(source, destination string) (bool, error) {
+	return false, nil
+}
+
+// Copy mocks method
+func (m *MockStorageLayer) Copy(source, destination string, replace bool) (bool, error) {
+	return replace, nil
+}
+
+// Expire mocks method
+func (m *MockStorageLayer) Expire(key string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+// Size mocks method
+func (m *MockStorageLayer) Size() (int, error) {
+	return 3, nil
+}
+
+// Range mocks method
+func (m *MockStorageLayer) Range(start, end string, limit int) ([]storage.KeyValue, error) {
+	return []storage.KeyValue{{Key: "key1", Value: "value1"}, {Key: "key2", Value: "value2"}}, nil
+}
+
+// Prefix mocks method
+func (m *MockStorageLayer) Prefix(prefix string, limit int) ([]storage.KeyValue, error) {
+	return []storage.KeyValue{{Key: "key1", Value: "value1"}}, nil
+}
+
+// VAdd mocks method
+func (m *MockStorageLayer) VAdd(index, key string, vector []float32) error {
+	return nil
+}
+
+// VSearch mocks method
+func (m *MockStorageLayer) VSearch(index, metric string, limit int, query []float32, prefix string) ([]vector.Result, error) {
+	return []vector.Result{{Key: "key1", Score: 1}, {Key: "key2", Score: 0.5}}, nil
+}
+
+// Lock mocks method
+func (m *MockStorageLayer) Lock(name, owner string, ttl time.Duration) (uint64, error) {
+	return 42, nil
+}
+
+// Refresh mocks method
+func (m *MockStorageLayer) Refresh(name, owner string, ttl time.Duration) error {
+	return nil
+}
+
+// Unlock mocks method
+func (m *MockStorageLayer) Unlock(name, owner string) error {
+	return nil
+}
+
+// Throttle mocks method
+func (m *MockStorageLayer) Throttle(key string, limit throttle.Limit) (throttle.Result, error) {
+	return throttle.Result{Allowed: false, Remaining: 0, RetryAfter: 2 * time.Second}, nil
+}
+
+// Enqueue mocks method
+func (m *MockStorageLayer) Enqueue(queueName, payload string, delay time.Duration, maxAttempts int) (uint64, error) {
+	return 7, nil
+}
+
+// Dequeue mocks method
+func (m *MockStorageLayer) Dequeue(queueName string, visibility time.Duration) (queue.Lease, error) {
+	return queue.Lease{Job: queue.Job{ID: 7, Payload: "payload", Attempts: 1}, Receipt: "7.1"}, nil
+}
+
+// Ack mocks method
+func (m *MockStorageLayer) Ack(queueName, receipt string) error {
+	return nil
+}
+
+// Nack mocks method
+func (m *MockStorageLayer) Nack(queueName, receipt string) error {
+	return nil
+}
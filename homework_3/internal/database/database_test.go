@@ -1,11 +1,14 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"testing"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/wal"
 )
 
 func TestNewDatabase(t *testing.T) {
@@ -103,6 +106,139 @@ func TestDatabase_HandleQuery(t *testing.T) {
 			isValid:       true,
 			expectedError: nil,
 		},
+		{
+			name:          "Database HandleQuery EXISTS command",
+			cmd:           compute.ExistsCommand,
+			response:      "[ok] 1",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery EXPIRE command",
+			cmd:           compute.ExpireCommand,
+			response:      "[ok] 1",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery TYPE command",
+			cmd:           compute.TypeCommand,
+			response:      "[ok] string",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery RENAME command",
+			cmd:           compute.RenameCommand,
+			response:      "[ok]",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery RENAMENX command",
+			cmd:           compute.RenameNXCommand,
+			response:      "[ok] 0",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery COPY command",
+			cmd:           compute.CopyCommand,
+			response:      "[ok] 1",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery DBSIZE command",
+			cmd:           compute.DBSizeCommand,
+			response:      "[ok] 3",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery RANGE command",
+			cmd:           compute.RangeCommand,
+			response:      "[ok] key1 value1 key2 value2",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery PREFIX command",
+			cmd:           compute.PrefixCommand,
+			response:      "[ok] key1 value1",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery VADD command",
+			cmd:           compute.VAddCommand,
+			response:      "[ok]",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery VSEARCH command",
+			cmd:           compute.VSearchCommand,
+			response:      "[ok] key1:1 key2:0.5",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery LOCK command",
+			cmd:           compute.LockCommand,
+			response:      "[ok] 42",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery REFRESH command",
+			cmd:           compute.RefreshCommand,
+			response:      "[ok]",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery UNLOCK command",
+			cmd:           compute.UnlockCommand,
+			response:      "[ok]",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery THROTTLE command",
+			cmd:           compute.ThrottleCommand,
+			response:      "[ok] 0 0 2s",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery ENQUEUE command",
+			cmd:           compute.EnqueueCommand,
+			response:      "[ok] 7",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery DEQUEUE command",
+			cmd:           compute.DequeueCommand,
+			response:      "[ok] 7.1 payload",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery ACK command",
+			cmd:           compute.AckCommand,
+			response:      "[ok]",
+			isValid:       true,
+			expectedError: nil,
+		},
+		{
+			name:          "Database HandleQuery NACK command",
+			cmd:           compute.NackCommand,
+			response:      "[ok]",
+			isValid:       true,
+			expectedError: nil,
+		},
 		{
 			name:          "Database HandleQuery invalid command",
 			cmd:           "",
@@ -138,3 +274,40 @@ func TestDatabase_HandleQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestDatabase_HandleQuery_LSN(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir()}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	storageLayer, err := storage.NewStorage(storage.NewMockEngine(), writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parser, _ := compute.NewParser(logger)
+	database, _ := NewDatabase(parser, storageLayer, logger)
+
+	tests := []struct {
+		request  string
+		response string
+	}{
+		{request: "SET key value", response: "[ok]"},
+		{request: "SET key value LSN", response: "[ok] [lsn 2]"},
+		{request: "DEL key ASYNC LSN", response: "[ok] [lsn 3]"},
+		{request: "LOCK name owner 1m LSN", response: "[ok] 1 [lsn 4]"},
+		{request: "ENQUEUE jobs payload ASYNC LSN", response: "[ok] 1 [lsn 5]"},
+		{request: "DEQUEUE jobs LSN", response: "[ok] 1.1 payload [lsn 6]"},
+		{request: "VADD index key 1 2 LSN", response: "[ok] [lsn 7]"},
+	}
+
+	for _, test := range tests {
+		if response, err := database.HandleQuery(test.request); err != nil || response != test.response {
+			t.Errorf("want %+v; got %+v, %+v", test.response, response, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// Allocate reserves disk blocks for the file up to the size, the reserved space reads as zeros
+func Allocate(file *os.File, size int64) error {
+	return syscall.Fallocate(int(file.Fd()), 0, 0, size)
+}
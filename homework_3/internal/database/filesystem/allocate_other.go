@@ -0,0 +1,15 @@
+//go:build !linux
+
+package filesystem
+
+import "os"
+
+// Allocate extends the file with zeros up to the size, fallocate is not available on this platform
+func Allocate(file *os.File, size int64) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() >= size {
+		return err
+	}
+
+	return file.Truncate(size)
+}
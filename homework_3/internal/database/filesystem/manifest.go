@@ -0,0 +1,59 @@
+package filesystem
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// The manifest holds the magic, the version, the id of the last created segment and CRC32C of them,
+// it is replaced atomically whenever a segment is created so that ids of removed segments are not reused
+const (
+	manifestExtension = ".manifest"
+	manifestMagic     = "WALM"
+	manifestVersion   = 1
+	manifestSize      = len(manifestMagic) + 2 + 8 + 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// readManifest returns the id of the last created segment, zero if there is no manifest
+func readManifest(filename string) (int64, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read segment manifest: %w", err)
+	}
+
+	if len(data) != manifestSize || string(data[:len(manifestMagic)]) != manifestMagic {
+		return 0, errors.New("segment manifest is damaged: invalid header")
+	}
+
+	if v := binary.LittleEndian.Uint16(data[len(manifestMagic):]); v != manifestVersion {
+		return 0, fmt.Errorf("segment manifest version %d is not supported", v)
+	}
+
+	if crc32.Checksum(data[:manifestSize-4], castagnoli) != binary.LittleEndian.Uint32(data[manifestSize-4:]) {
+		return 0, errors.New("segment manifest is damaged: checksum mismatch")
+	}
+
+	return int64(binary.LittleEndian.Uint64(data[len(manifestMagic)+2:])), nil
+}
+
+// writeManifest atomically replaces the manifest with the id of the last created segment
+func writeManifest(filename string, last int64) error {
+	data := make([]byte, manifestSize)
+	copy(data, manifestMagic)
+	binary.LittleEndian.PutUint16(data[len(manifestMagic):], manifestVersion)
+	binary.LittleEndian.PutUint64(data[len(manifestMagic)+2:], uint64(last))
+	binary.LittleEndian.PutUint32(data[manifestSize-4:], crc32.Checksum(data[:manifestSize-4], castagnoli))
+
+	if err := WriteFileAtomic(filename, data); err != nil {
+		return fmt.Errorf("failed to write segment manifest: %w", err)
+	}
+
+	return nil
+}
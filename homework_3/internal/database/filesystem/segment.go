@@ -1,39 +1,76 @@
 package filesystem
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
-	"time"
+	"sync"
 )
 
-var now = time.Now
+const (
+	walName      = "wal"
+	walExtension = ".log"
+
+	// recycledDir - zeroed removed segments kept for reuse by new segments of preallocated WAL
+	recycledDir         = "recycled"
+	maxRecycledSegments = 4
+)
 
 type Segment struct {
+	mutex     sync.Mutex
 	file      *os.File
 	directory string
+	// segments are named <name>_<id><extension> and listed by nameRe
+	name      string
+	extension string
+	nameRe    *regexp.Regexp
+	// id - sequence number of the current segment, last - of the last created one,
+	// new segments follow the last one recorded in the manifest
+	id   int64
+	last int64
 
 	segmentSize    int
 	maxSegmentSize int
+	// header - written at the start of every new segment
+	header []byte
+	// preallocate - new segments reserve max segment size on disk, removed segments are recycled
+	preallocate bool
 }
 
 func NewSegment(directory string, maxSegmentSize int) *Segment {
+	return NewNamedSegment(directory, walName, walExtension, maxSegmentSize)
+}
+
+// NewNamedSegment - segments named <name>_<id><extension>, ids of created segments are recorded
+// in the <name>.manifest file of the directory
+func NewNamedSegment(directory, name, extension string, maxSegmentSize int) *Segment {
 	return &Segment{
 		directory:      directory,
+		name:           name,
+		extension:      extension,
+		nameRe:         regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `_(\d+)` + regexp.QuoteMeta(extension) + `$`),
 		maxSegmentSize: maxSegmentSize,
 	}
 }
 
+// Write appends the data to the current segment, the data is durable once the segment is synced
 func (s *Segment) Write(data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if s.file == nil || s.segmentSize >= s.maxSegmentSize {
 		if err := s.createSegment(); err != nil {
 			return fmt.Errorf("failed to create segment file: %w", err)
 		}
 	}
 
-	writtenBytes, err := WriteFile(s.file, data)
+	writtenBytes, err := s.file.Write(data)
 	if err != nil {
 		return fmt.Errorf("failed to write data to segment file: %w", err)
 	}
@@ -42,77 +79,380 @@ func (s *Segment) Write(data []byte) error {
 	return nil
 }
 
-// TODO decompose this function
+// SetHeader sets the data written at the start of segments created after the call
+func (s *Segment) SetHeader(header []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.header = header
+}
+
+// SetPreallocation enables reserving disk space of new segments and recycling removed segments,
+// syncs of preallocated segments do not update the file size, the space after the written data reads as zeros
+func (s *Segment) SetPreallocation(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.preallocate = enabled
+}
+
+// Rotate closes the current segment and starts a new one, it returns the id of the new segment
+func (s *Segment) Rotate() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.createSegment(); err != nil {
+		return 0, fmt.Errorf("failed to create segment file: %w", err)
+	}
+
+	return s.id, nil
+}
+
+// Reserve allocates the id of a segment written outside of the current one, the caller creates
+// the file at its path
+func (s *Segment) Reserve() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.nextID()
+}
+
+// Sync flushes data written to the current segment to disk
+func (s *Segment) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
 
-func (s *Segment) ReadAll() ([][]byte, error) {
+	if err := SyncData(s.file); err != nil {
+		return fmt.Errorf("failed to sync segment file: %w", err)
+	}
+
+	return nil
+}
+
+// Close syncs and closes the file of the current segment, the next write starts a new segment
+func (s *Segment) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.closeFile()
+	s.file = nil
+	return err
+}
+
+// List returns ids of all segments in the order they were written, unrelated files are ignored
+func (s *Segment) List() ([]int64, error) {
 	files, err := os.ReadDir(s.directory)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read WAL directory: %w", err)
+		return nil, fmt.Errorf("failed to read segment directory: %w", err)
 	}
 
-	re := regexp.MustCompile(`wal_(\d+)\.log`)
-	fNames := map[int64]string{}
-	orderedTimestamps := make([]int64, 0, len(files))
+	ids := make([]int64, 0, len(files))
 	for _, file := range files {
-		if !file.IsDir() {
-			fName := file.Name()
-			ts, err := s.extractTimestamp(re, fName)
-			if err != nil {
-				return nil, fmt.Errorf("failed to extract timestamp: %w", err)
-			}
-
-			orderedTimestamps = append(orderedTimestamps, ts)
-			fNames[ts] = fName
+		match := s.nameRe.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
 		}
+
+		id, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment name %s: %w", file.Name(), err)
+		}
+
+		ids = append(ids, id)
 	}
 
-	sort.Slice(orderedTimestamps, func(i, j int) bool {
-		return orderedTimestamps[i] < orderedTimestamps[j]
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
 	})
 
-	rawData := make([][]byte, 0, len(orderedTimestamps))
-	for _, ts := range orderedTimestamps {
-		fullName := fmt.Sprintf("%s/%s", s.directory, fNames[ts])
-		data, err := os.ReadFile(fullName)
-		if err != nil {
-			return nil, err
+	return ids, nil
+}
+
+func (s *Segment) Read(id int64) ([]byte, error) {
+	return os.ReadFile(s.Path(id))
+}
+
+// Reopen continues writing the segment after the size on restart, it reports whether the segment
+// has been reopened: it must start with the current header, have space left and no segment must be written yet
+func (s *Segment) Reopen(id int64, size int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file != nil || size < len(s.header) || size >= s.maxSegmentSize {
+		return false, nil
+	}
+
+	if err := s.loadLast(); err != nil {
+		return false, err
+	}
+
+	file, err := os.OpenFile(s.Path(id), os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+
+	header := make([]byte, len(s.header))
+	if _, err = io.ReadFull(file, header); err != nil || !bytes.Equal(header, s.header) {
+		_ = file.Close()
+		return false, err
+	}
+
+	if _, err = file.Seek(int64(size), io.SeekStart); err != nil {
+		_ = file.Close()
+		return false, err
+	}
+
+	s.file = file
+	s.id = id
+	s.last = max(s.last, id)
+	s.segmentSize = size
+	return true, nil
+}
+
+// Remove deletes the segment, the current segment cannot be removed,
+// with preallocation the segment is zeroed and kept for reuse
+func (s *Segment) Remove(id int64) error {
+	s.mutex.Lock()
+	if s.file != nil && id == s.id {
+		s.mutex.Unlock()
+		return fmt.Errorf("failed to remove current segment %d", id)
+	}
+
+	preallocate := s.preallocate
+	s.mutex.Unlock()
+
+	if !preallocate {
+		return os.Remove(s.Path(id))
+	}
+
+	return s.recycle(id)
+}
+
+// recycle zeroes the segment outside of the lock not to block writes and moves it to recycled segments,
+// a zeroed segment left by a crash before the move reads as an empty one
+func (s *Segment) recycle(id int64) error {
+	recycled, err := s.recycled()
+	if err != nil {
+		return err
+	}
+
+	if len(recycled) >= maxRecycledSegments {
+		return os.Remove(s.Path(id))
+	}
+
+	file, err := os.OpenFile(s.Path(id), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	err = zero(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to zero segment %d: %w", id, err)
+	}
+
+	if err = os.MkdirAll(s.recycledDir(), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(s.Path(id), filepath.Join(s.recycledDir(), filepath.Base(s.Path(id))))
+}
+
+// recycled returns paths of recycled segments
+func (s *Segment) recycled() ([]string, error) {
+	files, err := os.ReadDir(s.recycledDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read recycled segments: %w", err)
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && s.nameRe.MatchString(file.Name()) {
+			paths = append(paths, filepath.Join(s.recycledDir(), file.Name()))
 		}
+	}
 
-		rawData = append(rawData, data)
+	return paths, nil
+}
+
+// Truncate cuts the segment at the size and returns the number of bytes cut off,
+// the current segment cannot be truncated
+func (s *Segment) Truncate(id int64, size int) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file != nil && id == s.id {
+		return 0, fmt.Errorf("failed to truncate current segment %d", id)
 	}
 
-	return rawData, nil
+	file, err := os.OpenFile(s.Path(id), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = file.Truncate(int64(size)); err != nil {
+		return 0, err
+	}
+
+	if err = file.Sync(); err != nil {
+		return 0, err
+	}
+
+	return info.Size() - int64(size), nil
 }
 
 func (s *Segment) createSegment() error {
-	segmentName := fmt.Sprintf("%s/wal_%d.log", s.directory, now().UnixMilli())
+	id, err := s.nextID()
+	if err != nil {
+		return err
+	}
+
 	if s.file != nil {
-		err := s.file.Close()
-		if err != nil {
+		if err := s.closeFile(); err != nil {
 			return err
 		}
 	}
 
-	file, err := CreateFile(segmentName)
+	file, err := s.openFile(id)
 	if err != nil {
 		return err
 	}
 
 	s.file = file
+	s.id = id
 	s.segmentSize = 0
+	if len(s.header) == 0 {
+		return nil
+	}
+
+	writtenBytes, err := s.file.Write(s.header)
+	if err != nil {
+		return err
+	}
+
+	s.segmentSize = writtenBytes
 	return nil
 }
 
-func (s *Segment) extractTimestamp(re *regexp.Regexp, filename string) (int64, error) {
-	match := re.FindStringSubmatch(filename)
-	if len(match) <= 1 {
-		return 0, fmt.Errorf("failed to extract timestamp from file %s", filename)
+// nextID allocates the id following the last created segment, the manifest is updated first
+// so that the id is not reused even if the segment is lost
+func (s *Segment) nextID() (int64, error) {
+	if err := s.loadLast(); err != nil {
+		return 0, err
 	}
 
-	timestamp, err := strconv.ParseInt(match[1], 10, 64)
-	if err != nil {
+	id := s.last + 1
+	if err := writeManifest(s.manifestPath(), id); err != nil {
 		return 0, err
 	}
 
-	return timestamp, nil
+	s.last = id
+	return id, nil
+}
+
+// loadLast reads the id of the last created segment from the manifest once, segments written
+// before the manifest existed are listed
+func (s *Segment) loadLast() error {
+	if s.last != 0 {
+		return nil
+	}
+
+	last, err := readManifest(s.manifestPath())
+	if err != nil {
+		return err
+	}
+
+	ids, err := s.List()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if len(ids) != 0 {
+		last = max(last, ids[len(ids)-1])
+	}
+
+	s.last = last
+	return nil
+}
+
+// openFile creates the file of the segment, with preallocation a recycled segment is reused
+// and the disk space is reserved
+func (s *Segment) openFile(id int64) (*os.File, error) {
+	if _, err := os.Lstat(s.Path(id)); !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("segment %d already exists", id)
+	}
+
+	if !s.preallocate {
+		return CreateFile(s.Path(id))
+	}
+
+	recycled, err := s.recycled()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recycled) != 0 {
+		if err = os.Rename(recycled[0], s.Path(id)); err != nil {
+			return nil, fmt.Errorf("failed to reuse recycled segment: %w", err)
+		}
+
+		// the segment must not stay among recycled ones after a crash
+		if err = SyncDir(s.directory); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := CreateFile(s.Path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = Allocate(file, int64(s.maxSegmentSize)); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to preallocate segment: %w", err)
+	}
+
+	return file, nil
+}
+
+// closeFile syncs data of the segment written since the last sync and closes it
+func (s *Segment) closeFile() error {
+	if err := SyncData(s.file); err != nil {
+		_ = s.file.Close()
+		return err
+	}
+
+	return s.file.Close()
+}
+
+func (s *Segment) recycledDir() string {
+	return filepath.Join(s.directory, recycledDir)
+}
+
+func (s *Segment) manifestPath() string {
+	return filepath.Join(s.directory, s.name+manifestExtension)
+}
+
+// Path returns the path of the segment file
+func (s *Segment) Path(id int64) string {
+	return filepath.Join(s.directory, fmt.Sprintf("%s_%d%s", s.name, id, s.extension))
 }
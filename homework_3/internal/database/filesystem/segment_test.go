@@ -1,9 +1,10 @@
 package filesystem
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
-	"time"
 )
 
 func TestSegmentWrite(t *testing.T) {
@@ -23,10 +24,6 @@ func TestSegmentWrite(t *testing.T) {
 	const maxSegmentSize = 10
 	segment := NewSegment(testWALDirectory, maxSegmentSize)
 
-	now = func() time.Time {
-		return time.Unix(1, 0)
-	}
-
 	err = segment.Write([]byte("01234"))
 	if err != nil {
 		t.Errorf("cannot write test data: %s", err)
@@ -41,10 +38,6 @@ func TestSegmentWrite(t *testing.T) {
 		t.Errorf("cannot write test data: %s", err)
 	}
 
-	now = func() time.Time {
-		return time.Unix(2, 0)
-	}
-
 	err = segment.Write([]byte("aaaaa"))
 	if err != nil {
 		t.Errorf("cannot write test data: %s", err)
@@ -54,18 +47,18 @@ func TestSegmentWrite(t *testing.T) {
 		t.Errorf("wrong segment size: wannt 5, got %d", segment.segmentSize)
 	}
 
-	stat, err := os.Stat(testWALDirectory + "/wal_1000.log")
+	stat, err := os.Stat(testWALDirectory + "/wal_1.log")
 	if err != nil {
-		t.Errorf("cannot get file info [%s]", testWALDirectory+"/wal_1000.log")
+		t.Errorf("cannot get file info [%s]", testWALDirectory+"/wal_1.log")
 	}
 
 	if stat.Size() != 10 {
 		t.Errorf("wrong file size: wannt 10, got %d", stat.Size())
 	}
 
-	stat, err = os.Stat(testWALDirectory + "/wal_2000.log")
+	stat, err = os.Stat(testWALDirectory + "/wal_2.log")
 	if err != nil {
-		t.Errorf("cannot get file info [%s]", testWALDirectory+"/wal_2000.log")
+		t.Errorf("cannot get file info [%s]", testWALDirectory+"/wal_2.log")
 	}
 
 	if stat.Size() != 5 {
@@ -74,16 +67,36 @@ func TestSegmentWrite(t *testing.T) {
 
 	err = segment.file.Close()
 	if err != nil {
-		t.Errorf("cannot close segment file [%s]", testWALDirectory+"/wal_2000.log")
+		t.Errorf("cannot close segment file [%s]", testWALDirectory+"/wal_2.log")
+	}
+}
+
+// readSegments reads segments one by one in the order they were written
+func readSegments(segment *Segment) ([][]byte, error) {
+	ids, err := segment.List()
+	if err != nil {
+		return nil, err
 	}
+
+	var segments [][]byte
+	for _, id := range ids {
+		data, err := segment.Read(id)
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, data)
+	}
+
+	return segments, nil
 }
 
-func TestReadAll(t *testing.T) {
+func TestListRead(t *testing.T) {
 	expectedSegmentsCount := 3
 	testDir := "test_data"
 	segment := NewSegment(testDir, 10)
 
-	data, err := segment.ReadAll()
+	data, err := readSegments(segment)
 	if err != nil {
 		t.Errorf("cannot read segmets data from [%s]", testDir)
 	}
@@ -104,3 +117,279 @@ func TestReadAll(t *testing.T) {
 		t.Errorf("wrong segment data: wannt %s, got %s", "0123", string(data[0]))
 	}
 }
+
+func TestSegmentRotateAndRemove(t *testing.T) {
+	testWALDirectory := t.TempDir()
+	segment := NewSegment(testWALDirectory, 10)
+
+	if err := segment.Write([]byte("0123")); err != nil {
+		t.Fatalf("cannot write test data: %s", err)
+	}
+
+	id, err := segment.Rotate()
+	if err != nil || id != 2 {
+		t.Fatalf("want new segment 2; got %d, %s", id, err)
+	}
+
+	if err = segment.Remove(id); err == nil {
+		t.Errorf("want current segment not removed")
+	}
+
+	if _, err = segment.Truncate(id, 0); err == nil {
+		t.Errorf("want current segment not truncated")
+	}
+
+	if discarded, err := segment.Truncate(1, 1); err != nil || discarded != 3 {
+		t.Errorf("want 3 bytes discarded; got %d, %v", discarded, err)
+	}
+
+	if data, _ := segment.Read(1); string(data) != "0" {
+		t.Errorf("want segment truncated; got %q", data)
+	}
+
+	if err = segment.Remove(1); err != nil {
+		t.Errorf("cannot remove segment: %s", err)
+	}
+
+	ids, err := segment.List()
+	if err != nil || len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("want only segment 2; got %+v, %s", ids, err)
+	}
+
+	if err = segment.Close(); err != nil {
+		t.Errorf("cannot close segment file: %s", err)
+	}
+
+	if err = segment.Remove(2); err != nil {
+		t.Errorf("want closed segment removed; got %s", err)
+	}
+}
+
+func TestSegmentHeader(t *testing.T) {
+	testWALDirectory := t.TempDir()
+	segment := NewSegment(testWALDirectory, 10)
+	segment.SetHeader([]byte("hd"))
+
+	for _, data := range []string{"0123", "4567", "89"} {
+		if err := segment.Write([]byte(data)); err != nil {
+			t.Fatalf("cannot write test data: %s", err)
+		}
+	}
+
+	// the header counts towards the segment size
+	data, err := readSegments(segment)
+	if err != nil || len(data) != 2 || string(data[0]) != "hd01234567" || string(data[1]) != "hd89" {
+		t.Errorf("want every segment started with header; got %q, %s", data, err)
+	}
+
+	if err = segment.file.Close(); err != nil {
+		t.Errorf("cannot close segment file: %s", err)
+	}
+}
+
+func TestSegmentPreallocation(t *testing.T) {
+	testWALDirectory := t.TempDir()
+	segment := NewSegment(testWALDirectory, 10)
+	segment.SetPreallocation(true)
+
+	if err := segment.Write([]byte("0123")); err != nil {
+		t.Fatalf("cannot write test data: %s", err)
+	}
+
+	// the reserved space after the written data reads as zeros
+	if data, err := segment.Read(1); err != nil || !bytes.Equal(data, []byte("0123\x00\x00\x00\x00\x00\x00")) {
+		t.Errorf("want segment preallocated; got %q, %v", data, err)
+	}
+
+	if _, err := segment.Rotate(); err != nil {
+		t.Fatalf("cannot rotate segment: %s", err)
+	}
+
+	if err := segment.Remove(1); err != nil {
+		t.Fatalf("cannot remove segment: %s", err)
+	}
+
+	recycled := filepath.Join(testWALDirectory, recycledDir, "wal_1.log")
+	if data, err := os.ReadFile(recycled); err != nil || !bytes.Equal(data, make([]byte, 10)) {
+		t.Errorf("want removed segment zeroed and recycled; got %q, %v", data, err)
+	}
+
+	if ids, err := segment.List(); err != nil || len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("want only segment 2; got %+v, %v", ids, err)
+	}
+
+	id, err := segment.Rotate()
+	if err != nil {
+		t.Fatalf("cannot rotate segment: %s", err)
+	}
+
+	if _, err = os.Stat(recycled); !os.IsNotExist(err) {
+		t.Errorf("want recycled segment reused by segment %d; got %v", id, err)
+	}
+
+	if err = segment.Write([]byte("45")); err != nil {
+		t.Fatalf("cannot write test data: %s", err)
+	}
+
+	if data, err := segment.Read(id); err != nil || !bytes.Equal(data, []byte("45\x00\x00\x00\x00\x00\x00\x00\x00")) {
+		t.Errorf("want recycled segment overwritten from the start; got %q, %v", data, err)
+	}
+
+	if err = segment.Close(); err != nil {
+		t.Errorf("cannot close segment file: %s", err)
+	}
+}
+
+// BenchmarkSegmentWrite measures a synced write of a batch, preallocated segments do not update
+// the file size on every sync
+func BenchmarkSegmentWrite(b *testing.B) {
+	batch := bytes.Repeat([]byte{1}, 256)
+
+	for _, preallocate := range []bool{false, true} {
+		name := "Growing"
+		if preallocate {
+			name = "Preallocated"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			segment := NewSegment(b.TempDir(), 16<<20)
+			segment.SetPreallocation(preallocate)
+			defer segment.Close()
+
+			for i := 0; i < b.N; i++ {
+				if err := segment.Write(batch); err != nil {
+					b.Fatal(err)
+				}
+
+				if err := segment.Sync(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSegmentManifest(t *testing.T) {
+	testWALDirectory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(testWALDirectory, "unrelated.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := NewSegment(testWALDirectory, 10)
+	if err := segment.Write([]byte("0123")); err != nil {
+		t.Fatalf("cannot write test data: %s", err)
+	}
+
+	if _, err := segment.Rotate(); err != nil {
+		t.Fatalf("cannot rotate segment: %s", err)
+	}
+
+	if err := segment.Close(); err != nil {
+		t.Fatalf("cannot close segment file: %s", err)
+	}
+
+	ids, err := segment.List()
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("want unrelated files ignored; got %+v, %v", ids, err)
+	}
+
+	for _, id := range ids {
+		if err = segment.Remove(id); err != nil {
+			t.Fatalf("cannot remove segment: %s", err)
+		}
+	}
+
+	// ids of removed segments are not reused after restart
+	restarted := NewSegment(testWALDirectory, 10)
+	if id, err := restarted.Rotate(); err != nil || id != 3 {
+		t.Errorf("want new segment 3; got %d, %v", id, err)
+	}
+
+	if err = restarted.Close(); err != nil {
+		t.Errorf("cannot close segment file: %s", err)
+	}
+}
+
+func TestSegmentReserve(t *testing.T) {
+	testDirectory := t.TempDir()
+	segment := NewNamedSegment(testDirectory, "data", ".data", 10)
+	if err := segment.Write([]byte("0123")); err != nil {
+		t.Fatalf("cannot write test data: %s", err)
+	}
+
+	reserved, err := segment.Reserve()
+	if err != nil || reserved != 2 {
+		t.Fatalf("want reserved segment 2; got %d, %v", reserved, err)
+	}
+
+	if err = os.WriteFile(segment.Path(reserved), []byte("merged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := segment.Rotate(); err != nil || id != 3 {
+		t.Errorf("want new segment 3; got %d, %v", id, err)
+	}
+
+	if err = segment.Close(); err != nil {
+		t.Fatalf("cannot close segment file: %s", err)
+	}
+
+	ids, err := segment.List()
+	if err != nil || len(ids) != 3 {
+		t.Errorf("want 3 segments; got %+v, %v", ids, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(testDirectory, "data_1.data")); err != nil {
+		t.Errorf("want segment named by the name and extension; got %+v", err)
+	}
+}
+
+func TestSegmentReopen(t *testing.T) {
+	testWALDirectory := t.TempDir()
+	segment := NewSegment(testWALDirectory, 10)
+	segment.SetHeader([]byte("hd"))
+
+	if err := segment.Write([]byte("0123")); err != nil {
+		t.Fatalf("cannot write test data: %s", err)
+	}
+
+	if err := segment.Close(); err != nil {
+		t.Fatalf("cannot close segment file: %s", err)
+	}
+
+	tests := []struct {
+		name             string
+		header           string
+		size             int
+		expectedReopened bool
+		expectedData     string
+	}{
+		{name: "Different header", header: "HD", size: 6, expectedData: "hd0123"},
+		{name: "Full segment", header: "hd", size: 10, expectedData: "hd0123"},
+		{name: "After the last record", header: "hd", size: 4, expectedReopened: true, expectedData: "hd0145"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restarted := NewSegment(testWALDirectory, 10)
+			restarted.SetHeader([]byte(test.header))
+
+			reopened, err := restarted.Reopen(1, test.size)
+			if err != nil || reopened != test.expectedReopened {
+				t.Fatalf("want %+v; got %+v, %v", test.expectedReopened, reopened, err)
+			}
+
+			if err = restarted.Write([]byte("45")); err != nil {
+				t.Fatalf("cannot write test data: %s", err)
+			}
+
+			if err = restarted.Close(); err != nil {
+				t.Fatalf("cannot close segment file: %s", err)
+			}
+
+			if data, _ := restarted.Read(1); string(data) != test.expectedData {
+				t.Errorf("want %q; got %q", test.expectedData, data)
+			}
+		})
+	}
+}
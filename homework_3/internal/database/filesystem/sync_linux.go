@@ -0,0 +1,13 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// SyncData flushes the file data to disk, metadata not needed to read the data is not flushed
+func SyncData(file *os.File) error {
+	return syscall.Fdatasync(int(file.Fd()))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package filesystem
+
+import "os"
+
+// SyncData flushes the file to disk, fdatasync is not available on this platform
+func SyncData(file *os.File) error {
+	return file.Sync()
+}
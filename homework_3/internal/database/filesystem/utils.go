@@ -1,6 +1,9 @@
 package filesystem
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+)
 
 func CreateFile(filename string) (*os.File, error) {
 	flags := os.O_CREATE | os.O_WRONLY
@@ -24,3 +27,59 @@ func WriteFile(file *os.File, data []byte) (int, error) {
 
 	return writtenBytes, nil
 }
+
+// SyncDir flushes the directory entries so that created, renamed and removed files survive a crash
+func SyncDir(directory string) error {
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	if err = dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+
+	return dir.Close()
+}
+
+// WriteFileAtomic replaces the file by renaming a synced temporary file over it
+func WriteFileAtomic(filename string, data []byte) error {
+	temporary := filename + ".tmp"
+	file, err := os.OpenFile(temporary, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = WriteFile(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(temporary, filename); err != nil {
+		return err
+	}
+
+	return SyncDir(filepath.Dir(filename))
+}
+
+// zero overwrites the whole file with zeros and syncs it
+func zero(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	zeros := make([]byte, 64*1024)
+	for offset := int64(0); offset < info.Size(); offset += int64(len(zeros)) {
+		if _, err = file.WriteAt(zeros[:min(int64(len(zeros)), info.Size()-offset)], offset); err != nil {
+			return err
+		}
+	}
+
+	return SyncData(file)
+}
@@ -0,0 +1,453 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+const BitcaskEngine = "bitcask"
+
+const (
+	defaultDirectory    = "data/bitcask"
+	defaultDataFileSize = 64 << 20
+
+	dataName       = "bitcask"
+	dataSuffix     = ".data"
+	hintSuffix     = ".hint"
+	checkpointName = "CHECKPOINT"
+)
+
+// location - where the latest value of a key is stored
+type location struct {
+	fileID int64
+	record record
+	offset int64
+}
+
+type dataFile struct {
+	reader *os.File
+	// total and dead bytes of records, dead records are dropped by merge
+	total int64
+	dead  int64
+}
+
+// Engine - Bitcask: values are appended to data files and the keydir maps every key to its latest record
+type Engine struct {
+	logger       *common.Logger
+	directory    string
+	maxFileSize  int64
+	checkpointer storage.Checkpointer
+
+	m      sync.RWMutex
+	keydir map[string]location
+	files  map[int64]*dataFile
+	// segment - writes the active data file, data files are rotated by the engine only
+	// so the size of the segment is not limited
+	segment    *filesystem.Segment
+	activeID   int64
+	activeSize int64
+	sequence   uint64
+
+	// maintenance - serializes rotations and merges
+	maintenance sync.Mutex
+	checkpoint  int64
+	rotations   chan struct{}
+
+	// stop - closed by Close, background rotations end and mark background done
+	stop       chan struct{}
+	background sync.WaitGroup
+}
+
+func NewEngine(directory string, maxFileSize int, logger *common.Logger) (*Engine, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	if directory == "" {
+		directory = defaultDirectory
+	}
+
+	if maxFileSize <= 0 {
+		maxFileSize = defaultDataFileSize
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create engine directory: %w", err)
+	}
+
+	engine := &Engine{
+		logger:       logger,
+		directory:    directory,
+		maxFileSize:  int64(maxFileSize),
+		checkpointer: storage.NoCheckpointer{},
+		keydir:       make(map[string]location),
+		files:        make(map[int64]*dataFile),
+		segment:      filesystem.NewNamedSegment(directory, dataName, dataSuffix, math.MaxInt),
+		rotations:    make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+
+	if err := engine.load(); err != nil {
+		_ = engine.closeFiles()
+		return nil, err
+	}
+
+	if err := engine.openActive(); err != nil {
+		_ = engine.closeFiles()
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+// SetCheckpointer makes data file rotations consistent with WAL segments
+func (e *Engine) SetCheckpointer(checkpointer storage.Checkpointer) {
+	e.m.Lock()
+	e.checkpointer = checkpointer
+	e.m.Unlock()
+}
+
+// LastCheckpoint returns the checkpoint of the last rotation, key changes logged to WAL segments before it
+// are in synced data files
+func (e *Engine) LastCheckpoint() int64 {
+	e.maintenance.Lock()
+	defer e.maintenance.Unlock()
+
+	return e.checkpoint
+}
+
+// Start launches background rotations of full data files followed by merges
+func (e *Engine) Start() {
+	e.background.Add(1)
+	go func() {
+		defer e.background.Done()
+
+		for {
+			select {
+			case <-e.rotations:
+			case <-e.stop:
+				return
+			}
+
+			if err := e.rotate(); err != nil {
+				e.logger.Error("failed to rotate data file: %s", err)
+				continue
+			}
+
+			if e.fragmented() {
+				if err := e.Merge(); err != nil {
+					e.logger.Error("failed to merge data files: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+func (e *Engine) Set(key, value string) error {
+	if err := e.append(key, value, false); err != nil {
+		e.logger.Error("SET query [key %s]: %s", key, err)
+		return err
+	}
+
+	e.logger.Debug("successful SET query [key %s, value %s]", key, value)
+	return nil
+}
+
+func (e *Engine) Get(key string) (string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	loc, ok := e.keydir[key]
+	if !ok {
+		e.logger.Debug("GET query [key %s]: key not found", key)
+		return "", storage.ErrNotFound
+	}
+
+	value := make([]byte, loc.record.valueLength)
+	valueOffset := loc.offset + recordHeaderSize + int64(len(key))
+	if _, err := e.files[loc.fileID].reader.ReadAt(value, valueOffset); err != nil {
+		e.logger.Error("GET query [key %s]: %s", key, err)
+		return "", err
+	}
+
+	e.logger.Info("successful GET query [key %s, value %s]", key, value)
+	return string(value), nil
+}
+
+// Del appends a tombstone so that older records of the key are not loaded at startup
+func (e *Engine) Del(key string) error {
+	if err := e.append(key, "", true); err != nil {
+		e.logger.Error("DEL query [key %s]: %s", key, err)
+		return err
+	}
+
+	e.logger.Debug("successful DEL query [key %s]", key)
+	return nil
+}
+
+func (e *Engine) append(key, value string, deleted bool) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.sequence++
+	data := encodeRecord(e.sequence, key, value, deleted)
+	if err := e.segment.Write(data); err != nil {
+		return err
+	}
+
+	loc := location{
+		fileID: e.activeID,
+		record: record{sequence: e.sequence, deleted: deleted, key: key, valueLength: uint32(len(value))},
+		offset: e.activeSize,
+	}
+	e.activeSize += int64(len(data))
+
+	file := e.files[e.activeID]
+	file.total += int64(len(data))
+	if old, ok := e.keydir[key]; ok {
+		e.files[old.fileID].dead += old.record.size()
+	}
+
+	if deleted {
+		delete(e.keydir, key)
+		file.dead += int64(len(data))
+	} else {
+		e.keydir[key] = loc
+	}
+
+	if e.activeSize >= e.maxFileSize {
+		select {
+		case e.rotations <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// rotate closes the active data file while no key change is in flight and writes its hint file,
+// the closed file is synced so WAL segments before the checkpoint can be dropped
+func (e *Engine) rotate() error {
+	e.maintenance.Lock()
+	defer e.maintenance.Unlock()
+
+	e.m.RLock()
+	checkpointer := e.checkpointer
+	e.m.RUnlock()
+
+	var closedID int64
+	var switchErr error
+	checkpoint, err := checkpointer.Checkpoint(func() {
+		closedID, switchErr = e.switchActive()
+	})
+	if err != nil {
+		return err
+	}
+
+	if switchErr != nil {
+		return switchErr
+	}
+
+	if checkpoint > e.checkpoint {
+		data := []byte(strconv.FormatInt(checkpoint, 10))
+		if err = filesystem.WriteFileAtomic(filepath.Join(e.directory, checkpointName), data); err != nil {
+			return fmt.Errorf("failed to write checkpoint: %w", err)
+		}
+
+		e.checkpoint = checkpoint
+	}
+
+	if err = e.writeHintFile(closedID); err != nil {
+		e.logger.Error("failed to write hint file for data file %d: %s", closedID, err)
+	}
+
+	if err = checkpointer.Release(checkpoint); err != nil {
+		e.logger.Error("failed to release WAL checkpoint %d: %s", checkpoint, err)
+	}
+
+	e.logger.Debug("data file %d has been rotated [checkpoint %d]", closedID, checkpoint)
+	return nil
+}
+
+func (e *Engine) switchActive() (int64, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	closedID := e.activeID
+	if err := e.openActiveLocked(); err != nil {
+		return 0, err
+	}
+
+	return closedID, nil
+}
+
+func (e *Engine) openActive() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return e.openActiveLocked()
+}
+
+// openActiveLocked starts a new data file, the previous one is synced and closed, the lock must be held
+func (e *Engine) openActiveLocked() error {
+	id, err := e.segment.Rotate()
+	if err != nil {
+		return err
+	}
+
+	reader, err := os.Open(e.dataPath(id))
+	if err != nil {
+		return err
+	}
+
+	if err = filesystem.SyncDir(e.directory); err != nil {
+		_ = reader.Close()
+		return err
+	}
+
+	e.activeID, e.activeSize = id, 0
+	e.files[id] = &dataFile{reader: reader}
+	return nil
+}
+
+// load builds the keydir from hint files or data files, the latest sequence of a key wins
+func (e *Engine) load() error {
+	if err := e.completeMerge(); err != nil {
+		return err
+	}
+
+	checkpoint, err := os.ReadFile(filepath.Join(e.directory, checkpointName))
+	if err == nil {
+		e.checkpoint, err = strconv.ParseInt(string(checkpoint), 10, 64)
+	}
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	ids, err := e.segment.List()
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]location)
+	for _, id := range ids {
+		hints, err := e.readHintFile(id)
+		if err != nil {
+			return err
+		}
+
+		reader, err := os.Open(e.dataPath(id))
+		if err != nil {
+			return err
+		}
+
+		stat, err := reader.Stat()
+		if err != nil {
+			_ = reader.Close()
+			return err
+		}
+
+		e.files[id] = &dataFile{reader: reader, total: stat.Size()}
+
+		for _, h := range hints {
+			e.sequence = max(e.sequence, h.sequence)
+			if current, ok := latest[h.key]; !ok || h.sequence > current.record.sequence {
+				latest[h.key] = location{fileID: id, record: h.record, offset: h.offset}
+			}
+		}
+	}
+
+	live := make(map[int64]int64, len(ids))
+	for key, loc := range latest {
+		if !loc.record.deleted {
+			e.keydir[key] = loc
+			live[loc.fileID] += loc.record.size()
+		}
+	}
+
+	for id, file := range e.files {
+		file.dead = file.total - live[id]
+	}
+
+	e.logger.Debug("bitcask keydir has been loaded [%d keys, %d data files]", len(e.keydir), len(ids))
+	return nil
+}
+
+// readHintFile reads hints of the data file, the data file is scanned if there is no valid hint file
+func (e *Engine) readHintFile(id int64) ([]hint, error) {
+	hints, err := readHints(e.hintPath(id))
+	if err == nil {
+		return hints, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		e.logger.Error("failed to read hint file for data file %d: %s", id, err)
+	}
+
+	hints, truncated, err := scanHints(e.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan data file %d: %w", id, err)
+	}
+
+	if truncated {
+		e.logger.Error("data file %d has been truncated at a corrupted record", id)
+	}
+
+	if err = writeHints(e.hintPath(id), hints); err != nil {
+		e.logger.Error("failed to write hint file for data file %d: %s", id, err)
+	}
+
+	return hints, nil
+}
+
+func (e *Engine) writeHintFile(id int64) error {
+	hints, _, err := scanHints(e.dataPath(id))
+	if err != nil {
+		return err
+	}
+
+	return writeHints(e.hintPath(id), hints)
+}
+
+func (e *Engine) dataPath(id int64) string {
+	return e.segment.Path(id)
+}
+
+func (e *Engine) hintPath(id int64) string {
+	return strings.TrimSuffix(e.dataPath(id), dataSuffix) + hintSuffix
+}
+
+// Close waits for the background rotation or merge in progress and closes data files,
+// it must be called once after the engine is no longer used
+func (e *Engine) Close() error {
+	close(e.stop)
+	e.background.Wait()
+
+	e.maintenance.Lock()
+	defer e.maintenance.Unlock()
+
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return e.closeFiles()
+}
+
+func (e *Engine) closeFiles() error {
+	err := e.segment.Close()
+	for _, file := range e.files {
+		err = errors.Join(err, file.reader.Close())
+	}
+
+	return err
+}
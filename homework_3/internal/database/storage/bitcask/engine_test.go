@@ -0,0 +1,231 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+type testCheckpointer struct {
+	next     int64
+	released []int64
+}
+
+func (c *testCheckpointer) Checkpoint(switchActive func()) (int64, error) {
+	c.next++
+	switchActive()
+	return c.next, nil
+}
+
+func (c *testCheckpointer) Release(checkpoint int64) error {
+	c.released = append(c.released, checkpoint)
+	return nil
+}
+
+func newTestEngine(t *testing.T, directory string) *Engine {
+	t.Helper()
+
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(directory, 1024, logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	t.Cleanup(func() { _ = engine.Close() })
+	return engine
+}
+
+func expectValue(t *testing.T, engine *Engine, key, expected string) {
+	t.Helper()
+
+	value, err := engine.Get(key)
+	if expected == "" {
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("want %s not found; got %s, %+v", key, value, err)
+		}
+		return
+	}
+
+	if err != nil || value != expected {
+		t.Errorf("want %s for %s; got %s, %+v", expected, key, value, err)
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	if _, err := NewEngine(t.TempDir(), 0, nil); err == nil {
+		t.Errorf("want error without logger")
+	}
+
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(t.TempDir(), 0, logger)
+	if err != nil || engine.maxFileSize != defaultDataFileSize {
+		t.Fatalf("want engine with default data file size; got %+v", err)
+	}
+	_ = engine.Close()
+}
+
+func TestEngine_SetGetDel(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+	engine.Set("key_1", "new_value_1")
+	engine.Del("key_2")
+	engine.Del("key_3")
+
+	expectValue(t, engine, "key_1", "new_value_1")
+	expectValue(t, engine, "key_2", "")
+
+	reopened := newTestEngine(t, directory)
+	expectValue(t, reopened, "key_1", "new_value_1")
+	expectValue(t, reopened, "key_2", "")
+
+	if _, err := os.Stat(reopened.hintPath(1)); err != nil {
+		t.Errorf("want hint file written for the scanned data file; got %+v", err)
+	}
+
+	reopened.Set("key_2", "value_2")
+	expectValue(t, reopened, "key_2", "value_2")
+}
+
+func TestEngine_Rotate(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+	checkpointer := &testCheckpointer{}
+	engine.SetCheckpointer(checkpointer)
+
+	engine.Set("key_1", "value_1")
+	if err := engine.rotate(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+	engine.Set("key_2", "value_2")
+
+	if engine.LastCheckpoint() != 1 || len(checkpointer.released) != 1 {
+		t.Errorf("want checkpoint 1 released; got %d, %+v", engine.LastCheckpoint(), checkpointer.released)
+	}
+
+	if _, err := os.Stat(engine.hintPath(1)); err != nil {
+		t.Errorf("want hint file for the rotated data file; got %+v", err)
+	}
+
+	reopened := newTestEngine(t, directory)
+	if reopened.LastCheckpoint() != 1 {
+		t.Errorf("want checkpoint 1 persisted; got %d", reopened.LastCheckpoint())
+	}
+
+	expectValue(t, reopened, "key_1", "value_1")
+	expectValue(t, reopened, "key_2", "value_2")
+}
+
+func TestEngine_TornTail(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+
+	torn := encodeRecord(100, "key_3", "value_3", false)
+	if err := engine.segment.Write(torn[:len(torn)-2]); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestEngine(t, directory)
+	expectValue(t, reopened, "key_1", "value_1")
+	expectValue(t, reopened, "key_2", "value_2")
+	expectValue(t, reopened, "key_3", "")
+
+	stat, err := os.Stat(reopened.dataPath(1))
+	if err != nil || stat.Size() != 2*int64(len(encodeRecord(1, "key_1", "value_1", false))) {
+		t.Errorf("want torn record truncated; got %+v, %+v", stat, err)
+	}
+}
+
+func TestEngine_Merge(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			engine.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d_%d", round, i))
+		}
+		engine.Del(fmt.Sprintf("key_%d", round))
+
+		if err := engine.rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !engine.fragmented() {
+		t.Fatalf("want overwritten data files fragmented")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			engine.Set("key_19", fmt.Sprintf("concurrent_%d", i))
+		}
+	}()
+
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+	wg.Wait()
+
+	check := func(engine *Engine) {
+		expectValue(t, engine, "key_4", "")
+		expectValue(t, engine, "key_0", "value_4_0")
+		expectValue(t, engine, "key_5", "value_4_5")
+		expectValue(t, engine, "key_19", "concurrent_19")
+	}
+	check(engine)
+
+	if engine.fragmented() {
+		t.Errorf("want merged data files without dead records")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(directory, "*"+dataSuffix))
+	if len(files) >= 6 {
+		t.Errorf("want data files merged; got %+v", files)
+	}
+
+	check(newTestEngine(t, directory))
+}
+
+func TestEngine_InterruptedMerge(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+
+	engine.Set("key", "value")
+	if err := engine.rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Del("key")
+	if err := engine.rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the merge wrote no live records and was interrupted before the old files had been removed
+	if err := os.WriteFile(filepath.Join(directory, mergeMarkerName), []byte("1\n2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(engine.dataPath(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestEngine(t, directory)
+	expectValue(t, reopened, "key", "")
+
+	if _, err := os.Stat(engine.dataPath(1)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want merged data file removed; got %+v", err)
+	}
+}
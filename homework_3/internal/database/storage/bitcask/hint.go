@@ -0,0 +1,109 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+)
+
+// Hint file - the keydir part of an immutable data file, loaded at startup instead of scanning the file:
+//
+//	sequence (8) | flags (1) | key length (4) | value length (4) | offset (8) | key
+//
+// followed by crc32 of the whole file
+const hintHeaderSize = 8 + 1 + 4 + 4 + 8
+
+var errCorruptedHint = errors.New("bitcask: corrupted hint file")
+
+// hint - a record of a data file and its offset
+type hint struct {
+	record
+	offset int64
+}
+
+func writeHints(filename string, hints []hint) error {
+	var data []byte
+	for _, h := range hints {
+		var flags byte
+		if h.deleted {
+			flags = tombstoneFlag
+		}
+
+		data = binary.LittleEndian.AppendUint64(data, h.sequence)
+		data = append(data, flags)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(h.key)))
+		data = binary.LittleEndian.AppendUint32(data, h.valueLength)
+		data = binary.LittleEndian.AppendUint64(data, uint64(h.offset))
+		data = append(data, h.key...)
+	}
+
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+	return filesystem.WriteFileAtomic(filename, data)
+}
+
+func readHints(filename string) ([]hint, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 4 {
+		return nil, errCorruptedHint
+	}
+
+	data, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(sum) {
+		return nil, errCorruptedHint
+	}
+
+	var hints []hint
+	for len(data) > 0 {
+		if len(data) < hintHeaderSize {
+			return nil, errCorruptedHint
+		}
+
+		keyLength := int(binary.LittleEndian.Uint32(data[9:]))
+		if len(data) < hintHeaderSize+keyLength {
+			return nil, errCorruptedHint
+		}
+
+		hints = append(hints, hint{
+			record: record{
+				sequence:    binary.LittleEndian.Uint64(data),
+				deleted:     data[8]&tombstoneFlag != 0,
+				key:         string(data[hintHeaderSize : hintHeaderSize+keyLength]),
+				valueLength: binary.LittleEndian.Uint32(data[13:]),
+			},
+			offset: int64(binary.LittleEndian.Uint64(data[17:])),
+		})
+		data = data[hintHeaderSize+keyLength:]
+	}
+
+	return hints, nil
+}
+
+// scanHints reads hints from the data file, the file is truncated at the first corrupted record
+// as it can only be a torn write of a crash
+func scanHints(filename string) ([]hint, bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var hints []hint
+	var offset int64
+	for offset < int64(len(data)) {
+		r, err := decodeRecord(data[offset:])
+		if err != nil {
+			return hints, true, os.Truncate(filename, offset)
+		}
+
+		hints = append(hints, hint{record: r, offset: offset})
+		offset += r.size()
+	}
+
+	return hints, false, nil
+}
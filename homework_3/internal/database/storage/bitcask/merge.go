@@ -0,0 +1,265 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+)
+
+const (
+	// mergeMarkerName - lists data files replaced by a merge, they are removed at startup
+	// if the merge was interrupted after the merged files had been written
+	mergeMarkerName = "MERGE"
+	// mergeRatio - share of dead bytes in immutable data files which triggers a merge
+	mergeRatio = 0.5
+)
+
+// fragmented reports whether enough of the immutable data files is taken by overwritten and deleted records
+func (e *Engine) fragmented() bool {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	var total, dead int64
+	for id, file := range e.files {
+		if id != e.activeID {
+			total += file.total
+			dead += file.dead
+		}
+	}
+
+	return total > 0 && float64(dead) >= mergeRatio*float64(total)
+}
+
+// Merge rewrites live records of the immutable data files into new data files with hint files
+// and removes the old ones, tombstones are dropped as all older records are merged with them
+func (e *Engine) Merge() error {
+	e.maintenance.Lock()
+	defer e.maintenance.Unlock()
+
+	inputs, live := e.mergeInputs()
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	outputs, moved, err := e.writeMerged(live)
+	if err != nil {
+		e.discardMerged(outputs)
+		return err
+	}
+
+	var marker strings.Builder
+	for _, id := range inputs {
+		fmt.Fprintf(&marker, "%d\n", id)
+	}
+
+	markerPath := filepath.Join(e.directory, mergeMarkerName)
+	if err = filesystem.WriteFileAtomic(markerPath, []byte(marker.String())); err != nil {
+		e.discardMerged(outputs)
+		return fmt.Errorf("failed to write merge marker: %w", err)
+	}
+
+	e.replaceMerged(inputs, outputs, moved)
+
+	for _, id := range inputs {
+		e.removeFiles(id)
+	}
+
+	if err = os.Remove(markerPath); err != nil {
+		return err
+	}
+
+	e.logger.Debug("data files have been merged [%d files into %d]", len(inputs), len(outputs))
+	return nil
+}
+
+// mergeInputs returns the immutable data files and locations of live keys stored in them
+func (e *Engine) mergeInputs() ([]int64, []location) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	var inputs []int64
+	for id := range e.files {
+		if id != e.activeID {
+			inputs = append(inputs, id)
+		}
+	}
+
+	sort.Slice(inputs, func(i, j int) bool {
+		return inputs[i] < inputs[j]
+	})
+
+	var live []location
+	for _, loc := range e.keydir {
+		if loc.fileID != e.activeID {
+			live = append(live, loc)
+		}
+	}
+
+	// records are read in the order they are stored
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].fileID != live[j].fileID {
+			return live[i].fileID < live[j].fileID
+		}
+		return live[i].offset < live[j].offset
+	})
+
+	return inputs, live
+}
+
+type mergedFile struct {
+	id     int64
+	writer *os.File
+	reader *os.File
+	size   int64
+	hints  []hint
+}
+
+// writeMerged copies the live records into new data files keeping their sequences,
+// it returns the new location of every copied record by its old location
+func (e *Engine) writeMerged(live []location) ([]*mergedFile, map[location]location, error) {
+	var outputs []*mergedFile
+	moved := make(map[location]location, len(live))
+
+	var current *mergedFile
+	for _, loc := range live {
+		value, err := e.readValue(loc)
+		if err != nil {
+			return outputs, nil, err
+		}
+
+		if current == nil || current.size >= e.maxFileSize {
+			if current, err = e.createMerged(); err != nil {
+				return outputs, nil, err
+			}
+			outputs = append(outputs, current)
+		}
+
+		data := encodeRecord(loc.record.sequence, loc.record.key, value, false)
+		if _, err = current.writer.Write(data); err != nil {
+			return outputs, nil, err
+		}
+
+		moved[loc] = location{fileID: current.id, record: loc.record, offset: current.size}
+		current.hints = append(current.hints, hint{record: loc.record, offset: current.size})
+		current.size += int64(len(data))
+	}
+
+	for _, output := range outputs {
+		if err := output.writer.Sync(); err != nil {
+			return outputs, nil, err
+		}
+
+		if err := output.writer.Close(); err != nil {
+			return outputs, nil, err
+		}
+
+		if err := writeHints(e.hintPath(output.id), output.hints); err != nil {
+			return outputs, nil, err
+		}
+
+		reader, err := os.Open(e.dataPath(output.id))
+		if err != nil {
+			return outputs, nil, err
+		}
+		output.reader = reader
+	}
+
+	return outputs, moved, nil
+}
+
+func (e *Engine) createMerged() (*mergedFile, error) {
+	id, err := e.segment.Reserve()
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := filesystem.CreateFile(e.dataPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mergedFile{id: id, writer: writer}, nil
+}
+
+func (e *Engine) discardMerged(outputs []*mergedFile) {
+	for _, output := range outputs {
+		_ = output.writer.Close()
+		if output.reader != nil {
+			_ = output.reader.Close()
+		}
+		e.removeFiles(output.id)
+	}
+}
+
+// replaceMerged points the keydir to the merged records unless keys have changed during the merge
+func (e *Engine) replaceMerged(inputs []int64, outputs []*mergedFile, moved map[location]location) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	for _, output := range outputs {
+		e.files[output.id] = &dataFile{reader: output.reader, total: output.size}
+	}
+
+	for old, merged := range moved {
+		file := e.files[merged.fileID]
+		if current, ok := e.keydir[old.record.key]; ok && current == old {
+			e.keydir[old.record.key] = merged
+		} else {
+			file.dead += merged.record.size()
+		}
+	}
+
+	for _, id := range inputs {
+		_ = e.files[id].reader.Close()
+		delete(e.files, id)
+	}
+}
+
+func (e *Engine) readValue(loc location) (string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	value := make([]byte, loc.record.valueLength)
+	offset := loc.offset + recordHeaderSize + int64(len(loc.record.key))
+	if _, err := e.files[loc.fileID].reader.ReadAt(value, offset); err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// completeMerge removes data files replaced by a merge interrupted before their removal
+func (e *Engine) completeMerge() error {
+	markerPath := filepath.Join(e.directory, mergeMarkerName)
+	data, err := os.ReadFile(markerPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read merge marker: %w", err)
+	}
+
+	for _, line := range strings.Fields(string(data)) {
+		id, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse merge marker: %w", err)
+		}
+
+		e.removeFiles(id)
+	}
+
+	return os.Remove(markerPath)
+}
+
+func (e *Engine) removeFiles(id int64) {
+	for _, filename := range []string{e.dataPath(id), e.hintPath(id)} {
+		if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			e.logger.Error("failed to remove %s: %s", filename, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// Data file record:
+//
+//	crc32 (4) | sequence (8) | flags (1) | key length (4) | value length (4) | key | value
+//
+// the checksum covers everything after it, the sequence orders records of a key across data files
+const (
+	recordHeaderSize = 4 + 8 + 1 + 4 + 4
+
+	tombstoneFlag = 1
+)
+
+var errCorruptedRecord = errors.New("bitcask: corrupted record")
+
+// record - a decoded data file record, the value is not kept
+type record struct {
+	sequence    uint64
+	deleted     bool
+	key         string
+	valueLength uint32
+}
+
+func (r record) size() int64 {
+	return recordHeaderSize + int64(len(r.key)) + int64(r.valueLength)
+}
+
+func encodeRecord(sequence uint64, key, value string, deleted bool) []byte {
+	data := make([]byte, recordHeaderSize, recordHeaderSize+len(key)+len(value))
+	binary.LittleEndian.PutUint64(data[4:], sequence)
+	if deleted {
+		data[12] = tombstoneFlag
+	}
+	binary.LittleEndian.PutUint32(data[13:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(data[17:], uint32(len(value)))
+	data = append(data, key...)
+	data = append(data, value...)
+
+	binary.LittleEndian.PutUint32(data, crc32.ChecksumIEEE(data[4:]))
+	return data
+}
+
+// decodeRecord decodes the record at the beginning of the data
+func decodeRecord(data []byte) (record, error) {
+	if len(data) < recordHeaderSize {
+		return record{}, errCorruptedRecord
+	}
+
+	keyLength := int64(binary.LittleEndian.Uint32(data[13:]))
+	valueLength := int64(binary.LittleEndian.Uint32(data[17:]))
+	size := recordHeaderSize + keyLength + valueLength
+	if int64(len(data)) < size {
+		return record{}, errCorruptedRecord
+	}
+
+	if crc32.ChecksumIEEE(data[4:size]) != binary.LittleEndian.Uint32(data) {
+		return record{}, errCorruptedRecord
+	}
+
+	return record{
+		sequence:    binary.LittleEndian.Uint64(data[4:]),
+		deleted:     data[12]&tombstoneFlag != 0,
+		key:         string(data[recordHeaderSize : recordHeaderSize+keyLength]),
+		valueLength: uint32(valueLength),
+	}, nil
+}
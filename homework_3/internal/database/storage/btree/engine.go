@@ -0,0 +1,390 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+const BTreeEngine = "btree"
+
+const (
+	defaultDirectory = "data/btree"
+	dataFileName     = "data.db"
+	// checkpointCommits - number of commits after which the WAL checkpoint is moved
+	checkpointCommits = 1024
+)
+
+// Engine - B+tree in a single file, every change is a copy-on-write transaction synced before
+// its meta page is written, readers use the tree of the meta page current when they start
+// and are not blocked by the single writer
+type Engine struct {
+	logger       *common.Logger
+	file         *os.File
+	checkpointer storage.Checkpointer
+
+	// writer - serializes write transactions, the freelist is only used by the writer
+	writer        sync.Mutex
+	freelist      *freelist
+	freelistPages int
+	commits       int
+
+	// m - guards the current meta page and transactions read by active readers
+	m       sync.Mutex
+	meta    meta
+	readers map[uint64]int
+
+	checkpoints chan struct{}
+
+	// stop - closed by Close, background checkpoints end and mark background done
+	stop       chan struct{}
+	background sync.WaitGroup
+}
+
+func NewEngine(directory string, logger *common.Logger) (*Engine, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	if directory == "" {
+		directory = defaultDirectory
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create engine directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(directory, dataFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data file: %w", err)
+	}
+
+	engine := &Engine{
+		logger:       logger,
+		file:         file,
+		checkpointer: storage.NoCheckpointer{},
+		readers:      make(map[uint64]int),
+		checkpoints:  make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+
+	if err = engine.open(directory); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+// SetCheckpointer makes moving the checkpoint consistent with WAL segments
+func (e *Engine) SetCheckpointer(checkpointer storage.Checkpointer) {
+	e.writer.Lock()
+	e.checkpointer = checkpointer
+	e.writer.Unlock()
+}
+
+// LastCheckpoint returns the checkpoint stored in the current meta page, key changes logged
+// to WAL segments before it are committed
+func (e *Engine) LastCheckpoint() int64 {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return e.meta.checkpoint
+}
+
+// Start launches background moving of the checkpoint, as every commit is synced it only waits
+// for in-flight key changes
+func (e *Engine) Start() {
+	e.background.Add(1)
+	go func() {
+		defer e.background.Done()
+
+		for {
+			select {
+			case <-e.checkpoints:
+			case <-e.stop:
+				return
+			}
+
+			if err := e.Checkpoint(); err != nil {
+				e.logger.Error("failed to move checkpoint: %s", err)
+			}
+		}
+	}()
+}
+
+// Close waits for the background checkpoint in progress and the write transaction and closes
+// the data file, it must be called once after the engine is no longer used
+func (e *Engine) Close() error {
+	close(e.stop)
+	e.background.Wait()
+
+	e.writer.Lock()
+	defer e.writer.Unlock()
+
+	return e.file.Close()
+}
+
+func (e *Engine) Set(key, value string) error {
+	err := e.update(key, func(n *node) bool {
+		i, found := n.search(key)
+		if found {
+			n.values[i] = value
+			return true
+		}
+
+		n.keys = append(n.keys[:i], append([]string{key}, n.keys[i:]...)...)
+		n.values = append(n.values[:i], append([]string{value}, n.values[i:]...)...)
+		return true
+	})
+	if err != nil {
+		e.logger.Error("SET query [key %s]: %s", key, err)
+		return err
+	}
+
+	e.logger.Debug("successful SET query [key %s, value %s]", key, value)
+	return nil
+}
+
+func (e *Engine) Get(key string) (string, error) {
+	snapshot := e.beginRead()
+	defer e.endRead(snapshot)
+
+	id := snapshot.root
+	for {
+		n, _, err := e.read(id)
+		if err != nil {
+			e.logger.Error("GET query [key %s]: %s", key, err)
+			return "", err
+		}
+
+		if !n.leaf {
+			id = n.children[n.childIndex(key)]
+			continue
+		}
+
+		i, found := n.search(key)
+		if !found {
+			e.logger.Debug("GET query [key %s]: key not found", key)
+			return "", storage.ErrNotFound
+		}
+
+		e.logger.Info("successful GET query [key %s, value %s]", key, n.values[i])
+		return n.values[i], nil
+	}
+}
+
+func (e *Engine) Del(key string) error {
+	err := e.update(key, func(n *node) bool {
+		i, found := n.search(key)
+		if !found {
+			return false
+		}
+
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+		return true
+	})
+	if err != nil {
+		e.logger.Error("DEL query [key %s]: %s", key, err)
+		return err
+	}
+
+	e.logger.Debug("successful DEL query [key %s]", key)
+	return nil
+}
+
+// update commits the change of the leaf which may hold the key, nothing is written if the leaf is not changed
+func (e *Engine) update(key string, change func(*node) bool) error {
+	e.writer.Lock()
+	defer e.writer.Unlock()
+
+	tx := e.beginWrite()
+	parts, changed, err := tx.modify(tx.meta.root, key, change)
+	if err != nil || !changed {
+		return err
+	}
+
+	if err = tx.setRoot(parts); err != nil {
+		return err
+	}
+
+	if err = tx.commit(); err != nil {
+		return err
+	}
+
+	e.commits++
+	if e.commits%checkpointCommits == 0 {
+		select {
+		case e.checkpoints <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Checkpoint stores the current WAL checkpoint in the meta page and releases older WAL segments
+func (e *Engine) Checkpoint() error {
+	e.writer.Lock()
+	checkpointer := e.checkpointer
+	e.writer.Unlock()
+
+	checkpoint, err := checkpointer.Checkpoint(func() {})
+	if err != nil {
+		return err
+	}
+
+	e.writer.Lock()
+	if checkpoint > e.meta.checkpoint {
+		tx := e.beginWrite()
+		tx.meta.checkpoint = checkpoint
+		err = tx.commit()
+	}
+	e.writer.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err = checkpointer.Release(checkpoint); err != nil {
+		e.logger.Error("failed to release WAL checkpoint %d: %s", checkpoint, err)
+	}
+
+	e.logger.Debug("checkpoint has been moved [checkpoint %d]", checkpoint)
+	return nil
+}
+
+// beginRead pins the current meta page, its pages are not reused until endRead
+func (e *Engine) beginRead() meta {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.readers[e.meta.txid]++
+	return e.meta
+}
+
+func (e *Engine) endRead(snapshot meta) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.readers[snapshot.txid]--
+	if e.readers[snapshot.txid] == 0 {
+		delete(e.readers, snapshot.txid)
+	}
+}
+
+// read returns the node stored at the page and the number of pages it takes
+func (e *Engine) read(id pgid) (*node, int, error) {
+	data, err := e.readPages(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := decodeNode(id, data)
+	return n, len(data) / pageSize, err
+}
+
+// readPages reads the page with its overflow pages
+func (e *Engine) readPages(id pgid) ([]byte, error) {
+	data := make([]byte, pageSize)
+	if _, err := e.file.ReadAt(data, int64(id)*pageSize); err != nil {
+		return nil, fmt.Errorf("failed to read page %d: %w", id, err)
+	}
+
+	if overflow := decodePageHeader(data).overflow; overflow > 0 {
+		data = append(data, make([]byte, int(overflow)*pageSize)...)
+		if _, err := e.file.ReadAt(data[pageSize:], int64(id+1)*pageSize); err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %w", id, err)
+		}
+	}
+
+	return data, nil
+}
+
+// open loads the current meta page and the freelist, an empty file gets an empty tree
+func (e *Engine) open(directory string) error {
+	stat, err := e.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() == 0 {
+		return e.initialize(directory)
+	}
+
+	var current meta
+	valid := false
+	for i := 0; i < metaPages; i++ {
+		data := make([]byte, pageSize)
+		if _, err = e.file.ReadAt(data, int64(i)*pageSize); err != nil {
+			continue
+		}
+
+		m, err := decodeMeta(data)
+		if err != nil {
+			e.logger.Error("meta page %d is invalid", i)
+			continue
+		}
+
+		if !valid || m.txid > current.txid {
+			current, valid = m, true
+		}
+	}
+
+	if !valid {
+		return errInvalidMeta
+	}
+
+	data, err := e.readPages(current.freelist)
+	if err != nil {
+		return err
+	}
+
+	if e.freelist, err = decodeFreelist(current.freelist, data); err != nil {
+		return err
+	}
+
+	e.meta, e.freelistPages = current, len(data)/pageSize
+	e.logger.Debug("btree has been opened [transaction %d, %d pages]", current.txid, current.pageCount)
+	return nil
+}
+
+// initialize writes both meta pages pointing to an empty leaf
+func (e *Engine) initialize(directory string) error {
+	e.freelist, e.freelistPages = newFreelist(), 1
+
+	freelistData := e.freelist.encode(1)
+	setPageID(freelistData, metaPages)
+	root := (&node{leaf: true}).encode()
+	setPageID(root, metaPages+1)
+
+	if _, err := e.file.WriteAt(append(freelistData, root...), metaPages*pageSize); err != nil {
+		return err
+	}
+
+	e.meta = meta{root: metaPages + 1, freelist: metaPages, pageCount: metaPages + 2}
+	for txid := uint64(0); txid < metaPages; txid++ {
+		e.meta.txid = txid
+		if err := e.writeMeta(e.meta); err != nil {
+			return err
+		}
+	}
+
+	return filesystem.SyncDir(directory)
+}
+
+// writeMeta writes the meta page of the transaction to the page not used by the previous transaction
+func (e *Engine) writeMeta(m meta) error {
+	if _, err := e.file.WriteAt(m.encode(), int64(m.txid%metaPages)*pageSize); err != nil {
+		return fmt.Errorf("failed to write meta page: %w", err)
+	}
+
+	return e.file.Sync()
+}
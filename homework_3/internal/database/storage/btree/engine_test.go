@@ -0,0 +1,315 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+type testCheckpointer struct {
+	next     int64
+	released []int64
+}
+
+func (c *testCheckpointer) Checkpoint(switchMemtable func()) (int64, error) {
+	c.next++
+	switchMemtable()
+	return c.next, nil
+}
+
+func (c *testCheckpointer) Release(checkpoint int64) error {
+	c.released = append(c.released, checkpoint)
+	return nil
+}
+
+func newTestEngine(t *testing.T, directory string) *Engine {
+	t.Helper()
+
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(directory, logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	t.Cleanup(func() {
+		_ = engine.file.Close()
+	})
+	return engine
+}
+
+func expectValue(t *testing.T, engine *Engine, key, expected string) {
+	t.Helper()
+
+	value, err := engine.Get(key)
+	if expected == "" {
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("want %s not found; got %s, %+v", key, value, err)
+		}
+		return
+	}
+
+	if err != nil || value != expected {
+		t.Errorf("want %s for %s; got %s, %+v", expected, key, value, err)
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	if _, err := NewEngine(t.TempDir(), nil); err == nil {
+		t.Errorf("want error without logger")
+	}
+
+	engine := newTestEngine(t, t.TempDir())
+	if engine.meta.txid != 1 || engine.meta.pageCount != metaPages+2 {
+		t.Errorf("want empty tree at transaction 1; got %+v", engine.meta)
+	}
+}
+
+func TestEngine_SetGetDel(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+	engine.Set("key_1", "new_value_1")
+	engine.Del("key_2")
+	engine.Del("key_3")
+
+	expectValue(t, engine, "key_1", "new_value_1")
+	expectValue(t, engine, "key_2", "")
+	expectValue(t, engine, "key_3", "")
+
+	reopened := newTestEngine(t, directory)
+	expectValue(t, reopened, "key_1", "new_value_1")
+	expectValue(t, reopened, "key_2", "")
+}
+
+func TestEngine_SplitAndShrink(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+
+	const count = 2000
+	for i := 0; i < count; i++ {
+		engine.Set(fmt.Sprintf("key_%05d", i), fmt.Sprintf("value_%d", i))
+	}
+
+	root, _, err := engine.read(engine.meta.root)
+	if err != nil || root.leaf {
+		t.Fatalf("want branch root; got %+v, %+v", root, err)
+	}
+
+	reopened := newTestEngine(t, directory)
+	for i := 0; i < count; i++ {
+		expectValue(t, reopened, fmt.Sprintf("key_%05d", i), fmt.Sprintf("value_%d", i))
+	}
+
+	for i := 0; i < count; i++ {
+		if i%100 != 0 {
+			reopened.Del(fmt.Sprintf("key_%05d", i))
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		expected := ""
+		if i%100 == 0 {
+			expected = fmt.Sprintf("value_%d", i)
+		}
+		expectValue(t, reopened, fmt.Sprintf("key_%05d", i), expected)
+	}
+
+	root, _, err = reopened.read(reopened.meta.root)
+	if err != nil || !root.leaf || len(root.keys) != count/100 {
+		t.Errorf("want leaf root with %d keys; got %+v, %+v", count/100, root, err)
+	}
+}
+
+func TestEngine_LargeValue(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+
+	large := strings.Repeat("v", 3*pageSize)
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", large)
+	engine.Set("key_3", "value_3")
+
+	reopened := newTestEngine(t, directory)
+	expectValue(t, reopened, "key_1", "value_1")
+	expectValue(t, reopened, "key_2", large)
+	expectValue(t, reopened, "key_3", "value_3")
+}
+
+func TestEngine_PagesReused(t *testing.T) {
+	engine := newTestEngine(t, t.TempDir())
+
+	for i := 0; i < 1000; i++ {
+		engine.Set("key", fmt.Sprintf("value_%d", i))
+	}
+
+	// a transaction frees the pages of the previous one, so only a few pages are ever in use
+	if engine.meta.pageCount > 16 {
+		t.Errorf("want freed pages reused; got %d pages", engine.meta.pageCount)
+	}
+
+	expectValue(t, engine, "key", "value_999")
+}
+
+func TestEngine_TornMeta(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_1", "value_2")
+
+	// the meta page of the last transaction is torn, the previous transaction is current
+	data := make([]byte, pageSize)
+	offset := int64(engine.meta.txid%metaPages) * pageSize
+	if _, err := engine.file.WriteAt(data[:100], offset); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	reopened := newTestEngine(t, directory)
+	expectValue(t, reopened, "key_1", "value_1")
+
+	reopened.Set("key_2", "value_2")
+	again := newTestEngine(t, directory)
+	expectValue(t, again, "key_1", "value_1")
+	expectValue(t, again, "key_2", "value_2")
+}
+
+func TestEngine_InvalidFile(t *testing.T) {
+	directory := t.TempDir()
+	filename := filepath.Join(directory, dataFileName)
+	if err := os.WriteFile(filename, make([]byte, 4*pageSize), 0644); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	logger, _ := common.NewLogger("", "")
+	if _, err := NewEngine(directory, logger); !errors.Is(err, errInvalidMeta) {
+		t.Errorf("want %+v; got %+v", errInvalidMeta, err)
+	}
+}
+
+func TestEngine_SnapshotReader(t *testing.T) {
+	engine := newTestEngine(t, t.TempDir())
+	engine.Set("key", "value_1")
+
+	// pages of the pinned transaction are not reused while it is read
+	snapshot := engine.beginRead()
+	for i := 0; i < 100; i++ {
+		engine.Set("key", fmt.Sprintf("value_%d", i+2))
+	}
+
+	leaf, _, err := engine.read(snapshot.root)
+	if err != nil || len(leaf.values) != 1 || leaf.values[0] != "value_1" {
+		t.Errorf("want pinned leaf with value_1; got %+v, %+v", leaf, err)
+	}
+	engine.endRead(snapshot)
+
+	expectValue(t, engine, "key", "value_101")
+}
+
+func TestEngine_ConcurrentReaders(t *testing.T) {
+	engine := newTestEngine(t, t.TempDir())
+
+	const count = 300
+	for i := 0; i < count; i++ {
+		engine.Set(fmt.Sprintf("key_%d", i), "value")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			engine.Set(fmt.Sprintf("key_%d", i), "new_value")
+		}
+	}()
+
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				value, err := engine.Get(fmt.Sprintf("key_%d", i))
+				if err != nil || (value != "value" && value != "new_value") {
+					t.Errorf("want value or new_value; got %s, %+v", value, err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestEngine_Checkpoint(t *testing.T) {
+	directory := t.TempDir()
+	engine := newTestEngine(t, directory)
+	checkpointer := &testCheckpointer{}
+	engine.SetCheckpointer(checkpointer)
+
+	engine.Set("key_1", "value_1")
+	if err := engine.Checkpoint(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if engine.LastCheckpoint() != 1 || len(checkpointer.released) != 1 || checkpointer.released[0] != 1 {
+		t.Errorf("want checkpoint 1 released; got %d, %+v", engine.LastCheckpoint(), checkpointer.released)
+	}
+
+	reopened := newTestEngine(t, directory)
+	if reopened.LastCheckpoint() != 1 {
+		t.Errorf("want checkpoint 1 after reopening; got %d", reopened.LastCheckpoint())
+	}
+	expectValue(t, reopened, "key_1", "value_1")
+}
+
+func TestEngine_Iterator(t *testing.T) {
+	engine := newTestEngine(t, t.TempDir())
+
+	const count = 1000
+	for i := 0; i < count; i++ {
+		engine.Set(fmt.Sprintf("key_%04d", i), fmt.Sprintf("value_%d", i))
+	}
+
+	// the iterator reads the tree of its transaction, later changes are not seen
+	iterator := engine.Iterator()
+	engine.Del("key_0500")
+	engine.Set("key_0500_new", "value")
+
+	seen := 0
+	for iterator.Seek("key_0250"); iterator.Next(); seen++ {
+		expected := fmt.Sprintf("key_%04d", 250+seen)
+		if iterator.Key() != expected || iterator.Value() != fmt.Sprintf("value_%d", 250+seen) {
+			t.Fatalf("want %s; got %s=%s", expected, iterator.Key(), iterator.Value())
+		}
+	}
+
+	if err := iterator.Close(); err != nil || seen != count-250 {
+		t.Errorf("want %d keys; got %d, %+v", count-250, seen, err)
+	}
+
+	engine.m.Lock()
+	readers := len(engine.readers)
+	engine.m.Unlock()
+	if readers != 0 {
+		t.Errorf("want snapshot released after closing; got %d readers", readers)
+	}
+
+	iterator = engine.Iterator()
+	defer iterator.Close()
+	if !iterator.Next() || iterator.Key() != "key_0000" {
+		t.Errorf("want iteration from the first key without seek")
+	}
+
+	iterator.Seek("key_0500")
+	if !iterator.Next() || iterator.Key() != "key_0500_new" {
+		t.Errorf("want key_0500_new after seek")
+	}
+}
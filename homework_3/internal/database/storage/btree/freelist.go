@@ -0,0 +1,114 @@
+package btree
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// freelist - pages which can be reused, pages freed by a transaction are pending
+// until no reader may still see them
+type freelist struct {
+	free []pgid
+	// pending - pages freed by the transaction, they are reachable from snapshots before it
+	pending map[uint64][]pgid
+}
+
+func newFreelist() *freelist {
+	return &freelist{pending: make(map[uint64][]pgid)}
+}
+
+// allocate takes n contiguous free pages, it returns false if there are none
+func (f *freelist) allocate(n int) (pgid, bool) {
+	start := 0
+	for i := range f.free {
+		if i > 0 && f.free[i] != f.free[i-1]+1 {
+			start = i
+		}
+
+		if i-start+1 == n {
+			id := f.free[start]
+			f.free = slices.Delete(f.free, start, i+1)
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+// release frees n pages starting from the id once the transaction is not visible to readers
+func (f *freelist) release(txid uint64, id pgid, n int) {
+	for i := 0; i < n; i++ {
+		f.pending[txid] = append(f.pending[txid], id+pgid(i))
+	}
+}
+
+// reclaim makes pages pending for transactions up to the given one free
+func (f *freelist) reclaim(upTo uint64) {
+	for txid, ids := range f.pending {
+		if txid <= upTo {
+			f.free = append(f.free, ids...)
+			delete(f.pending, txid)
+		}
+	}
+
+	slices.Sort(f.free)
+}
+
+// encode stores free and pending pages padded to the given number of pages,
+// pending pages are free after a restart as there are no readers
+func (f *freelist) encode(pages int) []byte {
+	ids := slices.Clone(f.free)
+	for _, pending := range f.pending {
+		ids = append(ids, pending...)
+	}
+	slices.Sort(ids)
+
+	data := make([]byte, max(pages, pagesFor(headerSize+8+8*len(ids)))*pageSize)
+	pageHeader{flags: freelistFlag, overflow: uint32(len(data)/pageSize - 1)}.encode(data)
+
+	binary.LittleEndian.PutUint64(data[headerSize:], uint64(len(ids)))
+	for i, free := range ids {
+		binary.LittleEndian.PutUint64(data[headerSize+8+8*i:], uint64(free))
+	}
+
+	return data
+}
+
+// encodedPages returns the number of pages the freelist takes
+func (f *freelist) encodedPages() int {
+	count := len(f.free)
+	for _, pending := range f.pending {
+		count += len(pending)
+	}
+
+	return pagesFor(headerSize + 8 + 8*count)
+}
+
+func decodeFreelist(id pgid, data []byte) (*freelist, error) {
+	header := decodePageHeader(data)
+	if header.id != id || header.flags != freelistFlag || len(data) < headerSize+8 {
+		return nil, errCorruptedPage
+	}
+
+	count := binary.LittleEndian.Uint64(data[headerSize:])
+	if uint64(len(data)-headerSize-8)/8 < count {
+		return nil, errCorruptedPage
+	}
+
+	f := newFreelist()
+	f.free = make([]pgid, 0, count)
+	for i := uint64(0); i < count; i++ {
+		f.free = append(f.free, pgid(binary.LittleEndian.Uint64(data[headerSize+8+8*i:])))
+	}
+
+	return f, nil
+}
+
+func (f *freelist) clone() *freelist {
+	c := &freelist{free: slices.Clone(f.free), pending: make(map[uint64][]pgid, len(f.pending))}
+	for txid, ids := range f.pending {
+		c.pending[txid] = slices.Clone(ids)
+	}
+
+	return c
+}
@@ -0,0 +1,98 @@
+package btree
+
+import "github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+
+// Iterator returns an iterator over the tree of the transaction current at the moment,
+// its pages are not reused until the iterator is closed
+func (e *Engine) Iterator() storage.Iterator {
+	return &iterator{engine: e, snapshot: e.beginRead()}
+}
+
+type frame struct {
+	node     *node
+	position int
+}
+
+type iterator struct {
+	engine   *Engine
+	snapshot meta
+	// stack - nodes on the path from the root to the current leaf
+	stack  []frame
+	sought bool
+	closed bool
+	err    error
+}
+
+func (it *iterator) Seek(key string) {
+	it.stack, it.sought = it.stack[:0], true
+	if it.closed || it.err != nil {
+		return
+	}
+
+	id := it.snapshot.root
+	for {
+		n, _, err := it.engine.read(id)
+		if err != nil {
+			it.err = err
+			return
+		}
+
+		if n.leaf {
+			i, _ := n.search(key)
+			it.stack = append(it.stack, frame{node: n, position: i - 1})
+			return
+		}
+
+		i := n.childIndex(key)
+		it.stack = append(it.stack, frame{node: n, position: i})
+		id = n.children[i]
+	}
+}
+
+func (it *iterator) Next() bool {
+	if !it.sought {
+		it.Seek("")
+	}
+
+	for !it.closed && it.err == nil && len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		top.position++
+
+		if top.node.leaf && top.position < len(top.node.keys) {
+			return true
+		}
+
+		if top.node.leaf || top.position >= len(top.node.children) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+
+		n, _, err := it.engine.read(top.node.children[top.position])
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.stack = append(it.stack, frame{node: n, position: -1})
+	}
+
+	return false
+}
+
+func (it *iterator) Key() string {
+	top := it.stack[len(it.stack)-1]
+	return top.node.keys[top.position]
+}
+
+func (it *iterator) Value() string {
+	top := it.stack[len(it.stack)-1]
+	return top.node.values[top.position]
+}
+
+func (it *iterator) Close() error {
+	if !it.closed {
+		it.closed, it.stack = true, nil
+		it.engine.endRead(it.snapshot)
+	}
+
+	return it.err
+}
@@ -0,0 +1,151 @@
+package btree
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// node - a decoded B+tree page, leaves hold values and branches hold children,
+// the key of a child is the smallest key stored under it
+type node struct {
+	leaf     bool
+	keys     []string
+	values   []string
+	children []pgid
+}
+
+// search returns the position of the key and whether it is present
+func (n *node) search(key string) (int, bool) {
+	i := sort.SearchStrings(n.keys, key)
+	return i, i < len(n.keys) && n.keys[i] == key
+}
+
+// childIndex returns the child which may hold the key
+func (n *node) childIndex(key string) int {
+	i, found := n.search(key)
+	if found || i == 0 {
+		return i
+	}
+
+	return i - 1
+}
+
+func (n *node) elementSize(i int) int {
+	size := binary.MaxVarintLen32 + len(n.keys[i])
+	if n.leaf {
+		return size + binary.MaxVarintLen32 + len(n.values[i])
+	}
+
+	return size + 8
+}
+
+// size returns the upper bound of the encoded node size
+func (n *node) size() int {
+	size := headerSize
+	for i := range n.keys {
+		size += n.elementSize(i)
+	}
+
+	return size
+}
+
+// split divides the node into parts fitting a page, an element larger than a page gets a part of its own
+func (n *node) split() []*node {
+	var parts []*node
+	start, size := 0, headerSize
+	for i := range n.keys {
+		elementSize := n.elementSize(i)
+		if i > start && size+elementSize > pageSize {
+			parts = append(parts, n.slice(start, i))
+			start, size = i, headerSize
+		}
+		size += elementSize
+	}
+
+	if start < len(n.keys) {
+		parts = append(parts, n.slice(start, len(n.keys)))
+	}
+
+	return parts
+}
+
+func (n *node) slice(start, end int) *node {
+	part := &node{leaf: n.leaf, keys: n.keys[start:end:end]}
+	if n.leaf {
+		part.values = n.values[start:end:end]
+	} else {
+		part.children = n.children[start:end:end]
+	}
+
+	return part
+}
+
+// encode returns the node padded to whole pages, the page id is set when the pages are allocated
+func (n *node) encode() []byte {
+	body := make([]byte, 0, pageSize)
+	for i, key := range n.keys {
+		body = binary.AppendUvarint(body, uint64(len(key)))
+		body = append(body, key...)
+		if n.leaf {
+			body = binary.AppendUvarint(body, uint64(len(n.values[i])))
+			body = append(body, n.values[i]...)
+		} else {
+			body = binary.LittleEndian.AppendUint64(body, uint64(n.children[i]))
+		}
+	}
+
+	pages := pagesFor(headerSize + len(body))
+	data := make([]byte, pages*pageSize)
+	header := pageHeader{flags: branchFlag, count: uint16(len(n.keys)), overflow: uint32(pages - 1)}
+	if n.leaf {
+		header.flags = leafFlag
+	}
+	header.encode(data)
+	copy(data[headerSize:], body)
+
+	return data
+}
+
+func decodeNode(id pgid, data []byte) (*node, error) {
+	header := decodePageHeader(data)
+	if header.id != id || header.flags&(leafFlag|branchFlag) == 0 {
+		return nil, errCorruptedPage
+	}
+
+	n := &node{leaf: header.flags&leafFlag != 0}
+	body := data[headerSize:]
+	for i := 0; i < int(header.count); i++ {
+		key, rest, ok := readBytes(body)
+		if !ok {
+			return nil, errCorruptedPage
+		}
+		n.keys = append(n.keys, string(key))
+
+		if n.leaf {
+			var value []byte
+			if value, rest, ok = readBytes(rest); !ok {
+				return nil, errCorruptedPage
+			}
+			n.values = append(n.values, string(value))
+		} else {
+			if len(rest) < 8 {
+				return nil, errCorruptedPage
+			}
+			n.children = append(n.children, pgid(binary.LittleEndian.Uint64(rest)))
+			rest = rest[8:]
+		}
+
+		body = rest
+	}
+
+	return n, nil
+}
+
+func readBytes(data []byte) ([]byte, []byte, bool) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return nil, nil, false
+	}
+
+	return data[n : n+int(length)], data[n+int(length):], true
+}
@@ -0,0 +1,109 @@
+package btree
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNode_EncodeDecode(t *testing.T) {
+	tests := map[string]*node{
+		"empty leaf": {leaf: true},
+		"leaf": {
+			leaf:   true,
+			keys:   []string{"key_1", "key_2"},
+			values: []string{"value_1", ""},
+		},
+		"overflow leaf": {
+			leaf:   true,
+			keys:   []string{"key_1"},
+			values: []string{strings.Repeat("v", 2*pageSize)},
+		},
+		"branch": {
+			keys:     []string{"key_1", "key_5"},
+			children: []pgid{3, 7},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := test.encode()
+			setPageID(data, 10)
+
+			decoded, err := decodeNode(10, data)
+			if err != nil {
+				t.Fatalf("want %+v; got %+v", nil, err)
+			}
+
+			if len(test.keys) == 0 {
+				decoded.keys, decoded.values = test.keys, test.values
+			}
+
+			if !reflect.DeepEqual(test, decoded) {
+				t.Errorf("want %+v; got %+v", test, decoded)
+			}
+
+			if _, err = decodeNode(11, data); err != errCorruptedPage {
+				t.Errorf("want %+v for another page; got %+v", errCorruptedPage, err)
+			}
+		})
+	}
+}
+
+func TestNode_Split(t *testing.T) {
+	n := &node{leaf: true}
+	for i := 0; i < 500; i++ {
+		n.keys = append(n.keys, fmt.Sprintf("key_%03d", i))
+		n.values = append(n.values, strings.Repeat("v", 30))
+	}
+
+	parts := n.split()
+	if len(parts) < 2 {
+		t.Fatalf("want several parts; got %d", len(parts))
+	}
+
+	var keys []string
+	for _, part := range parts {
+		if len(part.encode()) != pageSize {
+			t.Errorf("want part fitting a page; got %d bytes", len(part.encode()))
+		}
+		keys = append(keys, part.keys...)
+	}
+
+	if !reflect.DeepEqual(keys, n.keys) {
+		t.Errorf("want all keys in order after split")
+	}
+}
+
+func TestFreelist(t *testing.T) {
+	f := newFreelist()
+	f.release(1, 4, 2)
+	f.release(2, 9, 3)
+
+	if _, ok := f.allocate(1); ok {
+		t.Fatalf("want pending pages not allocated")
+	}
+
+	f.reclaim(1)
+	if id, ok := f.allocate(2); !ok || id != 4 {
+		t.Errorf("want pages 4-5; got %d, %t", id, ok)
+	}
+
+	f.reclaim(2)
+	if _, ok := f.allocate(4); ok {
+		t.Errorf("want no 4 contiguous pages")
+	}
+
+	if id, ok := f.allocate(3); !ok || id != 9 {
+		t.Errorf("want pages 9-11; got %d, %t", id, ok)
+	}
+
+	f.release(3, 20, 1)
+	data := f.encode(1)
+	setPageID(data, 2)
+	decoded, err := decodeFreelist(2, data)
+	if err != nil || !reflect.DeepEqual(decoded.free, []pgid{20}) {
+		t.Errorf("want pending page 20 free after decoding; got %+v, %+v", decoded, err)
+	}
+}
@@ -0,0 +1,122 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+// The file is a sequence of fixed-size pages:
+//
+//	page 0, page 1 - meta pages written in turns, the valid one with the greater transaction id is current
+//	other pages    - B+tree nodes and the freelist, a node larger than a page takes contiguous overflow pages
+//
+// every node and freelist page starts with the header:
+//
+//	id (8) | flags (2) | count (2) | overflow (4)
+const (
+	pageSize   = 4096
+	headerSize = 8 + 2 + 2 + 4
+
+	metaPages = 2
+	// minFill - a node smaller than that is merged with its sibling
+	minFill = pageSize / 4
+
+	leafFlag     = 0x01
+	branchFlag   = 0x02
+	freelistFlag = 0x04
+
+	magic   = 0xb7ee0001
+	version = 1
+	// metaSize - magic, version, page size, root, freelist, page count, transaction id, checkpoint and checksum
+	metaSize = 4 + 4 + 4 + 8*6
+)
+
+type pgid uint64
+
+var (
+	errInvalidMeta   = errors.New("btree: invalid meta page")
+	errCorruptedPage = errors.New("btree: corrupted page")
+)
+
+type pageHeader struct {
+	id       pgid
+	flags    uint16
+	count    uint16
+	overflow uint32
+}
+
+func (h pageHeader) encode(data []byte) {
+	binary.LittleEndian.PutUint64(data, uint64(h.id))
+	binary.LittleEndian.PutUint16(data[8:], h.flags)
+	binary.LittleEndian.PutUint16(data[10:], h.count)
+	binary.LittleEndian.PutUint32(data[12:], h.overflow)
+}
+
+// setPageID stores the id of the allocated page in the encoded header
+func setPageID(data []byte, id pgid) {
+	binary.LittleEndian.PutUint64(data, uint64(id))
+}
+
+func decodePageHeader(data []byte) pageHeader {
+	return pageHeader{
+		id:       pgid(binary.LittleEndian.Uint64(data)),
+		flags:    binary.LittleEndian.Uint16(data[8:]),
+		count:    binary.LittleEndian.Uint16(data[10:]),
+		overflow: binary.LittleEndian.Uint32(data[12:]),
+	}
+}
+
+// pagesFor returns the number of pages taking the data of the size
+func pagesFor(size int) int {
+	return (size + pageSize - 1) / pageSize
+}
+
+// meta - the root of a committed transaction
+type meta struct {
+	root     pgid
+	freelist pgid
+	// pageCount - pages from the end of the file are not used
+	pageCount  uint64
+	txid       uint64
+	checkpoint int64
+}
+
+func (m meta) encode() []byte {
+	data := make([]byte, pageSize)
+	binary.LittleEndian.PutUint32(data, magic)
+	binary.LittleEndian.PutUint32(data[4:], version)
+	binary.LittleEndian.PutUint32(data[8:], pageSize)
+	binary.LittleEndian.PutUint64(data[12:], uint64(m.root))
+	binary.LittleEndian.PutUint64(data[20:], uint64(m.freelist))
+	binary.LittleEndian.PutUint64(data[28:], m.pageCount)
+	binary.LittleEndian.PutUint64(data[36:], m.txid)
+	binary.LittleEndian.PutUint64(data[44:], uint64(m.checkpoint))
+	binary.LittleEndian.PutUint64(data[52:], metaChecksum(data))
+	return data
+}
+
+func decodeMeta(data []byte) (meta, error) {
+	if len(data) < metaSize ||
+		binary.LittleEndian.Uint32(data) != magic ||
+		binary.LittleEndian.Uint32(data[4:]) != version ||
+		binary.LittleEndian.Uint32(data[8:]) != pageSize ||
+		binary.LittleEndian.Uint64(data[52:]) != metaChecksum(data) {
+		return meta{}, errInvalidMeta
+	}
+
+	return meta{
+		root:       pgid(binary.LittleEndian.Uint64(data[12:])),
+		freelist:   pgid(binary.LittleEndian.Uint64(data[20:])),
+		pageCount:  binary.LittleEndian.Uint64(data[28:]),
+		txid:       binary.LittleEndian.Uint64(data[36:]),
+		checkpoint: int64(binary.LittleEndian.Uint64(data[44:])),
+	}, nil
+}
+
+// metaChecksum detects a torn meta page, the previous meta page is used then
+func metaChecksum(data []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(data[:52])
+	return h.Sum64()
+}
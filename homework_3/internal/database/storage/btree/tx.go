@@ -0,0 +1,222 @@
+package btree
+
+import (
+	"fmt"
+	"sort"
+)
+
+// writeTx - a copy-on-write transaction: changed nodes and every node on the path to them are written
+// to new pages, the replaced pages are freed once no reader can see them
+type writeTx struct {
+	engine   *Engine
+	meta     meta
+	freelist *freelist
+	dirty    map[pgid][]byte
+}
+
+func (e *Engine) beginWrite() *writeTx {
+	e.m.Lock()
+	current := e.meta
+	oldest := current.txid
+	for txid := range e.readers {
+		oldest = min(oldest, txid)
+	}
+	e.m.Unlock()
+
+	freelist := e.freelist.clone()
+	freelist.reclaim(oldest)
+
+	next := current
+	next.txid++
+	return &writeTx{engine: e, meta: next, freelist: freelist, dirty: make(map[pgid][]byte)}
+}
+
+// modify applies the change to the leaf which may hold the key copying the path to it,
+// it returns the nodes replacing the node at the id, none if the node has become empty
+func (tx *writeTx) modify(id pgid, key string, change func(*node) bool) ([]*node, bool, error) {
+	n, pages, err := tx.read(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if n.leaf {
+		if !change(n) {
+			return nil, false, nil
+		}
+	} else {
+		i := n.childIndex(key)
+		parts, changed, err := tx.modify(n.children[i], key, change)
+		if err != nil || !changed {
+			return nil, changed, err
+		}
+
+		start, end := i, i+1
+		if len(parts) == 1 && parts[0].size() < minFill && len(n.children) > 1 {
+			if start, end, parts, err = tx.merge(n, i, parts[0]); err != nil {
+				return nil, false, err
+			}
+		}
+
+		keys := append([]string{}, n.keys[:start]...)
+		children := append([]pgid{}, n.children[:start]...)
+		for _, part := range parts {
+			keys = append(keys, part.keys[0])
+			children = append(children, tx.write(part))
+		}
+
+		n.keys = append(keys, n.keys[end:]...)
+		n.children = append(children, n.children[end:]...)
+	}
+
+	tx.free(id, pages)
+	return n.split(), true, nil
+}
+
+// merge joins the underfilled child of the branch with its sibling, it returns the range of children
+// replaced by the resulting nodes
+func (tx *writeTx) merge(branch *node, i int, child *node) (int, int, []*node, error) {
+	left, right := i-1, i
+	if i == 0 {
+		left, right = i, i+1
+	}
+
+	siblingIndex := left
+	if siblingIndex == i {
+		siblingIndex = right
+	}
+
+	sibling, pages, err := tx.read(branch.children[siblingIndex])
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	tx.free(branch.children[siblingIndex], pages)
+
+	first, second := sibling, child
+	if siblingIndex == right {
+		first, second = child, sibling
+	}
+
+	merged := &node{
+		leaf:     child.leaf,
+		keys:     append(append([]string{}, first.keys...), second.keys...),
+		values:   append(append([]string{}, first.values...), second.values...),
+		children: append(append([]pgid{}, first.children...), second.children...),
+	}
+
+	return left, right + 1, merged.split(), nil
+}
+
+// setRoot writes the new root, a root split into several nodes gets a branch above them
+// and a branch left with a single child is replaced by it
+func (tx *writeTx) setRoot(parts []*node) error {
+	for len(parts) > 1 {
+		branch := &node{}
+		for _, part := range parts {
+			branch.keys = append(branch.keys, part.keys[0])
+			branch.children = append(branch.children, tx.write(part))
+		}
+		parts = branch.split()
+	}
+
+	root := &node{leaf: true}
+	if len(parts) == 1 {
+		root = parts[0]
+	}
+
+	for !root.leaf && len(root.children) == 1 {
+		child, pages, err := tx.read(root.children[0])
+		if err != nil {
+			return err
+		}
+
+		tx.free(root.children[0], pages)
+		root = child
+	}
+
+	tx.meta.root = tx.write(root)
+	return nil
+}
+
+// read returns the node from pages written by the transaction or from the file
+func (tx *writeTx) read(id pgid) (*node, int, error) {
+	if data, ok := tx.dirty[id]; ok {
+		n, err := decodeNode(id, data)
+		return n, len(data) / pageSize, err
+	}
+
+	return tx.engine.read(id)
+}
+
+func (tx *writeTx) write(n *node) pgid {
+	data := n.encode()
+	id := tx.allocate(len(data) / pageSize)
+	setPageID(data, id)
+	tx.dirty[id] = data
+	return id
+}
+
+// allocate reuses free pages or grows the file
+func (tx *writeTx) allocate(pages int) pgid {
+	if id, ok := tx.freelist.allocate(pages); ok {
+		return id
+	}
+
+	id := pgid(tx.meta.pageCount)
+	tx.meta.pageCount += uint64(pages)
+	return id
+}
+
+// free releases pages of a replaced node, pages written by the transaction itself are not visible to readers
+func (tx *writeTx) free(id pgid, pages int) {
+	if _, ok := tx.dirty[id]; ok {
+		delete(tx.dirty, id)
+		tx.freelist.release(0, id, pages)
+		return
+	}
+
+	tx.freelist.release(tx.meta.txid, id, pages)
+}
+
+// commit writes the pages and the freelist, syncs them and only then switches the meta page,
+// a crash before the meta page is synced leaves the previous transaction current
+func (tx *writeTx) commit() error {
+	e := tx.engine
+	tx.free(tx.meta.freelist, e.freelistPages)
+
+	pages := tx.freelist.encodedPages()
+	tx.meta.freelist = tx.allocate(pages)
+	data := tx.freelist.encode(pages)
+	setPageID(data, tx.meta.freelist)
+	tx.dirty[tx.meta.freelist] = data
+
+	ids := make([]pgid, 0, len(tx.dirty))
+	for id := range tx.dirty {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		if _, err := e.file.WriteAt(tx.dirty[id], int64(id)*pageSize); err != nil {
+			return fmt.Errorf("failed to write page %d: %w", id, err)
+		}
+	}
+
+	if err := e.file.Sync(); err != nil {
+		return err
+	}
+
+	if err := e.writeMeta(tx.meta); err != nil {
+		return err
+	}
+
+	e.freelist, e.freelistPages = tx.freelist, pages
+
+	e.m.Lock()
+	e.meta = tx.meta
+	e.m.Unlock()
+
+	return nil
+}
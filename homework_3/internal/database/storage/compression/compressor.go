@@ -0,0 +1,174 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	NoneCodec  = "none"
+	FlateCodec = "flate"
+
+	defaultThreshold = 256
+)
+
+// A stored value starts with a header byte only if it is needed to tell it from others:
+//
+//	rawHeader   - the value follows as is, it is added to values starting with a header byte
+//	flateHeader - the value follows compressed by flate
+//
+// so values written before compression was enabled or after it was disabled are read as they are
+const (
+	rawHeader   byte = 0x00
+	flateHeader byte = 0x01
+)
+
+var ErrInvalidCodec = errors.New("compression: codec is invalid")
+
+// Stats - sizes of values before and after encoding
+type Stats struct {
+	Values      uint64
+	Compressed  uint64
+	RawBytes    uint64
+	StoredBytes uint64
+}
+
+// Ratio returns how many times the stored values are smaller than the raw ones
+func (s Stats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+
+	return float64(s.RawBytes) / float64(s.StoredBytes)
+}
+
+// Compressor - encodes values at least of the threshold size with the codec,
+// a value is stored compressed only if it becomes smaller
+type Compressor struct {
+	codec     string
+	threshold int
+	writers   sync.Pool
+
+	values      atomic.Uint64
+	compressed  atomic.Uint64
+	rawBytes    atomic.Uint64
+	storedBytes atomic.Uint64
+}
+
+func NewCompressor(codec string, threshold int) (*Compressor, error) {
+	if codec == "" {
+		codec = NoneCodec
+	}
+
+	if codec != NoneCodec && codec != FlateCodec {
+		return nil, ErrInvalidCodec
+	}
+
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+
+	return &Compressor{codec: codec, threshold: threshold}, nil
+}
+
+// Uncompressed returns a compressor which only adds headers required to read values back
+func Uncompressed() *Compressor {
+	return &Compressor{codec: NoneCodec, threshold: defaultThreshold}
+}
+
+// Codec returns the codec values of the threshold size are compressed with
+func (c *Compressor) Codec() string {
+	return c.codec
+}
+
+// Encode returns the value to store
+func (c *Compressor) Encode(value string) string {
+	encoded := c.encode(value)
+
+	c.values.Add(1)
+	c.rawBytes.Add(uint64(len(value)))
+	c.storedBytes.Add(uint64(len(encoded)))
+	if len(encoded) > 0 && encoded[0] == flateHeader {
+		c.compressed.Add(1)
+	}
+
+	return encoded
+}
+
+func (c *Compressor) encode(value string) string {
+	if c.codec == FlateCodec && len(value) >= c.threshold {
+		if compressed, ok := c.deflate(value); ok {
+			return compressed
+		}
+	}
+
+	if len(value) > 0 && (value[0] == rawHeader || value[0] == flateHeader) {
+		return string(rawHeader) + value
+	}
+
+	return value
+}
+
+// deflate returns the compressed value with its header if it is smaller than the value
+func (c *Compressor) deflate(value string) (string, bool) {
+	var buffer bytes.Buffer
+	buffer.Grow(len(value) / 2)
+	buffer.WriteByte(flateHeader)
+
+	writer, _ := c.writers.Get().(*flate.Writer)
+	if writer == nil {
+		writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+	} else {
+		writer.Reset(&buffer)
+	}
+	defer c.writers.Put(writer)
+
+	if _, err := io.WriteString(writer, value); err != nil {
+		return "", false
+	}
+
+	if err := writer.Close(); err != nil || buffer.Len() >= len(value) {
+		return "", false
+	}
+
+	return buffer.String(), true
+}
+
+func (c *Compressor) Stats() Stats {
+	return Stats{
+		Values:      c.values.Load(),
+		Compressed:  c.compressed.Load(),
+		RawBytes:    c.rawBytes.Load(),
+		StoredBytes: c.storedBytes.Load(),
+	}
+}
+
+// Decode returns the value stored by any compressor
+func Decode(stored string) (string, error) {
+	if len(stored) == 0 {
+		return stored, nil
+	}
+
+	switch stored[0] {
+	case rawHeader:
+		return stored[1:], nil
+	case flateHeader:
+		reader := flate.NewReader(strings.NewReader(stored[1:]))
+		defer reader.Close()
+
+		value, err := io.ReadAll(reader)
+		if err != nil {
+			return "", fmt.Errorf("compression: failed to decode value: %w", err)
+		}
+
+		return string(value), nil
+	}
+
+	return stored, nil
+}
@@ -0,0 +1,93 @@
+package compression
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func TestNewCompressor(t *testing.T) {
+	if _, err := NewCompressor("zstd", 0); !errors.Is(err, ErrInvalidCodec) {
+		t.Errorf("want %+v; got %+v", ErrInvalidCodec, err)
+	}
+
+	compressor, err := NewCompressor("", 0)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if compressor.codec != NoneCodec || compressor.threshold != defaultThreshold {
+		t.Errorf("want default codec and threshold; got %s, %d", compressor.codec, compressor.threshold)
+	}
+}
+
+func TestCompressor_EncodeDecode(t *testing.T) {
+	random := make([]byte, 1024)
+	for i := range random {
+		random[i] = byte(rand.IntN(256))
+	}
+
+	tests := []struct {
+		name             string
+		value            string
+		expectCompressed bool
+	}{
+		{name: "Empty value", value: ""},
+		{name: "Value under threshold", value: "value"},
+		{name: "Value starting with raw header", value: string(rawHeader) + "value"},
+		{name: "Value starting with flate header", value: string(flateHeader) + "value"},
+		{name: "Compressible value", value: strings.Repeat("value_", 100), expectCompressed: true},
+		{name: "Incompressible value", value: "\x01" + string(random)},
+	}
+
+	compressor, err := NewCompressor(FlateCodec, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := compressor.Encode(tt.value)
+			if compressed := len(stored) > 0 && stored[0] == flateHeader; compressed != tt.expectCompressed {
+				t.Errorf("want compressed %t; got %t", tt.expectCompressed, compressed)
+			}
+
+			value, err := Decode(stored)
+			if err != nil || value != tt.value {
+				t.Errorf("want %q; got %q, %+v", tt.value, value, err)
+			}
+		})
+	}
+
+	stats := compressor.Stats()
+	if stats.Values != uint64(len(tests)) || stats.Compressed != 1 || stats.Ratio() <= 1 {
+		t.Errorf("want one of %d values compressed; got %+v", len(tests), stats)
+	}
+}
+
+func TestCompressor_MixedData(t *testing.T) {
+	value := strings.Repeat("value_", 100)
+
+	// values written without compression are read after it is enabled and the other way round
+	plain := Uncompressed().Encode(value)
+	if plain != value {
+		t.Errorf("want value stored as is; got %q", plain)
+	}
+
+	compressor, _ := NewCompressor(FlateCodec, 0)
+	compressed := compressor.Encode(value)
+	if len(compressed) >= len(value) {
+		t.Errorf("want compressed value; got %d bytes", len(compressed))
+	}
+
+	for _, stored := range []string{plain, compressed} {
+		if decoded, err := Decode(stored); err != nil || decoded != value {
+			t.Errorf("want %q; got %q, %+v", value, decoded, err)
+		}
+	}
+
+	if _, err := Decode(string(flateHeader) + "corrupted"); err == nil {
+		t.Errorf("want error for corrupted value")
+	}
+}
@@ -0,0 +1,192 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HeaderSize - encrypted data starts with the magic and the id of the key it is encrypted with
+const HeaderSize = len(magic) + 4
+
+// magic - cannot start gob encoded data, so encrypted and plain data are told apart
+const magic = "\x00ENC"
+
+var (
+	ErrAuthentication = errors.New("encryption: message authentication failed")
+	ErrUnknownKey     = errors.New("encryption: key is unknown")
+	ErrNotEncrypted   = errors.New("encryption: data is not encrypted")
+)
+
+// Keyring - AES-GCM keys by their ids, data is encrypted with the active key which is the one
+// with the greatest id, older keys are kept to decrypt data written before the rotation
+type Keyring struct {
+	keys   map[uint32]cipher.AEAD
+	active uint32
+}
+
+// LoadKeyring reads keys from the keyfile, every line holds the key id and the hex encoded
+// 16, 24 or 32 byte key separated by a space, empty lines and lines starting with # are skipped
+func LoadKeyring(path string) (*Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keyfile: %w", err)
+	}
+	defer file.Close()
+
+	keys := make(map[uint32][]byte)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid keyfile line %d: want key id and key", line)
+		}
+
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyfile line %d: %w", line, err)
+		}
+
+		if _, ok := keys[uint32(id)]; ok {
+			return nil, fmt.Errorf("invalid keyfile line %d: key %d is duplicated", line, id)
+		}
+
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyfile line %d: %w", line, err)
+		}
+
+		keys[uint32(id)] = key
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	return NewKeyring(keys)
+}
+
+func NewKeyring(keys map[uint32][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring has no keys")
+	}
+
+	keyring := &Keyring{keys: make(map[uint32]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d: %w", id, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d: %w", id, err)
+		}
+
+		keyring.keys[id] = aead
+		keyring.active = max(keyring.active, id)
+	}
+
+	return keyring, nil
+}
+
+// Active returns the id of the key new data is encrypted with
+func (k *Keyring) Active() uint32 {
+	return k.active
+}
+
+// Header returns the header of data encrypted with the active key
+func (k *Keyring) Header() []byte {
+	header := make([]byte, HeaderSize)
+	copy(header, magic)
+	binary.LittleEndian.PutUint32(header[len(magic):], k.active)
+	return header
+}
+
+// Seal encrypts the data with the key, the random nonce is prepended to the result and
+// the additional data is authenticated along with it
+func (k *Keyring) Seal(id uint32, data, additional []byte) ([]byte, error) {
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, data, additional), nil
+}
+
+// Open decrypts the data sealed with the key, modified data or additional data fail authentication
+func (k *Keyring) Open(id uint32, sealed, additional []byte) ([]byte, error) {
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrAuthentication
+	}
+
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+
+	return data, nil
+}
+
+// Encrypt returns the data sealed with the active key behind the header, it is used for files
+// written at once such as snapshots
+func (k *Keyring) Encrypt(data []byte) ([]byte, error) {
+	header := k.Header()
+	sealed, err := k.Seal(k.active, data, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, sealed...), nil
+}
+
+// Decrypt returns the data encrypted by Encrypt with any key of the keyring
+func (k *Keyring) Decrypt(encrypted []byte) ([]byte, error) {
+	id, ok := ParseHeader(encrypted)
+	if !ok {
+		return nil, ErrNotEncrypted
+	}
+
+	return k.Open(id, encrypted[HeaderSize:], encrypted[:HeaderSize])
+}
+
+// ParseHeader returns the id of the key the data is encrypted with, false means the data is not encrypted
+func ParseHeader(data []byte) (uint32, bool) {
+	if len(data) < HeaderSize || string(data[:len(magic)]) != magic {
+		return 0, false
+	}
+
+	return binary.LittleEndian.Uint32(data[len(magic):HeaderSize]), true
+}
+
+// IsPartialHeader reports whether the data is a beginning of the header cut short
+func IsPartialHeader(data []byte) bool {
+	if len(data) == 0 || len(data) >= HeaderSize {
+		return false
+	}
+
+	return strings.HasPrefix(magic, string(data[:min(len(data), len(magic))]))
+}
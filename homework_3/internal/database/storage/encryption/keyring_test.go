@@ -0,0 +1,109 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	tests := []struct {
+		name          string
+		keyfile       string
+		expectedError bool
+		expectedKey   uint32
+	}{
+		{
+			name:        "Keyfile with rotated keys",
+			keyfile:     "# keys\n1 " + strings.Repeat("01", 32) + "\n\n3 " + strings.Repeat("03", 16) + "\n2 " + strings.Repeat("02", 24) + "\n",
+			expectedKey: 3,
+		},
+		{name: "Empty keyfile", keyfile: "# no keys\n", expectedError: true},
+		{name: "Key without id", keyfile: strings.Repeat("01", 32), expectedError: true},
+		{name: "Invalid key size", keyfile: "1 0102", expectedError: true},
+		{name: "Invalid hex key", keyfile: "1 " + strings.Repeat("zz", 32), expectedError: true},
+		{name: "Duplicated key id", keyfile: "1 " + strings.Repeat("01", 32) + "\n1 " + strings.Repeat("02", 32), expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keyfile")
+			if err := os.WriteFile(path, []byte(tt.keyfile), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			keyring, err := LoadKeyring(path)
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("want error; got %+v", keyring)
+				}
+				return
+			}
+
+			if err != nil || keyring.Active() != tt.expectedKey {
+				t.Errorf("want active key %d; got %+v, %+v", tt.expectedKey, keyring, err)
+			}
+		})
+	}
+
+	if _, err := LoadKeyring(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("want error for missing keyfile")
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	first, err := NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := first.Encrypt([]byte("data"))
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if id, ok := ParseHeader(encrypted); !ok || id != 1 || bytes.Contains(encrypted, []byte("data")) {
+		t.Errorf("want data encrypted with key 1; got %q", encrypted)
+	}
+
+	// after the rotation new data is encrypted with the new key and old data is still decrypted
+	rotated, _ := NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32), 2: bytes.Repeat([]byte{2}, 32)})
+	if decrypted, err := rotated.Decrypt(encrypted); err != nil || string(decrypted) != "data" {
+		t.Errorf("want data; got %q, %+v", decrypted, err)
+	}
+
+	reencrypted, _ := rotated.Encrypt([]byte("data"))
+	if id, _ := ParseHeader(reencrypted); id != 2 {
+		t.Errorf("want data encrypted with key 2; got %d", id)
+	}
+
+	if _, err = first.Decrypt(reencrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("want %+v; got %+v", ErrUnknownKey, err)
+	}
+}
+
+func TestKeyring_Authentication(t *testing.T) {
+	keyring, _ := NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	encrypted, _ := keyring.Encrypt([]byte("data"))
+
+	for i := range encrypted {
+		tampered := bytes.Clone(encrypted)
+		tampered[i] ^= 0xff
+
+		// tampering with the key id either fails to parse the header or refers to another key
+		if _, err := keyring.Decrypt(tampered); err == nil {
+			t.Errorf("want error for byte %d tampered", i)
+		}
+	}
+
+	if _, err := keyring.Decrypt(encrypted[:len(encrypted)-1]); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("want %+v; got %+v", ErrAuthentication, err)
+	}
+
+	if _, err := keyring.Decrypt([]byte("data")); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("want %+v; got %+v", ErrNotEncrypted, err)
+	}
+}
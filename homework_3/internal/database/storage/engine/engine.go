@@ -2,7 +2,9 @@ package engine
 
 import (
 	"errors"
+	"maps"
 	"sync"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
@@ -10,33 +12,122 @@ import (
 
 const InMemoryEngine = "in_memory"
 
+const (
+	// sweepInterval - how often expired keys which are not accessed are purged
+	sweepInterval = time.Second
+	// sweepLimit - expiring keys checked by a sweep at once, sweeps repeat while a quarter of them are purged
+	sweepLimit = 256
+)
+
 type Engine struct {
 	logger *common.Logger
 
-	m  sync.Mutex
+	m  sync.RWMutex
 	DB map[string]string
+	// index - keys in the ascending order, only kept by the ordered engine
+	index *index
+
+	// memory limit, zero means unlimited
+	maxMemory  int
+	usedMemory int
+	policy     string
+	// access statistics for LRU and LFU policies
+	stats   map[string]*accessStats
+	expires map[string]time.Time
+
+	throttles throttles
+
+	// stop - closed by Close, the background sweep ends and marks background done
+	stop       chan struct{}
+	background sync.WaitGroup
 }
 
 func NewEngine(logger *common.Logger) (*Engine, error) {
+	return NewLimitedEngine(0, "", logger)
+}
+
+// NewLimitedEngine returns an engine which keeps its approximate memory usage under maxMemory bytes
+// by evicting keys according to the policy
+func NewLimitedEngine(maxMemory int, policy string, logger *common.Logger) (*Engine, error) {
 	if logger == nil {
 		return nil, errors.New("logger is invalid")
 	}
 
-	return &Engine{DB: make(map[string]string), logger: logger}, nil
+	if policy == "" {
+		policy = NoEviction
+	}
+
+	if !validPolicy(policy) {
+		return nil, errors.New("eviction policy is invalid")
+	}
+
+	engine := &Engine{
+		DB:        make(map[string]string),
+		maxMemory: max(maxMemory, 0),
+		policy:    policy,
+		expires:   make(map[string]time.Time),
+		stop:      make(chan struct{}),
+		logger:    logger,
+	}
+
+	if engine.maxMemory > 0 && (policy == AllKeysLRU || policy == AllKeysLFU) {
+		engine.stats = make(map[string]*accessStats)
+	}
+
+	return engine, nil
+}
+
+// Start launches the background sweep purging expired keys
+func (e *Engine) Start() {
+	e.background.Add(1)
+	go func() {
+		defer e.background.Done()
+
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				for e.sweep(sweepLimit) > sweepLimit/4 {
+				}
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background sweep, it must be called once
+func (e *Engine) Close() error {
+	close(e.stop)
+	e.background.Wait()
+	return nil
 }
 
-func (e *Engine) Set(key, value string) {
+func (e *Engine) Set(key, value string) error {
 	e.m.Lock()
-	e.DB[key] = value
+	e.store(key, value)
+	delete(e.expires, key)
 	e.m.Unlock()
 
 	e.logger.Debug("successful SET query [key %s, value %s]", key, value)
+	return nil
 }
 
 func (e *Engine) Get(key string) (string, error) {
-	e.m.Lock()
-	value, ok := e.DB[key]
-	e.m.Unlock()
+	e.m.RLock()
+	value, stored := e.DB[key]
+	ok := stored && e.alive(key, time.Now())
+	if ok {
+		e.touch(key)
+	}
+	e.m.RUnlock()
+
+	if stored && !ok {
+		e.purge(key)
+	}
+
 	if !ok {
 		e.logger.Debug("GET query [key %s, value %s]: key not found", key, value)
 		return "", storage.ErrNotFound
@@ -46,10 +137,112 @@ func (e *Engine) Get(key string) (string, error) {
 	return value, nil
 }
 
-func (e *Engine) Del(key string) {
+func (e *Engine) Del(key string) error {
 	e.m.Lock()
-	delete(e.DB, key)
+	e.remove(key)
 	e.m.Unlock()
 
 	e.logger.Debug("successful DEL query [key %s]", key)
+	return nil
+}
+
+// Dump copies keys with their expiration moments, writes wait only for the copy, the returned function
+// calls its argument with every alive key, its value and expiration moment, zero if the key does not expire
+func (e *Engine) Dump() func(func(key, value string, expiresAt time.Time)) {
+	e.m.RLock()
+	keys, expires := maps.Clone(e.DB), maps.Clone(e.expires)
+	e.m.RUnlock()
+
+	now := time.Now()
+	return func(fn func(key, value string, expiresAt time.Time)) {
+		for key, value := range keys {
+			if expiresAt, ok := expires[key]; !ok || now.Before(expiresAt) {
+				fn(key, value, expiresAt)
+			}
+		}
+	}
+}
+
+// store puts the value accounting its memory, the lock must be held
+func (e *Engine) store(key, value string) {
+	if old, ok := e.DB[key]; ok {
+		e.usedMemory -= entrySize(key, old)
+	} else if e.index != nil {
+		e.index.insert(key)
+	}
+
+	e.DB[key] = value
+	e.usedMemory += entrySize(key, value)
+
+	if e.stats != nil {
+		stats, ok := e.stats[key]
+		if !ok {
+			stats = &accessStats{}
+			e.stats[key] = stats
+		}
+		stats.touch()
+	}
+}
+
+// remove deletes the key with its expiration and statistics, the lock must be held
+func (e *Engine) remove(key string) {
+	if value, ok := e.DB[key]; ok {
+		e.usedMemory -= entrySize(key, value)
+		delete(e.DB, key)
+		if e.index != nil {
+			e.index.remove(key)
+		}
+	}
+
+	delete(e.expires, key)
+	if e.stats != nil {
+		delete(e.stats, key)
+	}
+}
+
+// purge removes the key if it has expired
+func (e *Engine) purge(key string) {
+	e.m.Lock()
+	if _, ok := e.DB[key]; ok && !e.alive(key, time.Now()) {
+		e.remove(key)
+	}
+	e.m.Unlock()
+}
+
+// sweep removes expired keys among at most limit expiring keys and returns the number of removed ones
+func (e *Engine) sweep(limit int) int {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	now, removed := time.Now(), 0
+	for key, expiresAt := range e.expires {
+		if limit == 0 {
+			break
+		}
+		limit--
+
+		if !now.Before(expiresAt) {
+			e.remove(key)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// alive reports whether the stored key has not expired by the moment, the lock must be held
+func (e *Engine) alive(key string, now time.Time) bool {
+	expiresAt, ok := e.expires[key]
+	return !ok || now.Before(expiresAt)
+}
+
+// touch updates access statistics of the key, the read lock is enough
+func (e *Engine) touch(key string) {
+	if e.stats == nil {
+		return
+	}
+
+	if stats, ok := e.stats[key]; ok {
+		stats.touch()
+	}
 }
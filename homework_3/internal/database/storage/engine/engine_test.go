@@ -2,10 +2,13 @@ package engine
 
 import (
 	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
 )
 
 func TestNewEngine(t *testing.T) {
@@ -164,3 +167,142 @@ func TestEngine_Del(t *testing.T) {
 		})
 	}
 }
+
+func TestEngine_Throttle(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	limit := throttle.Limit{MaxBurst: 1, Count: 1, Period: time.Hour}
+	for i, allowed := range []bool{true, true, false} {
+		result := engine.Throttle("user_1", limit)
+		if result.Allowed != allowed {
+			t.Errorf("request %d: want allowed %t; got %+v", i, allowed, result)
+		}
+	}
+
+	result := engine.Throttle("user_2", limit)
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("want independent limiter for another key; got %+v", result)
+	}
+
+	idle := throttle.Limit{MaxBurst: 0, Count: 1, Period: time.Nanosecond}
+	engine.throttles.limiters["idle"] = &rateLimiter{limit: idle}
+	engine.throttles.sweep(time.Now())
+	if _, ok := engine.throttles.limiters["idle"]; ok {
+		t.Errorf("want idle limiter to be swept")
+	}
+
+	if _, ok := engine.throttles.limiters["user_1"]; !ok {
+		t.Errorf("want active limiter to be kept")
+	}
+}
+
+func TestEngine_ThrottleKeysLocked(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	engine.m.Lock()
+	defer engine.m.Unlock()
+
+	done := make(chan throttle.Result, 1)
+	go func() { done <- engine.Throttle("user", throttle.Limit{MaxBurst: 1, Count: 1, Period: time.Hour}) }()
+
+	select {
+	case result := <-done:
+		if !result.Allowed {
+			t.Errorf("want request allowed; got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("want THROTTLE not to wait for the keys lock")
+	}
+}
+
+func TestEngine_Dump(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+	engine.Set("key_3", "value_3")
+	engine.Expire("key_3", time.Now().Add(-time.Second))
+
+	dump := engine.Dump()
+	engine.Set("key_1", "new_value_1")
+	engine.Del("key_2")
+
+	// the captured keys are walked without holding the lock, writes go on
+	keys := make(map[string]string)
+	dump(func(key, value string, _ time.Time) {
+		keys[key] = value
+		engine.Set("key_4", "value_4")
+	})
+
+	expected := map[string]string{"key_1": "value_1", "key_2": "value_2"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("want %+v; got %+v", expected, keys)
+	}
+}
+
+func TestEngine_Keys(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+
+	if !engine.Exists("key_1") || engine.Exists("key_3") {
+		t.Errorf("wrong EXISTS result")
+	}
+
+	if err = engine.Rename("key_1", "key_3"); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if engine.Exists("key_1") || engine.DB["key_3"] != "value_1" {
+		t.Errorf("want key_1 renamed to key_3; got %+v", engine.DB)
+	}
+
+	if err = engine.Rename("key_1", "key_3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want %+v; got %+v", storage.ErrNotFound, err)
+	}
+
+	renamed, err := engine.RenameNX("key_3", "key_2")
+	if err != nil || renamed {
+		t.Errorf("want not renamed to existing key; got %t, %+v", renamed, err)
+	}
+
+	renamed, err = engine.RenameNX("key_3", "key_1")
+	if err != nil || !renamed || engine.DB["key_1"] != "value_1" {
+		t.Errorf("want renamed to new key; got %t, %+v", renamed, err)
+	}
+
+	copied, err := engine.Copy("key_1", "key_2", false)
+	if err != nil || copied || engine.DB["key_2"] != "value_2" {
+		t.Errorf("want not copied to existing key; got %t, %+v", copied, err)
+	}
+
+	copied, err = engine.Copy("key_1", "key_2", true)
+	if err != nil || !copied || engine.DB["key_2"] != "value_1" {
+		t.Errorf("want copied with replace; got %t, %+v", copied, err)
+	}
+
+	if _, err = engine.Copy("key_3", "key_4", true); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want %+v; got %+v", storage.ErrNotFound, err)
+	}
+
+	if size := engine.Size(); size != 2 {
+		t.Errorf("want %d; got %d", 2, size)
+	}
+}
@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	NoEviction  = "noeviction"
+	AllKeysLRU  = "allkeys-lru"
+	AllKeysLFU  = "allkeys-lfu"
+	VolatileTTL = "volatile-ttl"
+
+	// entryOverhead - approximate memory taken by a map entry besides the key and the value
+	entryOverhead = 64
+	// evictionSamples - number of keys compared to choose one to evict, like in Redis
+	evictionSamples = 5
+	// lfuDecayPeriod - access counter of a key is halved for every period the key is not accessed
+	lfuDecayPeriod = time.Minute
+)
+
+var ErrOutOfMemory = errors.New("engine: out of memory, command not allowed")
+
+type accessStats struct {
+	lastAccess atomic.Int64
+	hits       atomic.Uint32
+}
+
+func (s *accessStats) touch() {
+	s.lastAccess.Store(time.Now().UnixNano())
+	s.hits.Add(1)
+}
+
+// frequency returns the access counter decayed by the time the key was idle
+func (s *accessStats) frequency(now time.Time) uint32 {
+	idle := now.Sub(time.Unix(0, s.lastAccess.Load())) / lfuDecayPeriod
+	return s.hits.Load() >> min(max(idle, 0), 31)
+}
+
+func validPolicy(policy string) bool {
+	switch policy {
+	case NoEviction, AllKeysLRU, AllKeysLFU, VolatileTTL:
+		return true
+	}
+
+	return false
+}
+
+func entrySize(key, value string) int {
+	return len(key) + len(value) + entryOverhead
+}
+
+// Victims returns keys to evict according to the policy to free room for the value of the key or
+// ErrOutOfMemory when the limit cannot be kept, keys are not removed: the caller deletes them in the order
+// the eviction is logged so that it cannot remove a key written after it
+func (e *Engine) Victims(key, value string) ([]string, error) {
+	if e.maxMemory == 0 {
+		return nil, nil
+	}
+
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	return e.victims(key, e.required(key, value), map[string]bool{key: true})
+}
+
+// RenameVictims returns keys to evict to free room for the source renamed to the destination as Victims does,
+// the memory of the source is freed by the rename
+func (e *Engine) RenameVictims(source, destination string) ([]string, error) {
+	if e.maxMemory == 0 {
+		return nil, nil
+	}
+
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	value, ok := e.DB[source]
+	if !ok {
+		return nil, nil
+	}
+
+	required := e.required(destination, value) - entrySize(source, value)
+	return e.victims(destination, required, map[string]bool{source: true, destination: true})
+}
+
+// required returns the memory taken by writing the value of the key, the lock must be held
+func (e *Engine) required(key, value string) int {
+	required := entrySize(key, value)
+	if old, ok := e.DB[key]; ok {
+		required -= entrySize(key, old)
+	}
+
+	return required
+}
+
+// victims picks keys to evict until the required memory fits the limit, the lock must be held
+func (e *Engine) victims(key string, required int, skipped map[string]bool) ([]string, error) {
+	var victims []string
+	for e.usedMemory+required > e.maxMemory {
+		victim, ok := e.victim(skipped)
+		if !ok {
+			e.logger.Debug("EVICT query [key %s]: out of memory with %s policy", key, e.policy)
+			return nil, ErrOutOfMemory
+		}
+
+		skipped[victim] = true
+		required -= entrySize(victim, e.DB[victim])
+		victims = append(victims, victim)
+	}
+
+	if len(victims) != 0 {
+		e.logger.Debug("successful EVICT query [key %s, %d keys to evict]", key, len(victims))
+	}
+
+	return victims, nil
+}
+
+// victim samples keys and picks the one to evict, skipped keys such as the one being written are never
+// picked and keys which have already expired are dropped before live ones
+func (e *Engine) victim(skipped map[string]bool) (string, bool) {
+	now := time.Now()
+	if expired, ok := e.expired(skipped, now); ok {
+		return expired, true
+	}
+
+	switch e.policy {
+	case AllKeysLRU:
+		return sample(e.stats, skipped, func(candidate, best *accessStats) bool {
+			return candidate.lastAccess.Load() < best.lastAccess.Load()
+		})
+	case AllKeysLFU:
+		return sample(e.stats, skipped, func(candidate, best *accessStats) bool {
+			return candidate.frequency(now) < best.frequency(now)
+		})
+	case VolatileTTL:
+		return sample(e.expires, skipped, func(candidate, best time.Time) bool {
+			return candidate.Before(best)
+		})
+	}
+
+	return "", false
+}
+
+func (e *Engine) expired(skipped map[string]bool, now time.Time) (string, bool) {
+	earliest, ok := sample(e.expires, skipped, func(candidate, best time.Time) bool {
+		return candidate.Before(best)
+	})
+
+	return earliest, ok && !now.Before(e.expires[earliest])
+}
+
+// sample compares a few keys in the map iteration order, which is random, and returns the best candidate
+func sample[V any](candidates map[string]V, skipped map[string]bool, better func(candidate, best V) bool) (string, bool) {
+	var bestKey string
+	var best V
+	found, sampled := false, 0
+	for candidateKey, candidate := range candidates {
+		if skipped[candidateKey] {
+			continue
+		}
+
+		if !found || better(candidate, best) {
+			bestKey, best, found = candidateKey, candidate, true
+		}
+
+		sampled++
+		if sampled == evictionSamples {
+			break
+		}
+	}
+
+	return bestKey, found
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/wal"
+)
+
+func TestEngine_Victims(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		access          func(engine *Engine)
+		expectedEvicted []string
+		expectedError   error
+	}{
+		{
+			name:          "No eviction policy",
+			policy:        NoEviction,
+			expectedError: ErrOutOfMemory,
+		},
+		{
+			name:   "All keys LRU policy",
+			policy: AllKeysLRU,
+			access: func(engine *Engine) {
+				_, _ = engine.Get("key_1")
+			},
+			expectedEvicted: []string{"key_2"},
+		},
+		{
+			name:   "All keys LFU policy",
+			policy: AllKeysLFU,
+			access: func(engine *Engine) {
+				_, _ = engine.Get("key_1")
+				_, _ = engine.Get("key_1")
+				_, _ = engine.Get("key_3")
+			},
+			expectedEvicted: []string{"key_2"},
+		},
+		{
+			name:   "Volatile TTL policy",
+			policy: VolatileTTL,
+			access: func(engine *Engine) {
+				engine.Expire("key_1", time.Now().Add(time.Hour))
+				engine.Expire("key_3", time.Now().Add(time.Minute))
+			},
+			expectedEvicted: []string{"key_3"},
+		},
+		{
+			name:          "Volatile TTL policy without volatile keys",
+			policy:        VolatileTTL,
+			expectedError: ErrOutOfMemory,
+		},
+	}
+
+	logger, _ := common.NewLogger("", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := NewLimitedEngine(3*entrySize("key_1", "value_1"), tt.policy, logger)
+			if err != nil {
+				t.Fatalf("want %+v; got %+v", nil, err)
+			}
+
+			for _, key := range []string{"key_1", "key_2", "key_3"} {
+				engine.Set(key, "value"+key[len("key"):])
+				time.Sleep(time.Millisecond)
+			}
+
+			if tt.access != nil {
+				tt.access(engine)
+			}
+
+			// overwriting a key with a value of the same size needs no room
+			if evicted, err := engine.Victims("key_1", "value_0"); err != nil || len(evicted) != 0 {
+				t.Errorf("want nothing evicted; got %+v, %+v", evicted, err)
+			}
+
+			evicted, err := engine.Victims("key_4", "value_4")
+			if !errors.Is(err, tt.expectedError) {
+				t.Errorf("want %+v; got %+v", tt.expectedError, err)
+			}
+
+			if !reflect.DeepEqual(evicted, tt.expectedEvicted) {
+				t.Errorf("want %+v; got %+v", tt.expectedEvicted, evicted)
+			}
+
+			// victims are removed by the caller
+			if size := engine.Size(); size != 3 {
+				t.Errorf("want %d; got %d", 3, size)
+			}
+		})
+	}
+}
+
+func TestNewLimitedEngine_InvalidPolicy(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	if _, err := NewLimitedEngine(1024, "allkeys-random", logger); err == nil {
+		t.Errorf("want error for unknown policy")
+	}
+}
+
+func TestEngine_Expire(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, _ := NewEngine(logger)
+
+	engine.Set("key_1", "value_1")
+	if engine.Expire("key_2", time.Now().Add(time.Hour)) {
+		t.Errorf("want missing key not expired")
+	}
+
+	if !engine.Expire("key_1", time.Now().Add(-time.Second)) {
+		t.Errorf("want existing key expired")
+	}
+
+	if _, err := engine.Get("key_1"); err == nil {
+		t.Errorf("want expired key not found")
+	}
+
+	if engine.Exists("key_1") || engine.Size() != 0 {
+		t.Errorf("want expired key not counted")
+	}
+
+	engine.Set("key_1", "value_1")
+	if _, err := engine.Get("key_1"); err != nil {
+		t.Errorf("want SET to clear expiry; got %+v", err)
+	}
+}
+
+func TestEngine_EvictLogged(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1MB", DirPath: t.TempDir(), SyncMode: wal.SyncNone}
+
+	open := func(engine storage.Engine) (*storage.Storage, *wal.WAL) {
+		writeAheadLog, err := wal.NewWAL(config, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeAheadLog.Start(context.Background())
+
+		db, err := storage.NewStorage(engine, writeAheadLog, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Start()
+
+		return db, writeAheadLog
+	}
+
+	engine, _ := NewLimitedEngine(2*entrySize("key_000", "value"), AllKeysLRU, logger)
+	db, _ := open(engine)
+
+	// keys written while others evict them must be removed in memory only if recovery removes them
+	keys := []string{"victim"}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key_%03d", i)
+		keys = append(keys, key)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		for _, written := range []string{key, "victim"} {
+			go func() {
+				defer wg.Done()
+				if err := db.Set(written, "value"); err != nil {
+					t.Errorf("want %+v; got %+v", nil, err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	if err := db.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recoveredEngine, _ := NewEngine(logger)
+	recovered, _ := open(recoveredEngine)
+	defer recovered.Stop(context.Background())
+
+	for _, key := range keys {
+		if engine.Exists(key) != recoveredEngine.Exists(key) {
+			t.Fatalf("want key %s recovered as it is in memory %+v; got %+v", key, engine.Exists(key), recoveredEngine.Exists(key))
+		}
+	}
+
+	if size, _ := recovered.Size(); size != engine.Size() {
+		t.Errorf("want %+v; got %+v", engine.Size(), size)
+	}
+}
+
+func TestEngine_PurgeExpired(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, _ := NewEngine(logger)
+
+	for _, key := range []string{"key_1", "key_2", "key_3", "key_4"} {
+		engine.Set(key, "value")
+	}
+
+	for _, key := range []string{"key_1", "key_2", "key_3"} {
+		engine.Expire(key, time.Now().Add(10*time.Millisecond))
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if size := engine.Size(); size != 1 {
+		t.Errorf("want expired keys not counted %+v; got %+v", 1, size)
+	}
+
+	// expired keys are purged when they are accessed
+	_, _ = engine.Get("key_1")
+	engine.Exists("key_2")
+	if _, stored := engine.DB["key_1"]; stored || len(engine.DB) != 2 {
+		t.Errorf("want accessed keys purged; got %+v", engine.DB)
+	}
+
+	if removed := engine.sweep(sweepLimit); removed != 1 || len(engine.DB) != 1 || len(engine.expires) != 0 {
+		t.Errorf("want the rest purged by the sweep; got %+v removed, %+v", removed, engine.DB)
+	}
+
+	if engine.usedMemory != entrySize("key_4", "value") {
+		t.Errorf("want %+v; got %+v", entrySize("key_4", "value"), engine.usedMemory)
+	}
+}
@@ -0,0 +1,80 @@
+package engine
+
+import "math/rand/v2"
+
+const (
+	indexMaxLevel    = 16
+	indexProbability = 0.25
+)
+
+type indexNode struct {
+	key  string
+	next []*indexNode
+}
+
+// index - skiplist of keys keeping them in the ascending order, the engine lock guards it
+type index struct {
+	head  *indexNode
+	level int
+}
+
+func newIndex() *index {
+	return &index{head: &indexNode{next: make([]*indexNode, indexMaxLevel)}, level: 1}
+}
+
+// path returns the last node before the key on every level
+func (idx *index) path(key string) [indexMaxLevel]*indexNode {
+	var update [indexMaxLevel]*indexNode
+	current := idx.head
+	for level := idx.level - 1; level >= 0; level-- {
+		for current.next[level] != nil && current.next[level].key < key {
+			current = current.next[level]
+		}
+		update[level] = current
+	}
+
+	return update
+}
+
+func (idx *index) insert(key string) {
+	update := idx.path(key)
+	if next := update[0].next[0]; next != nil && next.key == key {
+		return
+	}
+
+	level := 1
+	for level < indexMaxLevel && rand.Float64() < indexProbability {
+		level++
+	}
+
+	for ; idx.level < level; idx.level++ {
+		update[idx.level] = idx.head
+	}
+
+	node := &indexNode{key: key, next: make([]*indexNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+}
+
+func (idx *index) remove(key string) {
+	update := idx.path(key)
+	node := update[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+
+	for i := range node.next {
+		update[i].next[i] = node.next[i]
+	}
+
+	for idx.level > 1 && idx.head.next[idx.level-1] == nil {
+		idx.level--
+	}
+}
+
+// seek returns the first node with a key not less than the given one
+func (idx *index) seek(key string) *indexNode {
+	return idx.path(key)[0].next[0]
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+func (e *Engine) Exists(key string) bool {
+	e.m.RLock()
+	_, stored := e.DB[key]
+	ok := stored && e.alive(key, time.Now())
+	e.m.RUnlock()
+
+	if stored && !ok {
+		e.purge(key)
+	}
+
+	return ok
+}
+
+// Expire sets the moment the key expires at, a key expiring in the past is deleted right away,
+// it reports whether the key exists
+func (e *Engine) Expire(key string, expiresAt time.Time) bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	now := time.Now()
+	if _, ok := e.DB[key]; !ok || !e.alive(key, now) {
+		return false
+	}
+
+	if !now.Before(expiresAt) {
+		e.remove(key)
+		e.logger.Debug("successful EXPIRE query [key %s]: key deleted", key)
+		return true
+	}
+
+	e.expires[key] = expiresAt
+
+	e.logger.Debug("successful EXPIRE query [key %s, expires at %s]", key, expiresAt)
+	return true
+}
+
+// Rename moves the value to the destination key overwriting its value
+func (e *Engine) Rename(source, destination string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	_, err := move(e, source, e, destination, true, true)
+	if err != nil {
+		e.logger.Debug("RENAME query [source %s, destination %s]: key not found", source, destination)
+		return err
+	}
+
+	e.logger.Debug("successful RENAME query [source %s, destination %s]", source, destination)
+	return nil
+}
+
+// RenameNX moves the value to the destination key only if it does not exist
+func (e *Engine) RenameNX(source, destination string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	renamed, err := move(e, source, e, destination, false, true)
+	if err != nil {
+		e.logger.Debug("RENAMENX query [source %s, destination %s]: key not found", source, destination)
+		return false, err
+	}
+
+	e.logger.Debug("successful RENAMENX query [source %s, destination %s]", source, destination)
+	return renamed, nil
+}
+
+// Copy copies the value to the destination key, an existing destination is overwritten only with replace
+func (e *Engine) Copy(source, destination string, replace bool) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	copied, err := move(e, source, e, destination, replace, false)
+	if err != nil {
+		e.logger.Debug("COPY query [source %s, destination %s]: key not found", source, destination)
+		return false, err
+	}
+
+	e.logger.Debug("successful COPY query [source %s, destination %s]", source, destination)
+	return copied, nil
+}
+
+// Size returns the number of alive keys
+func (e *Engine) Size() int {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	now, size := time.Now(), len(e.DB)
+	for _, expiresAt := range e.expires {
+		if !now.Before(expiresAt) {
+			size--
+		}
+	}
+
+	return size
+}
+
+// move copies the value with its expiration between engines holding their locks,
+// the source is deleted with remove
+func move(from *Engine, source string, to *Engine, destination string, replace, remove bool) (bool, error) {
+	now := time.Now()
+
+	value, ok := from.DB[source]
+	if !ok || !from.alive(source, now) {
+		return false, storage.ErrNotFound
+	}
+
+	if _, exists := to.DB[destination]; exists && to.alive(destination, now) && !replace {
+		return false, nil
+	}
+
+	expiresAt, expires := from.expires[source]
+	if remove {
+		from.remove(source)
+	}
+
+	to.store(destination, value)
+	delete(to.expires, destination)
+	if expires {
+		to.expires[destination] = expiresAt
+	}
+
+	return true, nil
+}
@@ -0,0 +1,455 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+)
+
+const InMemoryMVCCEngine = "in_memory_mvcc"
+
+// version - a value of the key written by the commit, older versions follow it, a zero expiration
+// moment means the value does not expire
+type version struct {
+	sequence  uint64
+	value     string
+	expiresAt time.Time
+	deleted   bool
+	older     *version
+}
+
+// visible returns the newest version committed up to the sequence
+func (v *version) visible(sequence uint64) *version {
+	for v != nil && v.sequence > sequence {
+		v = v.older
+	}
+
+	return v
+}
+
+// alive reports whether the version holds a value not expired by the moment
+func (v *version) alive(now time.Time) bool {
+	return v != nil && !v.deleted && (v.expiresAt.IsZero() || now.Before(v.expiresAt))
+}
+
+// MVCCEngine - in-memory engine keeping versions of keys, every write gets a commit sequence number
+// and snapshots read keys as of their sequence without blocking writers for longer than a batch of keys
+type MVCCEngine struct {
+	logger *common.Logger
+
+	m        sync.RWMutex
+	versions map[string]*version
+	index    *index
+	sequence uint64
+	// snapshots - number of open snapshots by their sequence
+	snapshots map[uint64]int
+	// versioned - keys having older versions which may become garbage
+	versioned map[string]struct{}
+	// stored - number of keys whose latest version is not a tombstone, expired ones included
+	stored int
+	// expires - expiration moments of the latest versions, expired keys are deleted by the sweep
+	expires map[string]time.Time
+
+	throttles throttles
+
+	// stop - closed by Close, the background sweep ends and marks background done
+	stop       chan struct{}
+	background sync.WaitGroup
+}
+
+func NewMVCCEngine(logger *common.Logger) (*MVCCEngine, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	return &MVCCEngine{
+		logger:    logger,
+		versions:  make(map[string]*version),
+		index:     newIndex(),
+		snapshots: make(map[uint64]int),
+		versioned: make(map[string]struct{}),
+		expires:   make(map[string]time.Time),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// Start launches the background sweep deleting expired keys
+func (e *MVCCEngine) Start() {
+	e.background.Add(1)
+	go func() {
+		defer e.background.Done()
+
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				for e.sweep(sweepLimit) > sweepLimit/4 {
+				}
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background sweep, it must be called once
+func (e *MVCCEngine) Close() error {
+	close(e.stop)
+	e.background.Wait()
+	return nil
+}
+
+func (e *MVCCEngine) Set(key, value string) error {
+	e.m.Lock()
+	sequence := e.commit(key, version{value: value})
+	e.m.Unlock()
+
+	e.logger.Debug("successful SET query [key %s, value %s, sequence %d]", key, value, sequence)
+	return nil
+}
+
+func (e *MVCCEngine) Get(key string) (string, error) {
+	e.m.RLock()
+	value, ok := e.lookup(key, e.sequence, time.Now())
+	e.m.RUnlock()
+
+	if !ok {
+		e.logger.Debug("GET query [key %s]: key not found", key)
+		return "", storage.ErrNotFound
+	}
+
+	e.logger.Info("successful GET query [key %s, value %s]", key, value)
+	return value, nil
+}
+
+// Del commits a tombstone, older versions stay visible to snapshots taken before it
+func (e *MVCCEngine) Del(key string) error {
+	e.m.Lock()
+	if _, ok := e.lookup(key, e.sequence, time.Now()); ok {
+		e.commit(key, version{deleted: true})
+	}
+	e.m.Unlock()
+
+	e.logger.Debug("successful DEL query [key %s]", key)
+	return nil
+}
+
+func (e *MVCCEngine) Exists(key string) bool {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	_, ok := e.lookup(key, e.sequence, time.Now())
+	return ok
+}
+
+// Expire commits the value of the key expiring at the moment, a key expiring in the past is deleted
+// right away, it reports whether the key exists
+func (e *MVCCEngine) Expire(key string, expiresAt time.Time) bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	now := time.Now()
+	head := e.versions[key]
+	if !head.alive(now) {
+		return false
+	}
+
+	if !now.Before(expiresAt) {
+		e.commit(key, version{deleted: true})
+		e.logger.Debug("successful EXPIRE query [key %s]: key deleted", key)
+		return true
+	}
+
+	sequence := e.commit(key, version{value: head.value, expiresAt: expiresAt})
+	e.logger.Debug("successful EXPIRE query [key %s, expires at %s, sequence %d]", key, expiresAt, sequence)
+	return true
+}
+
+// Rename commits the value to the destination key overwriting its value and a tombstone to the source key
+func (e *MVCCEngine) Rename(source, destination string) error {
+	_, err := e.move(source, destination, true, true)
+	if err != nil {
+		e.logger.Debug("RENAME query [source %s, destination %s]: key not found", source, destination)
+		return err
+	}
+
+	e.logger.Debug("successful RENAME query [source %s, destination %s]", source, destination)
+	return nil
+}
+
+// RenameNX renames the key only if the destination key does not exist
+func (e *MVCCEngine) RenameNX(source, destination string) (bool, error) {
+	renamed, err := e.move(source, destination, false, true)
+	if err != nil {
+		e.logger.Debug("RENAMENX query [source %s, destination %s]: key not found", source, destination)
+		return false, err
+	}
+
+	e.logger.Debug("successful RENAMENX query [source %s, destination %s]", source, destination)
+	return renamed, nil
+}
+
+// Copy commits the value to the destination key, an existing destination is overwritten only with replace
+func (e *MVCCEngine) Copy(source, destination string, replace bool) (bool, error) {
+	copied, err := e.move(source, destination, replace, false)
+	if err != nil {
+		e.logger.Debug("COPY query [source %s, destination %s]: key not found", source, destination)
+		return false, err
+	}
+
+	e.logger.Debug("successful COPY query [source %s, destination %s]", source, destination)
+	return copied, nil
+}
+
+// Size returns the number of alive keys
+func (e *MVCCEngine) Size() int {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	now, size := time.Now(), e.stored
+	for _, expiresAt := range e.expires {
+		if !now.Before(expiresAt) {
+			size--
+		}
+	}
+
+	return size
+}
+
+// Throttle atomically accounts a request against the rate limiter stored by the key, rate limiters
+// are not versioned
+func (e *MVCCEngine) Throttle(key string, limit throttle.Limit) throttle.Result {
+	result := e.throttles.take(key, limit)
+	e.logger.Debug("successful THROTTLE query [key %s, allowed %t, remaining %d]", key, result.Allowed, result.Remaining)
+	return result
+}
+
+// move commits the value with its expiration to the destination key, a tombstone is committed to
+// the source key with remove, snapshots see both keys as of before the move or both after it
+func (e *MVCCEngine) move(source, destination string, replace, remove bool) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	now := time.Now()
+	head := e.versions[source]
+	if !head.alive(now) {
+		return false, storage.ErrNotFound
+	}
+
+	if e.versions[destination].alive(now) && !replace {
+		return false, nil
+	}
+
+	if source == destination {
+		return true, nil
+	}
+
+	e.commit(destination, version{value: head.value, expiresAt: head.expiresAt})
+	if remove {
+		e.commit(source, version{deleted: true})
+	}
+
+	return true, nil
+}
+
+// Sequence returns the commit sequence number of the last write
+func (e *MVCCEngine) Sequence() uint64 {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	return e.sequence
+}
+
+// Snapshot pins the current commit sequence number, versions it sees are kept until it is closed
+func (e *MVCCEngine) Snapshot() storage.Snapshot {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.snapshots[e.sequence]++
+	return &snapshot{engine: e, sequence: e.sequence}
+}
+
+// Iterator returns an iterator over a snapshot taken for it, the snapshot is closed with the iterator
+func (e *MVCCEngine) Iterator() storage.Iterator {
+	s := e.Snapshot().(*snapshot)
+	return newIterator(&e.m, e.index, s.lookup, func() {
+		_ = s.Close()
+	})
+}
+
+// Dump pins a snapshot of the latest values, the returned function walks keys seen by it and closes it
+func (e *MVCCEngine) Dump() func(func(key, value string, expiresAt time.Time)) {
+	s := e.Snapshot().(*snapshot)
+	return func(fn func(key, value string, expiresAt time.Time)) {
+		defer func() { _ = s.Close() }()
+
+		keys := s.Iterator()
+		defer func() { _ = keys.Close() }()
+
+		for keys.Next() {
+			e.m.RLock()
+			expiresAt := e.versions[keys.Key()].visible(s.sequence).expiresAt
+			e.m.RUnlock()
+
+			fn(keys.Key(), keys.Value(), expiresAt)
+		}
+	}
+}
+
+// commit adds the version of the key and drops versions no snapshot sees, the lock must be held
+func (e *MVCCEngine) commit(key string, committed version) uint64 {
+	e.sequence++
+	head, ok := e.versions[key]
+	if !ok {
+		e.index.insert(key)
+	}
+
+	if ok && !head.deleted {
+		e.stored--
+	}
+	if !committed.deleted {
+		e.stored++
+	}
+
+	delete(e.expires, key)
+	if !committed.deleted && !committed.expiresAt.IsZero() {
+		e.expires[key] = committed.expiresAt
+	}
+
+	committed.sequence, committed.older = e.sequence, head
+	e.versions[key] = &committed
+	e.collect(key, e.oldestSnapshot())
+	return e.sequence
+}
+
+// lookup returns the value of the key as of the sequence not expired by the moment, the lock must be held
+func (e *MVCCEngine) lookup(key string, sequence uint64, now time.Time) (string, bool) {
+	v := e.versions[key].visible(sequence)
+	if !v.alive(now) {
+		return "", false
+	}
+
+	return v.value, true
+}
+
+// sweep deletes expired keys among at most limit expiring keys and returns the number of deleted ones
+func (e *MVCCEngine) sweep(limit int) int {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	now, deleted := time.Now(), 0
+	for key, expiresAt := range e.expires {
+		if limit == 0 {
+			break
+		}
+		limit--
+
+		if !now.Before(expiresAt) {
+			e.commit(key, version{deleted: true})
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// oldestSnapshot returns the sequence of the oldest open snapshot or the last commit, the lock must be held
+func (e *MVCCEngine) oldestSnapshot() uint64 {
+	oldest := e.sequence
+	for sequence := range e.snapshots {
+		oldest = min(oldest, sequence)
+	}
+
+	return oldest
+}
+
+// collect drops versions of the key older than the one seen by the oldest snapshot,
+// a key whose only version is a tombstone is removed, the lock must be held
+func (e *MVCCEngine) collect(key string, oldest uint64) {
+	head := e.versions[key]
+	if v := head.visible(oldest); v != nil {
+		v.older = nil
+	}
+
+	switch {
+	case head.older == nil && head.deleted:
+		delete(e.versions, key)
+		delete(e.versioned, key)
+		e.index.remove(key)
+	case head.older == nil:
+		delete(e.versioned, key)
+	default:
+		e.versioned[key] = struct{}{}
+	}
+}
+
+// release closes the snapshot, garbage of keys with older versions is collected if it was the oldest one
+func (e *MVCCEngine) release(sequence uint64) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	previous := e.oldestSnapshot()
+	e.snapshots[sequence]--
+	if e.snapshots[sequence] == 0 {
+		delete(e.snapshots, sequence)
+	}
+
+	oldest := e.oldestSnapshot()
+	if oldest == previous {
+		return
+	}
+
+	collected := len(e.versioned)
+	for key := range e.versioned {
+		e.collect(key, oldest)
+	}
+
+	e.logger.Debug("versions have been collected [%d keys, %d keys still versioned]", collected, len(e.versioned))
+}
+
+type snapshot struct {
+	engine   *MVCCEngine
+	sequence uint64
+
+	once sync.Once
+}
+
+func (s *snapshot) Sequence() uint64 {
+	return s.sequence
+}
+
+func (s *snapshot) Get(key string) (string, error) {
+	s.engine.m.RLock()
+	value, ok := s.engine.lookup(key, s.sequence, time.Now())
+	s.engine.m.RUnlock()
+
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+
+	return value, nil
+}
+
+// Iterator returns an iterator over keys seen by the snapshot, it must be closed before the snapshot
+func (s *snapshot) Iterator() storage.Iterator {
+	return newIterator(&s.engine.m, s.engine.index, s.lookup, nil)
+}
+
+func (s *snapshot) Close() error {
+	s.once.Do(func() {
+		s.engine.release(s.sequence)
+	})
+
+	return nil
+}
+
+// lookup returns the value seen by the snapshot, the read lock is held by the iterator
+func (s *snapshot) lookup(key string, now time.Time) (string, bool) {
+	return s.engine.lookup(key, s.sequence, now)
+}
@@ -0,0 +1,342 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+)
+
+func newTestMVCCEngine(t *testing.T) *MVCCEngine {
+	t.Helper()
+
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewMVCCEngine(logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	return engine
+}
+
+func versionsCount(engine *MVCCEngine, key string) int {
+	engine.m.RLock()
+	defer engine.m.RUnlock()
+
+	count := 0
+	for v := engine.versions[key]; v != nil; v = v.older {
+		count++
+	}
+
+	return count
+}
+
+func TestNewMVCCEngine(t *testing.T) {
+	if _, err := NewMVCCEngine(nil); err == nil {
+		t.Errorf("want error without logger")
+	}
+}
+
+func TestMVCCEngine_Snapshot(t *testing.T) {
+	engine := newTestMVCCEngine(t)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+
+	snapshot := engine.Snapshot()
+	if snapshot.Sequence() != 2 {
+		t.Errorf("want snapshot at sequence 2; got %d", snapshot.Sequence())
+	}
+
+	engine.Set("key_1", "new_value_1")
+	engine.Del("key_2")
+	engine.Set("key_3", "value_3")
+
+	tests := []struct {
+		key             string
+		expectedLatest  string
+		expectedInStale string
+	}{
+		{key: "key_1", expectedLatest: "new_value_1", expectedInStale: "value_1"},
+		{key: "key_2", expectedInStale: "value_2"},
+		{key: "key_3", expectedLatest: "value_3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			for _, read := range []struct {
+				get      func(string) (string, error)
+				expected string
+			}{
+				{get: engine.Get, expected: tt.expectedLatest},
+				{get: snapshot.Get, expected: tt.expectedInStale},
+			} {
+				value, err := read.get(tt.key)
+				if read.expected == "" && !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("want %s not found; got %s, %+v", tt.key, value, err)
+				} else if read.expected != "" && (err != nil || value != read.expected) {
+					t.Errorf("want %s; got %s, %+v", read.expected, value, err)
+				}
+			}
+		})
+	}
+
+	iterator := snapshot.Iterator()
+	var keys []string
+	for iterator.Next() {
+		keys = append(keys, iterator.Key()+"="+iterator.Value())
+	}
+	_ = iterator.Close()
+
+	if !reflect.DeepEqual(keys, []string{"key_1=value_1", "key_2=value_2"}) {
+		t.Errorf("want keys as of the snapshot; got %+v", keys)
+	}
+
+	_ = snapshot.Close()
+	_ = snapshot.Close()
+
+	engine.m.RLock()
+	snapshots := len(engine.snapshots)
+	engine.m.RUnlock()
+	if snapshots != 0 {
+		t.Errorf("want snapshot released once; got %d open", snapshots)
+	}
+}
+
+func TestMVCCEngine_Dump(t *testing.T) {
+	engine := newTestMVCCEngine(t)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+
+	dump := engine.Dump()
+	engine.Set("key_1", "new_value_1")
+	engine.Del("key_2")
+
+	keys := make(map[string]string)
+	dump(func(key, value string, _ time.Time) {
+		keys[key] = value
+		engine.Set("key_3", "value_3")
+	})
+
+	expected := map[string]string{"key_1": "value_1", "key_2": "value_2"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("want %+v; got %+v", expected, keys)
+	}
+
+	if count := versionsCount(engine, "key_1"); count != 1 {
+		t.Errorf("want snapshot of the dump released; got %d versions", count)
+	}
+}
+
+func TestMVCCEngine_Keys(t *testing.T) {
+	engine := newTestMVCCEngine(t)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+	snapshot := engine.Snapshot()
+	defer snapshot.Close()
+
+	if !engine.Exists("key_1") || engine.Exists("key_3") {
+		t.Errorf("wrong EXISTS result")
+	}
+
+	if err := engine.Rename("key_1", "key_3"); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err := engine.Rename("key_1", "key_3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want %+v; got %+v", storage.ErrNotFound, err)
+	}
+
+	if value, err := snapshot.Get("key_1"); err != nil || value != "value_1" {
+		t.Errorf("want renamed key seen by the older snapshot; got %s, %+v", value, err)
+	}
+
+	renamed, err := engine.RenameNX("key_3", "key_2")
+	if err != nil || renamed {
+		t.Errorf("want not renamed to existing key; got %t, %+v", renamed, err)
+	}
+
+	copied, err := engine.Copy("key_3", "key_2", true)
+	if err != nil || !copied {
+		t.Errorf("want copied over existing key; got %t, %+v", copied, err)
+	}
+
+	tests := map[string]string{"key_1": "", "key_2": "value_1", "key_3": "value_1"}
+	for key, expected := range tests {
+		value, err := engine.Get(key)
+		if expected == "" && !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("want %s not found; got %s, %+v", key, value, err)
+		} else if expected != "" && (err != nil || value != expected) {
+			t.Errorf("want %s for %s; got %s, %+v", expected, key, value, err)
+		}
+	}
+
+	if size := engine.Size(); size != 2 {
+		t.Errorf("want 2 keys; got %d", size)
+	}
+
+	limit := throttle.Limit{MaxBurst: 0, Count: 1, Period: time.Hour}
+	if !engine.Throttle("user", limit).Allowed || engine.Throttle("user", limit).Allowed {
+		t.Errorf("want the second request throttled")
+	}
+}
+
+func TestMVCCEngine_Expire(t *testing.T) {
+	engine := newTestMVCCEngine(t)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+	engine.Set("key_3", "value_3")
+
+	expiresAt := time.Now().Add(time.Hour)
+	if !engine.Expire("key_1", expiresAt) || !engine.Expire("key_2", time.Now().Add(-time.Second)) {
+		t.Errorf("want existing keys expired")
+	}
+
+	if engine.Expire("key_4", expiresAt) {
+		t.Errorf("want missing key not expired")
+	}
+
+	if engine.Exists("key_2") {
+		t.Errorf("want key expiring in the past deleted")
+	}
+
+	// the copy keeps the expiration which the dump returns
+	engine.Copy("key_1", "key_4", false)
+	expirations := make(map[string]time.Time)
+	engine.Dump()(func(key, _ string, expiresAt time.Time) {
+		expirations[key] = expiresAt
+	})
+
+	expected := map[string]time.Time{"key_1": expiresAt, "key_3": {}, "key_4": expiresAt}
+	if !reflect.DeepEqual(expirations, expected) {
+		t.Errorf("want %+v; got %+v", expected, expirations)
+	}
+
+	engine.Expire("key_3", time.Now().Add(time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	if engine.Exists("key_3") || engine.Size() != 2 {
+		t.Errorf("want expired key not counted; got %d keys", engine.Size())
+	}
+
+	if deleted := engine.sweep(sweepLimit); deleted != 1 || engine.Size() != 2 {
+		t.Errorf("want expired key swept; got %d deleted, %d keys", deleted, engine.Size())
+	}
+
+	// a new value does not expire
+	engine.Set("key_1", "new_value_1")
+	engine.m.RLock()
+	_, expiring := engine.expires["key_1"]
+	engine.m.RUnlock()
+
+	if expiring || !engine.Exists("key_1") {
+		t.Errorf("want overwritten key not to expire")
+	}
+}
+
+func TestMVCCEngine_GarbageCollection(t *testing.T) {
+	engine := newTestMVCCEngine(t)
+
+	// without snapshots only the latest version is kept
+	for i := 0; i < 10; i++ {
+		engine.Set("key", fmt.Sprintf("value_%d", i))
+	}
+
+	if count := versionsCount(engine, "key"); count != 1 {
+		t.Errorf("want 1 version; got %d", count)
+	}
+
+	first := engine.Snapshot()
+	engine.Set("key", "value_10")
+	second := engine.Snapshot()
+	engine.Set("key", "value_11")
+	engine.Del("key")
+
+	if count := versionsCount(engine, "key"); count != 4 {
+		t.Errorf("want 4 versions seen by snapshots; got %d", count)
+	}
+
+	_ = first.Close()
+	if count := versionsCount(engine, "key"); count != 3 {
+		t.Errorf("want 3 versions after closing the first snapshot; got %d", count)
+	}
+
+	_ = second.Close()
+	engine.m.RLock()
+	_, ok := engine.versions["key"]
+	versioned := len(engine.versioned)
+	engine.m.RUnlock()
+
+	if ok || versioned != 0 {
+		t.Errorf("want deleted key collected; got %t, %d versioned keys", ok, versioned)
+	}
+
+	iterator := engine.Iterator()
+	if iterator.Next() {
+		t.Errorf("want no keys; got %s", iterator.Key())
+	}
+	_ = iterator.Close()
+}
+
+func TestMVCCEngine_ConsistentIterator(t *testing.T) {
+	engine := newTestMVCCEngine(t)
+
+	// every write moves a unit between accounts, a consistent view always sums up to the same total
+	const accounts = 3 * iteratorBatch
+	for i := 0; i < accounts; i++ {
+		engine.Set(fmt.Sprintf("account_%04d", i), "10")
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			from, to := fmt.Sprintf("account_%04d", i%accounts), fmt.Sprintf("account_%04d", (i+1)%accounts)
+			engine.m.Lock()
+			fromValue, _ := engine.lookup(from, engine.sequence, time.Now())
+			toValue, _ := engine.lookup(to, engine.sequence, time.Now())
+			var fromBalance, toBalance int
+			fmt.Sscan(fromValue, &fromBalance)
+			fmt.Sscan(toValue, &toBalance)
+			engine.commit(from, version{value: fmt.Sprint(fromBalance - 1)})
+			engine.commit(to, version{value: fmt.Sprint(toBalance + 1)})
+			engine.m.Unlock()
+		}
+	}()
+
+	for round := 0; round < 20; round++ {
+		iterator := engine.Iterator()
+		total := 0
+		for iterator.Next() {
+			var balance int
+			fmt.Sscan(iterator.Value(), &balance)
+			total += balance
+		}
+		_ = iterator.Close()
+
+		// transfers are two commits, a snapshot may be taken between them
+		if total != 10*accounts && total != 10*accounts-1 {
+			t.Errorf("want consistent total; got %d", total)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+const (
+	InMemoryOrderedEngine = "in_memory_ordered"
+
+	// iteratorBatch - number of keys an iterator copies under one read lock, writes never wait for more
+	iteratorBatch = 128
+	// iteratorScan - number of keys an iterator walks under one read lock including the skipped ones,
+	// so that a run of expired keys does not hold the lock
+	iteratorScan = 4 * iteratorBatch
+)
+
+// OrderedEngine - in-memory engine which also keeps its keys in the ascending order for range iteration
+type OrderedEngine struct {
+	*Engine
+}
+
+func NewOrderedEngine(maxMemory int, policy string, logger *common.Logger) (*OrderedEngine, error) {
+	engine, err := NewLimitedEngine(maxMemory, policy, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	engine.index = newIndex()
+	return &OrderedEngine{Engine: engine}, nil
+}
+
+// Iterator returns an iterator over all keys, it is not a snapshot: a batch of keys is copied at a time
+// so keys changed during the iteration are seen if they are not passed yet
+func (e *OrderedEngine) Iterator() storage.Iterator {
+	return newIterator(&e.m, e.index, func(key string, now time.Time) (string, bool) {
+		return e.DB[key], e.alive(key, now)
+	}, nil)
+}
+
+type entry struct {
+	key   string
+	value string
+}
+
+type iterator struct {
+	m     *sync.RWMutex
+	index *index
+	// lookup returns the value of the indexed key seen by the iterator, the read lock is held
+	lookup func(string, time.Time) (string, bool)
+	// release is called once the iterator is closed
+	release func()
+
+	// from - the key the next batch starts at, the key itself is skipped unless inclusive
+	from      string
+	inclusive bool
+	exhausted bool
+	closed    bool
+
+	batch    []entry
+	position int
+}
+
+func newIterator(m *sync.RWMutex, idx *index, lookup func(string, time.Time) (string, bool), release func()) *iterator {
+	return &iterator{m: m, index: idx, lookup: lookup, release: release, inclusive: true, position: -1}
+}
+
+func (it *iterator) Seek(key string) {
+	it.from, it.inclusive, it.exhausted = key, true, false
+	it.batch, it.position = nil, -1
+}
+
+func (it *iterator) Next() bool {
+	if it.closed {
+		return false
+	}
+
+	it.position++
+	if it.position < len(it.batch) {
+		return true
+	}
+
+	if it.exhausted {
+		return false
+	}
+
+	// a batch of skipped keys only is empty, the following keys are walked under a new read lock
+	it.batch, it.position = it.batch[:0], 0
+	for len(it.batch) == 0 && !it.exhausted {
+		it.fill()
+	}
+
+	return len(it.batch) > 0
+}
+
+func (it *iterator) Key() string {
+	return it.batch[it.position].key
+}
+
+func (it *iterator) Value() string {
+	return it.batch[it.position].value
+}
+
+func (it *iterator) Close() error {
+	if !it.closed && it.release != nil {
+		it.release()
+	}
+
+	it.closed, it.batch = true, nil
+	return nil
+}
+
+// fill copies the next batch of keys seen by the iterator, other keys are skipped, at most
+// iteratorScan keys are walked
+func (it *iterator) fill() {
+	it.m.RLock()
+	defer it.m.RUnlock()
+
+	it.batch = it.batch[:0]
+	now := time.Now()
+	node := it.index.seek(it.from)
+	if node != nil && !it.inclusive && node.key == it.from {
+		node = node.next[0]
+	}
+
+	for scanned := 0; node != nil && len(it.batch) < iteratorBatch && scanned < iteratorScan; node = node.next[0] {
+		scanned++
+		it.from, it.inclusive = node.key, false
+		if value, ok := it.lookup(node.key, now); ok {
+			it.batch = append(it.batch, entry{key: node.key, value: value})
+		}
+	}
+
+	it.exhausted = node == nil
+}
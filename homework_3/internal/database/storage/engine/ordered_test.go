@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+func newTestOrderedEngine(t *testing.T) *OrderedEngine {
+	t.Helper()
+
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewOrderedEngine(0, "", logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	return engine
+}
+
+func collect(iterator storage.Iterator, from string) []string {
+	var keys []string
+	for iterator.Seek(from); iterator.Next(); {
+		keys = append(keys, iterator.Key()+"="+iterator.Value())
+	}
+
+	return keys
+}
+
+func TestOrderedEngine_Iterator(t *testing.T) {
+	engine := newTestOrderedEngine(t)
+
+	engine.Set("c", "3")
+	engine.Set("a", "1")
+	engine.Set("d", "4")
+	engine.Set("b", "2")
+	engine.Set("a", "10")
+	engine.Del("d")
+	if err := engine.Rename("b", "e"); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	engine.Set("expired", "0")
+	engine.Expire("expired", time.Now().Add(time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+
+	iterator := engine.Iterator()
+	defer iterator.Close()
+
+	if keys := collect(iterator, ""); !reflect.DeepEqual(keys, []string{"a=10", "c=3", "e=2"}) {
+		t.Errorf("want all keys in order; got %+v", keys)
+	}
+
+	if keys := collect(iterator, "b"); !reflect.DeepEqual(keys, []string{"c=3", "e=2"}) {
+		t.Errorf("want keys from b; got %+v", keys)
+	}
+
+	if keys := collect(iterator, "f"); len(keys) != 0 {
+		t.Errorf("want no keys after f; got %+v", keys)
+	}
+}
+
+func TestOrderedEngine_IteratorBatches(t *testing.T) {
+	engine := newTestOrderedEngine(t)
+
+	const count = 3 * iteratorBatch
+	for i := 0; i < count; i++ {
+		engine.Set(fmt.Sprintf("key_%04d", i), "value")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i += 2 {
+			engine.Del(fmt.Sprintf("key_%04d", i))
+			engine.Set(fmt.Sprintf("key_%04d_new", i), "value")
+		}
+	}()
+
+	// keys are changed while iterating, the order is kept and no key is seen twice
+	iterator := engine.Iterator()
+	previous, seen := "", 0
+	for iterator.Next() {
+		if iterator.Key() <= previous {
+			t.Fatalf("want keys in ascending order; got %s after %s", iterator.Key(), previous)
+		}
+		previous = iterator.Key()
+		seen++
+	}
+	_ = iterator.Close()
+	wg.Wait()
+
+	if seen < count/2 {
+		t.Errorf("want at least %d keys; got %d", count/2, seen)
+	}
+
+	if iterator.Next() {
+		t.Errorf("want no keys after closing")
+	}
+}
+
+func TestOrderedEngine_IteratorExpired(t *testing.T) {
+	engine := newTestOrderedEngine(t)
+
+	const count = 3 * iteratorScan
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("a_%04d", i)
+		engine.Set(key, "value")
+		engine.expires[key] = time.Now().Add(-time.Second)
+	}
+	engine.Set("b", "1")
+
+	keys := engine.Iterator()
+	defer keys.Close()
+
+	// expired keys are skipped by batches walked under separate read locks
+	it := keys.(*iterator)
+	it.fill()
+	if len(it.batch) != 0 || it.exhausted || it.from != fmt.Sprintf("a_%04d", iteratorScan-1) {
+		t.Errorf("want %d expired keys walked; got %+v, exhausted %t, from %s", iteratorScan, it.batch, it.exhausted, it.from)
+	}
+
+	if collected := collect(keys, ""); !reflect.DeepEqual(collected, []string{"b=1"}) {
+		t.Errorf("want expired keys skipped; got %+v", collected)
+	}
+}
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"errors"
+	"hash/maphash"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+)
+
+const (
+	InMemoryShardedEngine = "in_memory_sharded"
+
+	defaultShardsCount = 32
+)
+
+// ShardedEngine - in-memory engine which partitions keys between independently locked shards
+type ShardedEngine struct {
+	seed   maphash.Seed
+	shards []*Engine
+	logger *common.Logger
+}
+
+func NewShardedEngine(shardsCount, maxMemory int, policy string, logger *common.Logger) (*ShardedEngine, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	if shardsCount <= 0 {
+		shardsCount = defaultShardsCount
+	}
+
+	shardMemory := 0
+	if maxMemory > 0 {
+		shardMemory = max(maxMemory/shardsCount, 1)
+	}
+
+	shards := make([]*Engine, 0, shardsCount)
+	for i := 0; i < shardsCount; i++ {
+		shard, err := NewLimitedEngine(shardMemory, policy, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		shards = append(shards, shard)
+	}
+
+	return &ShardedEngine{
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+		logger: logger,
+	}, nil
+}
+
+// Start launches background sweeps of all shards
+func (e *ShardedEngine) Start() {
+	for _, shard := range e.shards {
+		shard.Start()
+	}
+}
+
+// Close stops background sweeps of all shards, it must be called once
+func (e *ShardedEngine) Close() error {
+	for _, shard := range e.shards {
+		_ = shard.Close()
+	}
+
+	return nil
+}
+
+func (e *ShardedEngine) Set(key, value string) error {
+	return e.shard(key).Set(key, value)
+}
+
+func (e *ShardedEngine) Get(key string) (string, error) {
+	return e.shard(key).Get(key)
+}
+
+func (e *ShardedEngine) Del(key string) error {
+	return e.shard(key).Del(key)
+}
+
+func (e *ShardedEngine) Exists(key string) bool {
+	return e.shard(key).Exists(key)
+}
+
+func (e *ShardedEngine) Expire(key string, expiresAt time.Time) bool {
+	return e.shard(key).Expire(key, expiresAt)
+}
+
+// Victims returns keys to evict to free room for the value in the shard of the key, every shard gets
+// an equal part of the memory limit
+func (e *ShardedEngine) Victims(key, value string) ([]string, error) {
+	return e.shard(key).Victims(key, value)
+}
+
+// RenameVictims returns keys to evict to free room for the source renamed to the destination, a key renamed
+// to another shard takes the memory of the destination shard
+func (e *ShardedEngine) RenameVictims(source, destination string) ([]string, error) {
+	sourceShard, destinationShard := e.shard(source), e.shard(destination)
+	if sourceShard == destinationShard {
+		return sourceShard.RenameVictims(source, destination)
+	}
+
+	sourceShard.m.RLock()
+	value, ok := sourceShard.DB[source]
+	sourceShard.m.RUnlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	return destinationShard.Victims(destination, value)
+}
+
+func (e *ShardedEngine) Rename(source, destination string) error {
+	_, err := e.move(source, destination, true, true)
+	return err
+}
+
+func (e *ShardedEngine) RenameNX(source, destination string) (bool, error) {
+	return e.move(source, destination, false, true)
+}
+
+func (e *ShardedEngine) Copy(source, destination string, replace bool) (bool, error) {
+	return e.move(source, destination, replace, false)
+}
+
+// Size returns the number of alive keys, shards are counted one by one
+func (e *ShardedEngine) Size() int {
+	size := 0
+	for _, shard := range e.shards {
+		size += shard.Size()
+	}
+
+	return size
+}
+
+// Dump copies keys of all shards one by one, the returned function walks keys of every shard
+func (e *ShardedEngine) Dump() func(func(key, value string, expiresAt time.Time)) {
+	dumps := make([]func(func(string, string, time.Time)), len(e.shards))
+	for i, shard := range e.shards {
+		dumps[i] = shard.Dump()
+	}
+
+	return func(fn func(key, value string, expiresAt time.Time)) {
+		for _, dump := range dumps {
+			dump(fn)
+		}
+	}
+}
+
+func (e *ShardedEngine) Throttle(key string, limit throttle.Limit) throttle.Result {
+	return e.shard(key).Throttle(key, limit)
+}
+
+// move copies the value between keys holding locks of both shards, the source is deleted with remove
+func (e *ShardedEngine) move(source, destination string, replace, remove bool) (bool, error) {
+	sourceShard, destinationShard := e.shardIndex(source), e.shardIndex(destination)
+	unlock := e.lockShards(sourceShard, destinationShard)
+	defer unlock()
+
+	moved, err := move(e.shards[sourceShard], source, e.shards[destinationShard], destination, replace, remove)
+	if err != nil {
+		e.logger.Debug("move query [source %s, destination %s]: key not found", source, destination)
+		return false, err
+	}
+
+	e.logger.Debug("successful move query [source %s, destination %s]", source, destination)
+	return moved, nil
+}
+
+// lockShards locks shards in the order of their indexes to avoid deadlocks between concurrent moves
+func (e *ShardedEngine) lockShards(first, second int) func() {
+	if first == second {
+		e.shards[first].m.Lock()
+		return e.shards[first].m.Unlock
+	}
+
+	low, high := min(first, second), max(first, second)
+	e.shards[low].m.Lock()
+	e.shards[high].m.Lock()
+
+	return func() {
+		e.shards[high].m.Unlock()
+		e.shards[low].m.Unlock()
+	}
+}
+
+func (e *ShardedEngine) shard(key string) *Engine {
+	return e.shards[e.shardIndex(key)]
+}
+
+func (e *ShardedEngine) shardIndex(key string) int {
+	return int(maphash.String(e.seed, key) % uint64(len(e.shards)))
+}
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+)
+
+func TestNewShardedEngine(t *testing.T) {
+	tests := []struct {
+		name                string
+		logger              *common.Logger
+		shardsCount         int
+		expectedShardsCount int
+		expectedError       error
+	}{
+		{
+			name:          "New sharded engine without logger",
+			expectedError: errors.New("logger is invalid"),
+		},
+		{
+			name: "New sharded engine with default shards count",
+			logger: func() *common.Logger {
+				logger, _ := common.NewLogger("", "")
+				return logger
+			}(),
+			expectedShardsCount: defaultShardsCount,
+		},
+		{
+			name: "New sharded engine with shards count",
+			logger: func() *common.Logger {
+				logger, _ := common.NewLogger("", "")
+				return logger
+			}(),
+			shardsCount:         4,
+			expectedShardsCount: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := NewShardedEngine(tt.shardsCount, 0, "", tt.logger)
+
+			if tt.expectedError != nil {
+				if err == nil || tt.expectedError.Error() != err.Error() {
+					t.Errorf("want %+v; got %+v", tt.expectedError, err)
+				}
+
+				if engine != nil {
+					t.Errorf("want %+v; got %+v", nil, engine)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("want %+v; got %+v", nil, err)
+				}
+
+				if len(engine.shards) != tt.expectedShardsCount {
+					t.Errorf("want %d shards; got %d", tt.expectedShardsCount, len(engine.shards))
+				}
+			}
+		})
+	}
+}
+
+func TestShardedEngine_SetGetDel(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, _ := NewShardedEngine(8, 0, "", logger)
+
+	for i := 0; i < 100; i++ {
+		engine.Set(strconv.Itoa(i), "value_"+strconv.Itoa(i))
+	}
+
+	for i := 0; i < 100; i++ {
+		value, err := engine.Get(strconv.Itoa(i))
+		if err != nil || value != "value_"+strconv.Itoa(i) {
+			t.Errorf("want %s; got %s, %+v", "value_"+strconv.Itoa(i), value, err)
+		}
+	}
+
+	engine.Del("42")
+	if _, err := engine.Get("42"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want %+v; got %+v", storage.ErrNotFound, err)
+	}
+
+	if size := engine.Size(); size != 99 {
+		t.Errorf("want %d; got %d", 99, size)
+	}
+}
+
+func TestShardedEngine_Keys(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, _ := NewShardedEngine(8, 0, "", logger)
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+
+	if err := engine.Rename("key_1", "key_3"); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if engine.Exists("key_1") || !engine.Exists("key_3") {
+		t.Errorf("want key_1 renamed to key_3")
+	}
+
+	if renamed, err := engine.RenameNX("key_3", "key_2"); err != nil || renamed {
+		t.Errorf("want not renamed to existing key; got %t, %+v", renamed, err)
+	}
+
+	if copied, err := engine.Copy("key_3", "key_2", true); err != nil || !copied {
+		t.Errorf("want copied with replace; got %t, %+v", copied, err)
+	}
+
+	if value, _ := engine.Get("key_2"); value != "value_1" {
+		t.Errorf("want %s; got %s", "value_1", value)
+	}
+
+	if _, err := engine.Copy("unknown", "key_2", true); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want %+v; got %+v", storage.ErrNotFound, err)
+	}
+
+	if result := engine.Throttle("key", throttle.Limit{Count: 1, Period: time.Hour}); !result.Allowed {
+		t.Errorf("want allowed; got %+v", result)
+	}
+}
+
+func TestShardedEngine_RenameVictims(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	// every shard holds two keys of the test
+	engine, _ := NewShardedEngine(2, 4*entrySize("key_00", "value"), AllKeysLRU, logger)
+
+	keys := make([][]string, 2)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%02d", i)
+		keys[engine.shardIndex(key)] = append(keys[engine.shardIndex(key)], key)
+	}
+
+	for _, shardKeys := range keys {
+		for _, key := range shardKeys[:2] {
+			if err := engine.Set(key, "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// a key renamed within its shard takes the memory it frees
+	if victims, err := engine.RenameVictims(keys[0][0], keys[0][2]); err != nil || len(victims) != 0 {
+		t.Errorf("want no victims of a rename within the shard; got %+v, %+v", victims, err)
+	}
+
+	victims, err := engine.RenameVictims(keys[0][0], keys[1][2])
+	if err != nil || len(victims) != 1 || engine.shardIndex(victims[0]) != 1 {
+		t.Fatalf("want a victim in the destination shard; got %+v, %+v", victims, err)
+	}
+
+	db, err := storage.NewStorage(engine, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.Rename(keys[0][0], keys[1][2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if used := engine.shards[0].usedMemory; used != entrySize("key_00", "value") {
+		t.Errorf("want memory of the source freed; got %d", used)
+	}
+
+	if used := engine.shards[1].usedMemory; used != 2*entrySize("key_00", "value") {
+		t.Errorf("want memory of the destination kept within the limit; got %d", used)
+	}
+}
+
+func TestShardedEngine_ConcurrentRenames(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, _ := NewShardedEngine(4, 0, "", logger)
+
+	const keysCount = 64
+	for i := 0; i < keysCount; i++ {
+		engine.Set("a_"+strconv.Itoa(i), "value")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, direction := range [][2]string{{"a_", "b_"}, {"b_", "a_"}} {
+		go func(from, to string) {
+			defer wg.Done()
+			for round := 0; round < 10; round++ {
+				for i := 0; i < keysCount; i++ {
+					_ = engine.Rename(from+strconv.Itoa(i), to+strconv.Itoa(keysCount-i-1))
+				}
+			}
+		}(direction[0], direction[1])
+	}
+	wg.Wait()
+
+	if size := engine.Size(); size != keysCount {
+		t.Errorf("want %d keys after concurrent renames; got %d", keysCount, size)
+	}
+}
+
+// Run with -cpu 1,2,4,8 to compare how both engines scale with GOMAXPROCS
+func BenchmarkEngine_Parallel(b *testing.B) {
+	logger, _ := common.NewLogger("", "")
+	engine, _ := NewEngine(logger)
+	benchmarkParallel(b, engine)
+}
+
+func BenchmarkShardedEngine_Parallel(b *testing.B) {
+	logger, _ := common.NewLogger("", "")
+	engine, _ := NewShardedEngine(defaultShardsCount, 0, "", logger)
+	benchmarkParallel(b, engine)
+}
+
+// benchmarkParallel runs a read-heavy workload with every tenth query being a write
+func benchmarkParallel(b *testing.B, engine storage.Engine) {
+	const keysCount = 1024
+	keys := make([]string, keysCount)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+		engine.Set(keys[i], "value")
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%keysCount]
+			if i%10 == 0 {
+				engine.Set(key, "value")
+			} else {
+				_, _ = engine.Get(key)
+			}
+			i++
+		}
+	})
+}
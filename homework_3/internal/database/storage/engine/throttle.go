@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+)
+
+// throttlesSweepPeriod - number of THROTTLE queries between sweeps of idle rate limiters
+const throttlesSweepPeriod = 1024
+
+type rateLimiter struct {
+	limit throttle.Limit
+	state throttle.State
+}
+
+// throttles - rate limiters by their keys, guarded apart from keys so that THROTTLE does not wait for key changes
+type throttles struct {
+	mutex    sync.Mutex
+	limiters map[string]*rateLimiter
+	calls    int
+}
+
+// take atomically accounts a request against the rate limiter stored by the key
+func (t *throttles) take(key string, limit throttle.Limit) throttle.Result {
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.limiters == nil {
+		t.limiters = make(map[string]*rateLimiter)
+	}
+
+	limiter, ok := t.limiters[key]
+	if !ok {
+		limiter = &rateLimiter{}
+		t.limiters[key] = limiter
+	}
+
+	limiter.limit = limit
+	result := limit.Take(&limiter.state, now)
+
+	t.calls++
+	if t.calls%throttlesSweepPeriod == 0 {
+		t.sweep(now)
+	}
+
+	return result
+}
+
+// sweep drops idle rate limiters, the mutex must be held
+func (t *throttles) sweep(now time.Time) {
+	for key, limiter := range t.limiters {
+		if limiter.limit.Idle(&limiter.state, now) {
+			delete(t.limiters, key)
+		}
+	}
+}
+
+// Throttle atomically accounts a request against the rate limiter stored by the key
+func (e *Engine) Throttle(key string, limit throttle.Limit) throttle.Result {
+	result := e.throttles.take(key, limit)
+	e.logger.Debug("successful THROTTLE query [key %s, allowed %t, remaining %d]", key, result.Allowed, result.Remaining)
+	return result
+}
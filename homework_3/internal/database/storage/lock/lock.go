@@ -0,0 +1,155 @@
+package lock
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var now = time.Now
+
+var (
+	ErrLocked     = errors.New("lock: already held by another owner")
+	ErrNotOwner   = errors.New("lock: not held by the owner")
+	ErrInvalidTTL = errors.New("lock: invalid lease TTL")
+)
+
+// Lease - a granted lock
+type Lease struct {
+	Owner     string
+	Token     uint64
+	ExpiresAt time.Time
+}
+
+// Manager - named locks with expiring leases and fencing tokens
+type Manager struct {
+	mutex     sync.Mutex
+	leases    map[string]Lease
+	lastToken uint64
+}
+
+func NewManager() *Manager {
+	return &Manager{leases: make(map[string]Lease)}
+}
+
+// Grant issues a lease for the owner if the lock is free or its lease has expired, every grant
+// gets a fencing token greater than all previously issued ones. The lock is held once the lease
+// is applied with RestoreGrant
+func (m *Manager) Grant(name, owner string, ttl time.Duration) (Lease, error) {
+	if ttl <= 0 {
+		return Lease{}, ErrInvalidTTL
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, held := m.active(name); held {
+		return Lease{}, ErrLocked
+	}
+
+	m.lastToken++
+	return Lease{Owner: owner, Token: m.lastToken, ExpiresAt: now().Add(ttl)}, nil
+}
+
+// Extend returns the lease of the lock held by the owner extended by the TTL, the extension
+// is applied with RestoreRefresh
+func (m *Manager) Extend(name, owner string, ttl time.Duration) (Lease, error) {
+	if ttl <= 0 {
+		return Lease{}, ErrInvalidTTL
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	lease, held := m.active(name)
+	if !held || lease.Owner != owner {
+		return Lease{}, ErrNotOwner
+	}
+
+	lease.ExpiresAt = now().Add(ttl)
+	return lease, nil
+}
+
+// Held checks the lock is held by the owner
+func (m *Manager) Held(name, owner string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if lease, held := m.active(name); !held || lease.Owner != owner {
+		return ErrNotOwner
+	}
+
+	return nil
+}
+
+// Release frees the lock held by the owner
+func (m *Manager) Release(name, owner string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	lease, held := m.active(name)
+	if !held || lease.Owner != owner {
+		return ErrNotOwner
+	}
+
+	delete(m.leases, name)
+	return nil
+}
+
+// RestoreGrant applies a grant as is, both issued and previously persisted
+func (m *Manager) RestoreGrant(name string, lease Lease) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.leases[name] = lease
+	m.lastToken = max(m.lastToken, lease.Token)
+}
+
+// RestoreRefresh applies a lease extension as is, both issued and previously persisted
+func (m *Manager) RestoreRefresh(name, owner string, expiresAt time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if lease, ok := m.leases[name]; ok && lease.Owner == owner {
+		lease.ExpiresAt = expiresAt
+		m.leases[name] = lease
+	}
+}
+
+// Export returns leases by lock names and the last issued fencing token
+func (m *Manager) Export() (map[string]Lease, uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	leases := make(map[string]Lease, len(m.leases))
+	for name, lease := range m.leases {
+		leases[name] = lease
+	}
+
+	return leases, m.lastToken
+}
+
+// Import applies exported leases as is, tokens issued later are greater than the last token
+func (m *Manager) Import(leases map[string]Lease, lastToken uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for name, lease := range leases {
+		m.leases[name] = lease
+	}
+	m.lastToken = max(m.lastToken, lastToken)
+}
+
+func (m *Manager) active(name string) (Lease, bool) {
+	lease, ok := m.leases[name]
+	if !ok {
+		return Lease{}, false
+	}
+
+	if !now().Before(lease.ExpiresAt) {
+		delete(m.leases, name)
+		return Lease{}, false
+	}
+
+	return lease, true
+}
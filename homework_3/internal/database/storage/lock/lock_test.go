@@ -0,0 +1,152 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquire grants the lock and applies the grant
+func acquire(manager *Manager, name, owner string, ttl time.Duration) (Lease, error) {
+	lease, err := manager.Grant(name, owner, ttl)
+	if err == nil {
+		manager.RestoreGrant(name, lease)
+	}
+
+	return lease, err
+}
+
+func TestManager_Grant(t *testing.T) {
+	current := time.Unix(100, 0)
+	now = func() time.Time {
+		return current
+	}
+	defer func() { now = time.Now }()
+
+	manager := NewManager()
+
+	lease, err := acquire(manager, "resource", "owner_1", time.Second)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if lease.Token != 1 || lease.Owner != "owner_1" || !lease.ExpiresAt.Equal(current.Add(time.Second)) {
+		t.Errorf("wrong lease: got %+v", lease)
+	}
+
+	if _, err = acquire(manager, "resource", "owner_2", time.Second); !errors.Is(err, ErrLocked) {
+		t.Errorf("want %+v; got %+v", ErrLocked, err)
+	}
+
+	if _, err = acquire(manager, "resource", "owner_1", time.Second); !errors.Is(err, ErrLocked) {
+		t.Errorf("want %+v; got %+v", ErrLocked, err)
+	}
+
+	if _, err = acquire(manager, "other", "owner_1", 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("want %+v; got %+v", ErrInvalidTTL, err)
+	}
+
+	current = current.Add(time.Second)
+	lease, err = acquire(manager, "resource", "owner_2", time.Second)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if lease.Token != 2 || lease.Owner != "owner_2" {
+		t.Errorf("wrong lease after expiration: got %+v", lease)
+	}
+}
+
+func TestManager_ExtendAndRelease(t *testing.T) {
+	current := time.Unix(100, 0)
+	now = func() time.Time {
+		return current
+	}
+	defer func() { now = time.Now }()
+
+	manager := NewManager()
+	_, _ = acquire(manager, "resource", "owner_1", time.Second)
+
+	if _, err := manager.Extend("resource", "owner_2", time.Second); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("want %+v; got %+v", ErrNotOwner, err)
+	}
+
+	current = current.Add(500 * time.Millisecond)
+	lease, err := manager.Extend("resource", "owner_1", time.Second)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if lease.Token != 1 || !lease.ExpiresAt.Equal(current.Add(time.Second)) {
+		t.Errorf("wrong extended lease: got %+v", lease)
+	}
+	manager.RestoreRefresh("resource", "owner_1", lease.ExpiresAt)
+
+	current = current.Add(700 * time.Millisecond)
+	if err = manager.Held("resource", "owner_1"); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err = manager.Release("resource", "owner_2"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("want %+v; got %+v", ErrNotOwner, err)
+	}
+
+	if err = manager.Release("resource", "owner_1"); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err = manager.Release("resource", "owner_1"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("want %+v; got %+v", ErrNotOwner, err)
+	}
+
+	current = current.Add(time.Hour)
+	if _, err = manager.Extend("resource", "owner_1", time.Second); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("want %+v; got %+v", ErrNotOwner, err)
+	}
+}
+
+func TestManager_GrantNotApplied(t *testing.T) {
+	manager := NewManager()
+	lease, _ := manager.Grant("resource", "owner_1", time.Minute)
+
+	if err := manager.Held("resource", "owner_1"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("want %+v; got %+v", ErrNotOwner, err)
+	}
+
+	next, err := acquire(manager, "resource", "owner_2", time.Minute)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if next.Token <= lease.Token {
+		t.Errorf("want token greater than %d; got %d", lease.Token, next.Token)
+	}
+}
+
+func TestManager_Restore(t *testing.T) {
+	current := time.Unix(100, 0)
+	now = func() time.Time {
+		return current
+	}
+	defer func() { now = time.Now }()
+
+	manager := NewManager()
+	manager.RestoreGrant("expired", Lease{Owner: "owner_1", Token: 7, ExpiresAt: current.Add(-time.Second)})
+	manager.RestoreGrant("resource", Lease{Owner: "owner_1", Token: 5, ExpiresAt: current.Add(time.Second)})
+	manager.RestoreRefresh("resource", "owner_2", current.Add(time.Hour))
+	manager.RestoreRefresh("resource", "owner_1", current.Add(time.Minute))
+
+	current = current.Add(30 * time.Second)
+	if _, err := acquire(manager, "resource", "owner_2", time.Second); !errors.Is(err, ErrLocked) {
+		t.Errorf("want %+v; got %+v", ErrLocked, err)
+	}
+
+	lease, err := acquire(manager, "expired", "owner_2", time.Second)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if lease.Token != 8 {
+		t.Errorf("wrong fencing token after restore: want %d; got %d", 8, lease.Token)
+	}
+}
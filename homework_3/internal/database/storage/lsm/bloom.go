@@ -0,0 +1,74 @@
+package lsm
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+const (
+	bitsPerKey = 10
+	// bloomHashes - number of probes giving the lowest false positive rate for bitsPerKey, about 1%
+	bloomHashes = 7
+)
+
+var errCorruptedFilter = errors.New("lsm: corrupted bloom filter")
+
+// bloomFilter - tells that a key is definitely not in a table without reading its blocks
+type bloomFilter struct {
+	bits   []byte
+	hashes uint8
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	size := max(keys*bitsPerKey, 64)
+	return &bloomFilter{
+		bits:   make([]byte, (size+7)/8),
+		hashes: bloomHashes,
+	}
+}
+
+// hashKey returns a hash used for all probes of the key, it must be stable across restarts
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func (f *bloomFilter) add(hash uint64) {
+	f.probe(hash, func(bit uint64) bool {
+		f.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	return f.probe(hashKey(key), func(bit uint64) bool {
+		return f.bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// probe visits the bits of the hash using double hashing until visit returns false
+func (f *bloomFilter) probe(hash uint64, visit func(bit uint64) bool) bool {
+	bitsCount := uint64(len(f.bits) * 8)
+	h1, h2 := hash&0xffffffff, hash>>32|1
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		if !visit((h1 + i*h2) % bitsCount) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// encode appends the number of probes after the bits
+func (f *bloomFilter) encode() []byte {
+	return append(append([]byte(nil), f.bits...), f.hashes)
+}
+
+func decodeBloomFilter(data []byte) (*bloomFilter, error) {
+	if len(data) < 2 || data[len(data)-1] == 0 {
+		return nil, errCorruptedFilter
+	}
+
+	return &bloomFilter{bits: data[:len(data)-1], hashes: data[len(data)-1]}, nil
+}
@@ -0,0 +1,36 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const keys = 10000
+	filter := newBloomFilter(keys)
+	for i := 0; i < keys; i++ {
+		filter.add(hashKey(fmt.Sprintf("key_%d", i)))
+	}
+
+	decoded, err := decodeBloomFilter(filter.encode())
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	for i := 0; i < keys; i++ {
+		if !decoded.mayContain(fmt.Sprintf("key_%d", i)) {
+			t.Fatalf("want no false negatives for key_%d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := keys; i < 2*keys; i++ {
+		if decoded.mayContain(fmt.Sprintf("key_%d", i)) {
+			falsePositives++
+		}
+	}
+
+	if rate := float64(falsePositives) / keys; rate > 0.03 {
+		t.Errorf("want false positive rate about 1%%; got %.2f%%", rate*100)
+	}
+}
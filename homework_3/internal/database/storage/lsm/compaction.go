@@ -0,0 +1,80 @@
+package lsm
+
+// Size-tiered compaction: adjacent tables of a similar size are merged into one larger table,
+// so every key is stored in a logarithmic number of tables
+const (
+	// compactionThreshold - number of similar tables which are merged together
+	compactionThreshold = 4
+)
+
+// compactionRun returns bounds of the newest run of adjacent tables of a similar size
+// which is long enough to be merged, tables are ordered from the oldest to the newest
+func compactionRun(tables []*table) (int, int, bool) {
+	end := len(tables)
+	for end > 0 {
+		start := end - 1
+		total := tables[start].size
+		for start > 0 && similarSize(tables[start-1].size, total/int64(end-start)) {
+			start--
+			total += tables[start].size
+		}
+
+		if end-start >= compactionThreshold {
+			return start, end, true
+		}
+
+		end = start
+	}
+
+	return 0, 0, false
+}
+
+// similarSize reports whether the table size is within [average/2, average*3/2]
+func similarSize(size, average int64) bool {
+	return size*2 >= average && size*2 <= average*3
+}
+
+// merge writes records of the tables into the writer, for equal keys the record of the newest table wins,
+// tombstones can be dropped only when the oldest table is merged as there is nothing left for them to hide
+func merge(tables []*table, writer *tableWriter, dropTombstones bool) error {
+	iterators := make([]*tableIterator, len(tables))
+	valid := make([]bool, len(tables))
+	for i, t := range tables {
+		iterators[i] = t.iterator()
+		valid[i] = iterators[i].next()
+		if iterators[i].err != nil {
+			return iterators[i].err
+		}
+	}
+
+	for {
+		smallest := -1
+		for i := len(iterators) - 1; i >= 0; i-- {
+			if valid[i] && (smallest == -1 || iterators[i].key < iterators[smallest].key) {
+				smallest = i
+			}
+		}
+
+		if smallest == -1 {
+			return nil
+		}
+
+		key, e := iterators[smallest].key, iterators[smallest].entry
+		for i, iterator := range iterators {
+			if valid[i] && iterator.key == key {
+				valid[i] = iterator.next()
+				if iterator.err != nil {
+					return iterator.err
+				}
+			}
+		}
+
+		if e.deleted && dropTombstones {
+			continue
+		}
+
+		if err := writer.add(key, e); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+const LSMEngine = "lsm"
+
+const (
+	defaultDirectory    = "data/lsm"
+	defaultMemtableSize = 4 << 20
+)
+
+// Engine - log-structured merge tree: writes go to the memtable which is flushed to sorted tables on disk
+// when it is full, reads go from the newest data to the oldest
+type Engine struct {
+	logger       *common.Logger
+	directory    string
+	memtableSize int
+
+	m            sync.RWMutex
+	memtable     *skiplist
+	immutable    *skiplist
+	checkpointer storage.Checkpointer
+
+	tablesMutex sync.RWMutex
+	// tables - from the oldest to the newest, files of replaced tables are removed under the write lock
+	tables []*table
+
+	// flushMutex - serializes flushes and compactions which change the manifest
+	flushMutex sync.Mutex
+	manifest   manifest
+	// pending - checkpoint of the immutable memtable
+	pending int64
+	flushes chan struct{}
+
+	// stop - closed by Close, background flushes end and mark background done
+	stop       chan struct{}
+	background sync.WaitGroup
+}
+
+func NewEngine(directory string, memtableSize int, logger *common.Logger) (*Engine, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	if directory == "" {
+		directory = defaultDirectory
+	}
+
+	if memtableSize <= 0 {
+		memtableSize = defaultMemtableSize
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create engine directory: %w", err)
+	}
+
+	m, err := readManifest(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &Engine{
+		logger:       logger,
+		directory:    directory,
+		memtableSize: memtableSize,
+		memtable:     newSkiplist(),
+		checkpointer: storage.NoCheckpointer{},
+		manifest:     m,
+		flushes:      make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+
+	for _, id := range m.tables {
+		t, err := openTable(id, tablePath(directory, id))
+		if err != nil {
+			_ = engine.closeTables()
+			return nil, err
+		}
+
+		engine.tables = append(engine.tables, t)
+	}
+
+	engine.removeOrphans()
+	return engine, nil
+}
+
+// SetCheckpointer makes memtable flushes consistent with WAL segments
+func (e *Engine) SetCheckpointer(checkpointer storage.Checkpointer) {
+	e.m.Lock()
+	e.checkpointer = checkpointer
+	e.m.Unlock()
+}
+
+// LastCheckpoint returns the checkpoint of the last flushed memtable,
+// key changes logged to WAL segments before it must not be replayed
+func (e *Engine) LastCheckpoint() int64 {
+	e.flushMutex.Lock()
+	defer e.flushMutex.Unlock()
+
+	return e.manifest.checkpoint
+}
+
+// Start launches background flushes of full memtables followed by compactions
+func (e *Engine) Start() {
+	e.background.Add(1)
+	go func() {
+		defer e.background.Done()
+
+		for {
+			select {
+			case <-e.flushes:
+			case <-e.stop:
+				return
+			}
+
+			if err := e.Flush(); err != nil {
+				e.logger.Error("failed to flush memtable: %s", err)
+				continue
+			}
+
+			if err := e.Compact(); err != nil {
+				e.logger.Error("failed to compact tables: %s", err)
+			}
+		}
+	}()
+}
+
+// Close waits for the background flush in progress, flushes the memtable when there is no WAL to
+// replay it from and closes tables, it must be called once after the engine is no longer used
+func (e *Engine) Close() error {
+	close(e.stop)
+	e.background.Wait()
+
+	e.m.RLock()
+	_, noWAL := e.checkpointer.(storage.NoCheckpointer)
+	unflushed := e.memtable.length != 0 || e.immutable != nil
+	e.m.RUnlock()
+
+	var err error
+	if noWAL && unflushed {
+		err = e.Flush()
+	}
+
+	e.flushMutex.Lock()
+	defer e.flushMutex.Unlock()
+
+	e.tablesMutex.Lock()
+	defer e.tablesMutex.Unlock()
+
+	return errors.Join(err, e.closeTables())
+}
+
+func (e *Engine) Set(key, value string) error {
+	e.put(key, entry{value: value})
+	e.logger.Debug("successful SET query [key %s, value %s]", key, value)
+	return nil
+}
+
+func (e *Engine) Get(key string) (string, error) {
+	found, ok, err := e.lookup(key)
+	if err != nil {
+		e.logger.Error("GET query [key %s]: %s", key, err)
+		return "", err
+	}
+
+	if !ok || found.deleted {
+		e.logger.Debug("GET query [key %s]: key not found", key)
+		return "", storage.ErrNotFound
+	}
+
+	e.logger.Info("successful GET query [key %s, value %s]", key, found.value)
+	return found.value, nil
+}
+
+// Del writes a tombstone hiding values of the key in older tables
+func (e *Engine) Del(key string) error {
+	e.put(key, entry{deleted: true})
+	e.logger.Debug("successful DEL query [key %s]", key)
+	return nil
+}
+
+func (e *Engine) put(key string, value entry) {
+	e.m.Lock()
+	e.memtable.put(key, value)
+	full := e.memtable.size >= e.memtableSize
+	e.m.Unlock()
+
+	if full {
+		select {
+		case e.flushes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Engine) lookup(key string) (entry, bool, error) {
+	e.m.RLock()
+	found, ok := e.memtable.get(key)
+	if !ok && e.immutable != nil {
+		found, ok = e.immutable.get(key)
+	}
+	e.m.RUnlock()
+
+	if ok {
+		return found, true, nil
+	}
+
+	e.tablesMutex.RLock()
+	defer e.tablesMutex.RUnlock()
+
+	for i := len(e.tables) - 1; i >= 0; i-- {
+		found, ok, err := e.tables[i].get(key)
+		if err != nil || ok {
+			return found, ok, err
+		}
+	}
+
+	return entry{}, false, nil
+}
+
+// Flush writes the memtable to a new table and lets WAL drop the segments covered by it
+func (e *Engine) Flush() error {
+	e.flushMutex.Lock()
+	defer e.flushMutex.Unlock()
+
+	e.m.RLock()
+	immutable, checkpointer := e.immutable, e.checkpointer
+	e.m.RUnlock()
+
+	// a memtable left by a failed flush is written before the next switch
+	if immutable == nil {
+		checkpoint, err := checkpointer.Checkpoint(e.switchMemtable)
+		if err != nil {
+			return err
+		}
+
+		e.pending = checkpoint
+		e.m.RLock()
+		immutable = e.immutable
+		e.m.RUnlock()
+	}
+
+	m := e.manifest
+	m.checkpoint = max(m.checkpoint, e.pending)
+
+	var flushed *table
+	if immutable.length != 0 {
+		var err error
+		flushed, err = e.writeTable(m.nextID, func(writer *tableWriter) error {
+			for n := immutable.first(); n != nil; n = n.next[0] {
+				if err := writer.add(n.key, n.entry); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		m.nextID++
+		m.tables = append(slices.Clone(m.tables), flushed.id)
+	}
+
+	if err := e.commit(m); err != nil {
+		e.dropTable(flushed)
+		return err
+	}
+
+	e.tablesMutex.Lock()
+	if flushed != nil {
+		e.tables = append(e.tables, flushed)
+	}
+	e.tablesMutex.Unlock()
+
+	e.m.Lock()
+	e.immutable = nil
+	e.m.Unlock()
+
+	if err := checkpointer.Release(m.checkpoint); err != nil {
+		e.logger.Error("failed to release WAL checkpoint %d: %s", m.checkpoint, err)
+	}
+
+	e.logger.Debug("memtable has been flushed [%d keys, checkpoint %d]", immutable.length, m.checkpoint)
+	return nil
+}
+
+func (e *Engine) switchMemtable() {
+	e.m.Lock()
+	e.immutable = e.memtable
+	e.memtable = newSkiplist()
+	e.m.Unlock()
+}
+
+// Compact merges runs of similar tables until there is nothing to merge
+func (e *Engine) Compact() error {
+	e.flushMutex.Lock()
+	defer e.flushMutex.Unlock()
+
+	for {
+		e.tablesMutex.RLock()
+		tables := slices.Clone(e.tables)
+		e.tablesMutex.RUnlock()
+
+		start, end, ok := compactionRun(tables)
+		if !ok {
+			return nil
+		}
+
+		if err := e.compactRun(tables, start, end); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Engine) compactRun(tables []*table, start, end int) error {
+	m := e.manifest
+	run := tables[start:end]
+	merged, err := e.writeTable(m.nextID, func(writer *tableWriter) error {
+		return merge(run, writer, start == 0)
+	})
+	if err != nil {
+		return err
+	}
+	m.nextID++
+
+	compacted := slices.Clone(tables[:start])
+	if merged != nil {
+		compacted = append(compacted, merged)
+	}
+	compacted = append(compacted, tables[end:]...)
+
+	m.tables = make([]uint64, 0, len(compacted))
+	for _, t := range compacted {
+		m.tables = append(m.tables, t.id)
+	}
+
+	if err = e.commit(m); err != nil {
+		e.dropTable(merged)
+		return err
+	}
+
+	e.tablesMutex.Lock()
+	e.tables = compacted
+	for _, t := range run {
+		e.dropTable(t)
+	}
+	e.tablesMutex.Unlock()
+
+	e.logger.Debug("tables have been compacted [%d tables merged]", len(run))
+	return nil
+}
+
+// writeTable creates the table with records added by fill, it returns nil if no records were added
+func (e *Engine) writeTable(id uint64, fill func(*tableWriter) error) (*table, error) {
+	path := tablePath(e.directory, id)
+	writer, err := createTable(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fill(writer); err != nil {
+		writer.abort()
+		return nil, err
+	}
+
+	if writer.empty() {
+		writer.abort()
+		return nil, nil
+	}
+
+	if err = writer.finish(); err != nil {
+		writer.abort()
+		return nil, err
+	}
+
+	return openTable(id, path)
+}
+
+func (e *Engine) commit(m manifest) error {
+	if err := writeManifest(e.directory, m); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	e.manifest = m
+	return nil
+}
+
+func (e *Engine) dropTable(t *table) {
+	if t == nil {
+		return
+	}
+
+	_ = t.close()
+	if err := os.Remove(tablePath(e.directory, t.id)); err != nil {
+		e.logger.Error("failed to remove table %d: %s", t.id, err)
+	}
+}
+
+func (e *Engine) closeTables() error {
+	var err error
+	for _, t := range e.tables {
+		err = errors.Join(err, t.close())
+	}
+
+	return err
+}
+
+// removeOrphans removes tables left by flushes and compactions interrupted before the manifest was written
+func (e *Engine) removeOrphans() {
+	files, err := os.ReadDir(e.directory)
+	if err != nil {
+		e.logger.Error("failed to read engine directory: %s", err)
+		return
+	}
+
+	live := make(map[uint64]struct{}, len(e.manifest.tables))
+	for _, id := range e.manifest.tables {
+		live[id] = struct{}{}
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, tableSuffix), 10, 64)
+		if file.IsDir() || !strings.HasSuffix(name, tableSuffix) || err != nil {
+			continue
+		}
+
+		if _, ok := live[id]; !ok {
+			_ = os.Remove(filepath.Join(e.directory, name))
+			e.logger.Debug("orphan table %s has been removed", name)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+type testCheckpointer struct {
+	next     int64
+	released []int64
+}
+
+func (c *testCheckpointer) Checkpoint(switchMemtable func()) (int64, error) {
+	c.next++
+	switchMemtable()
+	return c.next, nil
+}
+
+func (c *testCheckpointer) Release(checkpoint int64) error {
+	c.released = append(c.released, checkpoint)
+	return nil
+}
+
+func TestNewEngine(t *testing.T) {
+	if _, err := NewEngine(t.TempDir(), 0, nil); err == nil {
+		t.Errorf("want error without logger")
+	}
+
+	logger, _ := common.NewLogger("", "")
+	directory := t.TempDir()
+	orphan := tablePath(directory, 7)
+	if err := os.WriteFile(orphan, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := NewEngine(directory, 0, logger)
+	if err != nil || engine.memtableSize != defaultMemtableSize {
+		t.Fatalf("want engine with default memtable size; got %+v", err)
+	}
+
+	if _, err = os.Stat(orphan); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want orphan table removed; got %+v", err)
+	}
+}
+
+func TestEngine_SetGetDel(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	directory := t.TempDir()
+	engine, err := NewEngine(directory, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkpointer := &testCheckpointer{}
+	engine.SetCheckpointer(checkpointer)
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+	if err = engine.Flush(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	engine.Del("key_1")
+	engine.Set("key_2", "new_value_2")
+
+	if _, err = engine.Get("key_1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want tombstone to hide flushed value; got %+v", err)
+	}
+
+	if value, err := engine.Get("key_2"); err != nil || value != "new_value_2" {
+		t.Errorf("want memtable value; got %s, %+v", value, err)
+	}
+
+	if err = engine.Flush(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if len(checkpointer.released) != 2 || checkpointer.released[1] != 2 {
+		t.Errorf("want both checkpoints released; got %+v", checkpointer.released)
+	}
+
+	engine.Set("key_3", "value_3")
+	engine.Close()
+
+	reopened, err := NewEngine(directory, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if reopened.LastCheckpoint() != 2 {
+		t.Errorf("want checkpoint %d; got %d", 2, reopened.LastCheckpoint())
+	}
+
+	if _, err = reopened.Get("key_1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want deleted key not found; got %+v", err)
+	}
+
+	if value, err := reopened.Get("key_2"); err != nil || value != "new_value_2" {
+		t.Errorf("want flushed value; got %s, %+v", value, err)
+	}
+
+	// not flushed changes are recovered from WAL
+	if _, err = reopened.Get("key_3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want memtable not persisted; got %+v", err)
+	}
+}
+
+func TestEngine_CloseWithoutWAL(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	directory := t.TempDir()
+	engine, err := NewEngine(directory, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Set("key_1", "value_1")
+	engine.Set("key_2", "value_2")
+	engine.Del("key_2")
+	if err = engine.Close(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	reopened, err := NewEngine(directory, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if value, err := reopened.Get("key_1"); err != nil || value != "value_1" {
+		t.Errorf("want memtable flushed on close; got %s, %+v", value, err)
+	}
+
+	if _, err = reopened.Get("key_2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want %+v; got %+v", storage.ErrNotFound, err)
+	}
+}
+
+func TestEngine_Compact(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	directory := t.TempDir()
+	engine, err := NewEngine(directory, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+
+	for flush := 0; flush < compactionThreshold; flush++ {
+		for i := 0; i < 100; i++ {
+			engine.Set(fmt.Sprintf("key_%03d", i), fmt.Sprintf("value_%d_%d", flush, i))
+		}
+		engine.Del(fmt.Sprintf("key_%03d", flush))
+
+		if err = engine.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = engine.Compact(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if len(engine.tables) != 1 {
+		t.Fatalf("want tables merged into one; got %d", len(engine.tables))
+	}
+
+	last := compactionThreshold - 1
+	if value, err := engine.Get("key_050"); err != nil || value != fmt.Sprintf("value_%d_50", last) {
+		t.Errorf("want the newest value; got %s, %+v", value, err)
+	}
+
+	if _, err = engine.Get(fmt.Sprintf("key_%03d", last)); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want deleted key not found; got %+v", err)
+	}
+
+	if value, err := engine.Get("key_000"); err != nil || value != fmt.Sprintf("value_%d_0", last) {
+		t.Errorf("want key deleted in an older table restored by a newer one; got %s, %+v", value, err)
+	}
+
+	iterator := engine.tables[0].iterator()
+	for iterator.next() {
+		if iterator.entry.deleted {
+			t.Errorf("want tombstones dropped by full compaction; got %s", iterator.key)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(directory, "*"+tableSuffix))
+	if len(files) != 1 {
+		t.Errorf("want merged tables removed; got %+v", files)
+	}
+}
+
+func TestCompactionRun(t *testing.T) {
+	sized := func(sizes ...int64) []*table {
+		tables := make([]*table, 0, len(sizes))
+		for _, size := range sizes {
+			tables = append(tables, &table{size: size})
+		}
+		return tables
+	}
+
+	tests := []struct {
+		name          string
+		tables        []*table
+		expectedStart int
+		expectedEnd   int
+		expectedOk    bool
+	}{
+		{
+			name:   "Not enough tables",
+			tables: sized(100, 100, 100),
+		},
+		{
+			name:          "Newest similar tables",
+			tables:        sized(1000, 100, 110, 90, 100),
+			expectedStart: 1,
+			expectedEnd:   5,
+			expectedOk:    true,
+		},
+		{
+			name:          "Older run left by a failed compaction",
+			tables:        sized(100, 100, 100, 100, 1000, 10),
+			expectedStart: 0,
+			expectedEnd:   4,
+			expectedOk:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := compactionRun(tt.tables)
+			if start != tt.expectedStart || end != tt.expectedEnd || ok != tt.expectedOk {
+				t.Errorf("want [%d, %d) %t; got [%d, %d) %t", tt.expectedStart, tt.expectedEnd, tt.expectedOk, start, end, ok)
+			}
+		})
+	}
+}
+
+func TestEngine_Start(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(t.TempDir(), 1024, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Start()
+	for i := 0; i < 100; i++ {
+		engine.Set(fmt.Sprintf("key_%d", i), "value")
+	}
+
+	flushed := func() bool {
+		engine.tablesMutex.RLock()
+		defer engine.tablesMutex.RUnlock()
+		return len(engine.tables) != 0
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !flushed() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !flushed() {
+		t.Errorf("want full memtable flushed in background")
+	}
+
+	if value, err := engine.Get("key_0"); err != nil || value != "value" {
+		t.Errorf("want value; got %s, %+v", value, err)
+	}
+}
@@ -0,0 +1,80 @@
+package lsm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+)
+
+const (
+	manifestName = "MANIFEST"
+	tableSuffix  = ".sst"
+)
+
+// manifest - the set of live tables, it is replaced atomically after every flush and compaction:
+//
+//	checkpoint <WAL segment id>
+//	next <next table id>
+//	table <id>
+//	...
+type manifest struct {
+	// checkpoint - requests logged to WAL segments before this one are persisted in the tables
+	checkpoint int64
+	nextID     uint64
+	// tables - ids of the tables from the oldest to the newest
+	tables []uint64
+}
+
+func readManifest(directory string) (manifest, error) {
+	file, err := os.Open(filepath.Join(directory, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest{nextID: 1}, nil
+	} else if err != nil {
+		return manifest{}, err
+	}
+	defer file.Close()
+
+	var m manifest
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var id uint64
+		switch {
+		case strings.HasPrefix(line, "checkpoint "):
+			_, err = fmt.Sscanf(line, "checkpoint %d", &m.checkpoint)
+		case strings.HasPrefix(line, "next "):
+			_, err = fmt.Sscanf(line, "next %d", &m.nextID)
+		case strings.HasPrefix(line, "table "):
+			_, err = fmt.Sscanf(line, "table %d", &id)
+			m.tables = append(m.tables, id)
+		default:
+			err = fmt.Errorf("unexpected line %q", line)
+		}
+
+		if err != nil {
+			return manifest{}, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+	}
+
+	return m, scanner.Err()
+}
+
+// writeManifest replaces the manifest atomically
+func writeManifest(directory string, m manifest) error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "checkpoint %d\nnext %d\n", m.checkpoint, m.nextID)
+	for _, id := range m.tables {
+		fmt.Fprintf(&builder, "table %d\n", id)
+	}
+
+	return filesystem.WriteFileAtomic(filepath.Join(directory, manifestName), []byte(builder.String()))
+}
+
+func tablePath(directory string, id uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("%06d%s", id, tableSuffix))
+}
@@ -0,0 +1,102 @@
+package lsm
+
+import "math/rand/v2"
+
+const (
+	maxHeight = 12
+	// branching - each level of the skiplist holds about 1/branching nodes of the level below
+	branching = 4
+	// nodeOverhead - approximate memory taken by a node besides the key and the value
+	nodeOverhead = 48
+)
+
+// entry - a value or a tombstone of a deleted key
+type entry struct {
+	value   string
+	deleted bool
+}
+
+type node struct {
+	key   string
+	entry entry
+	next  []*node
+}
+
+// skiplist - sorted memtable, it is not safe for concurrent use
+type skiplist struct {
+	head   *node
+	height int
+	length int
+	// size - approximate memory taken by the entries
+	size int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:   &node{next: make([]*node, maxHeight)},
+		height: 1,
+	}
+}
+
+func (s *skiplist) put(key string, e entry) {
+	var update [maxHeight]*node
+	current := s.head
+	for level := s.height - 1; level >= 0; level-- {
+		for current.next[level] != nil && current.next[level].key < key {
+			current = current.next[level]
+		}
+		update[level] = current
+	}
+
+	if found := current.next[0]; found != nil && found.key == key {
+		s.size += len(e.value) - len(found.entry.value)
+		found.entry = e
+		return
+	}
+
+	height := randomHeight()
+	if height > s.height {
+		for level := s.height; level < height; level++ {
+			update[level] = s.head
+		}
+		s.height = height
+	}
+
+	created := &node{key: key, entry: e, next: make([]*node, height)}
+	for level := 0; level < height; level++ {
+		created.next[level] = update[level].next[level]
+		update[level].next[level] = created
+	}
+
+	s.length++
+	s.size += len(key) + len(e.value) + nodeOverhead
+}
+
+func (s *skiplist) get(key string) (entry, bool) {
+	current := s.head
+	for level := s.height - 1; level >= 0; level-- {
+		for current.next[level] != nil && current.next[level].key < key {
+			current = current.next[level]
+		}
+	}
+
+	if found := current.next[0]; found != nil && found.key == key {
+		return found.entry, true
+	}
+
+	return entry{}, false
+}
+
+// first returns the node with the smallest key, nodes are linked in the key order by next[0]
+func (s *skiplist) first() *node {
+	return s.head.next[0]
+}
+
+func randomHeight() int {
+	height := 1
+	for height < maxHeight && rand.IntN(branching) == 0 {
+		height++
+	}
+
+	return height
+}
@@ -0,0 +1,49 @@
+package lsm
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+func TestSkiplist(t *testing.T) {
+	list := newSkiplist()
+
+	var keys []string
+	for _, i := range rand.Perm(1000) {
+		key := fmt.Sprintf("key_%04d", i)
+		keys = append(keys, key)
+		list.put(key, entry{value: "old"})
+	}
+
+	for _, key := range keys {
+		list.put(key, entry{value: key})
+	}
+	list.put("key_0000", entry{deleted: true})
+
+	if list.length != len(keys) {
+		t.Errorf("want %d; got %d", len(keys), list.length)
+	}
+
+	if found, ok := list.get("key_0500"); !ok || found.value != "key_0500" {
+		t.Errorf("want overwritten value; got %+v, %t", found, ok)
+	}
+
+	if found, ok := list.get("key_0000"); !ok || !found.deleted {
+		t.Errorf("want tombstone; got %+v, %t", found, ok)
+	}
+
+	if _, ok := list.get("key_1000"); ok {
+		t.Errorf("want missing key not found")
+	}
+
+	sort.Strings(keys)
+	i := 0
+	for n := list.first(); n != nil; n = n.next[0] {
+		if n.key != keys[i] {
+			t.Fatalf("want %s; got %s", keys[i], n.key)
+		}
+		i++
+	}
+}
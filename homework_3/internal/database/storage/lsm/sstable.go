@@ -0,0 +1,392 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// SSTable layout:
+//
+//	data blocks | index block | bloom filter block | footer
+//
+// every block is followed by its CRC32, a data block holds records sorted by key:
+//
+//	flags (1 byte) | key length (uvarint) | key | value length (uvarint) | value
+//
+// the index block holds a handle for every data block:
+//
+//	last key length (uvarint) | last key | offset (uvarint) | size (uvarint)
+//
+// the footer holds offsets and sizes of the index and the filter blocks and the magic number
+const (
+	blockSize  = 4096
+	footerSize = 5 * 8
+	checksum   = 4
+	tableMagic = 0x4c534d5353544231 // "LSMSSTB1"
+
+	tombstoneFlag = 1
+)
+
+var errCorruptedTable = errors.New("lsm: corrupted table")
+
+type blockHandle struct {
+	lastKey string
+	offset  uint64
+	size    uint64
+}
+
+// tableWriter - writes sorted records into a new table file
+type tableWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	offset uint64
+
+	block   bytes.Buffer
+	lastKey string
+	index   []blockHandle
+	hashes  []uint64
+}
+
+func createTable(path string) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tableWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// add appends the record, keys must be added in the ascending order
+func (w *tableWriter) add(key string, e entry) error {
+	var flags byte
+	if e.deleted {
+		flags = tombstoneFlag
+	}
+
+	w.block.WriteByte(flags)
+	w.block.Write(binary.AppendUvarint(nil, uint64(len(key))))
+	w.block.WriteString(key)
+	w.block.Write(binary.AppendUvarint(nil, uint64(len(e.value))))
+	w.block.WriteString(e.value)
+
+	w.lastKey = key
+	w.hashes = append(w.hashes, hashKey(key))
+
+	if w.block.Len() >= blockSize {
+		return w.flushBlock()
+	}
+
+	return nil
+}
+
+func (w *tableWriter) empty() bool {
+	return len(w.hashes) == 0
+}
+
+// finish writes the index, the filter and the footer and syncs the file
+func (w *tableWriter) finish() error {
+	if w.block.Len() != 0 {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+
+	var index []byte
+	for _, handle := range w.index {
+		index = binary.AppendUvarint(index, uint64(len(handle.lastKey)))
+		index = append(index, handle.lastKey...)
+		index = binary.AppendUvarint(index, handle.offset)
+		index = binary.AppendUvarint(index, handle.size)
+	}
+
+	indexHandle, err := w.writeBlock(index)
+	if err != nil {
+		return err
+	}
+
+	filter := newBloomFilter(len(w.hashes))
+	for _, hash := range w.hashes {
+		filter.add(hash)
+	}
+
+	filterHandle, err := w.writeBlock(filter.encode())
+	if err != nil {
+		return err
+	}
+
+	footer := make([]byte, 0, footerSize)
+	footer = binary.LittleEndian.AppendUint64(footer, indexHandle.offset)
+	footer = binary.LittleEndian.AppendUint64(footer, indexHandle.size)
+	footer = binary.LittleEndian.AppendUint64(footer, filterHandle.offset)
+	footer = binary.LittleEndian.AppendUint64(footer, filterHandle.size)
+	footer = binary.LittleEndian.AppendUint64(footer, tableMagic)
+	if _, err = w.writer.Write(footer); err != nil {
+		return err
+	}
+
+	if err = w.writer.Flush(); err != nil {
+		return err
+	}
+
+	if err = w.file.Sync(); err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// abort closes and removes the unfinished table
+func (w *tableWriter) abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+func (w *tableWriter) flushBlock() error {
+	handle, err := w.writeBlock(w.block.Bytes())
+	if err != nil {
+		return err
+	}
+
+	handle.lastKey = w.lastKey
+	w.index = append(w.index, handle)
+	w.block.Reset()
+	return nil
+}
+
+func (w *tableWriter) writeBlock(data []byte) (blockHandle, error) {
+	handle := blockHandle{offset: w.offset, size: uint64(len(data))}
+	if _, err := w.writer.Write(data); err != nil {
+		return blockHandle{}, err
+	}
+
+	if _, err := w.writer.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(data))); err != nil {
+		return blockHandle{}, err
+	}
+
+	w.offset += uint64(len(data)) + checksum
+	return handle, nil
+}
+
+// table - an immutable sorted table file, its index and filter are kept in memory
+type table struct {
+	id     uint64
+	file   *os.File
+	size   int64
+	index  []blockHandle
+	filter *bloomFilter
+}
+
+func openTable(id uint64, path string) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := loadTable(id, file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to open table %s: %w", path, err)
+	}
+
+	return t, nil
+}
+
+func loadTable(id uint64, file *os.File) (*table, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.Size() < footerSize {
+		return nil, errCorruptedTable
+	}
+
+	footer := make([]byte, footerSize)
+	if _, err = file.ReadAt(footer, stat.Size()-footerSize); err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint64(footer[32:]) != tableMagic {
+		return nil, errCorruptedTable
+	}
+
+	t := &table{id: id, file: file, size: stat.Size()}
+	indexData, err := t.readBlock(blockHandle{
+		offset: binary.LittleEndian.Uint64(footer[0:]),
+		size:   binary.LittleEndian.Uint64(footer[8:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if t.index, err = decodeIndex(indexData); err != nil {
+		return nil, err
+	}
+
+	filterData, err := t.readBlock(blockHandle{
+		offset: binary.LittleEndian.Uint64(footer[16:]),
+		size:   binary.LittleEndian.Uint64(footer[24:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if t.filter, err = decodeBloomFilter(filterData); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func decodeIndex(data []byte) ([]blockHandle, error) {
+	var index []blockHandle
+	for len(data) > 0 {
+		lastKey, rest, err := readBytes(data)
+		if err != nil {
+			return nil, err
+		}
+
+		offset, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return nil, errCorruptedTable
+		}
+		rest = rest[n:]
+
+		size, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return nil, errCorruptedTable
+		}
+
+		index = append(index, blockHandle{lastKey: string(lastKey), offset: offset, size: size})
+		data = rest[n:]
+	}
+
+	return index, nil
+}
+
+// get looks the key up, it reports whether the table has a record for the key
+func (t *table) get(key string) (entry, bool, error) {
+	if !t.filter.mayContain(key) {
+		return entry{}, false, nil
+	}
+
+	position := sort.Search(len(t.index), func(i int) bool {
+		return t.index[i].lastKey >= key
+	})
+	if position == len(t.index) {
+		return entry{}, false, nil
+	}
+
+	data, err := t.readBlock(t.index[position])
+	if err != nil {
+		return entry{}, false, err
+	}
+
+	for len(data) > 0 {
+		var recordKey string
+		var e entry
+		recordKey, e, data, err = decodeRecord(data)
+		if err != nil {
+			return entry{}, false, err
+		}
+
+		if recordKey == key {
+			return e, true, nil
+		}
+
+		if recordKey > key {
+			break
+		}
+	}
+
+	return entry{}, false, nil
+}
+
+func (t *table) readBlock(handle blockHandle) ([]byte, error) {
+	if handle.offset+handle.size+checksum > uint64(t.size) {
+		return nil, errCorruptedTable
+	}
+
+	data := make([]byte, handle.size+checksum)
+	if _, err := t.file.ReadAt(data, int64(handle.offset)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	block, sum := data[:handle.size], data[handle.size:]
+	if crc32.ChecksumIEEE(block) != binary.LittleEndian.Uint32(sum) {
+		return nil, errCorruptedTable
+	}
+
+	return block, nil
+}
+
+func (t *table) iterator() *tableIterator {
+	return &tableIterator{table: t}
+}
+
+func (t *table) close() error {
+	return t.file.Close()
+}
+
+func decodeRecord(data []byte) (string, entry, []byte, error) {
+	if len(data) == 0 {
+		return "", entry{}, nil, errCorruptedTable
+	}
+
+	flags := data[0]
+	key, rest, err := readBytes(data[1:])
+	if err != nil {
+		return "", entry{}, nil, err
+	}
+
+	value, rest, err := readBytes(rest)
+	if err != nil {
+		return "", entry{}, nil, err
+	}
+
+	return string(key), entry{value: string(value), deleted: flags&tombstoneFlag != 0}, rest, nil
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return nil, nil, errCorruptedTable
+	}
+
+	return data[n : n+int(length)], data[n+int(length):], nil
+}
+
+// tableIterator - walks the table records in the key order
+type tableIterator struct {
+	table *table
+	block int
+	data  []byte
+
+	key   string
+	entry entry
+	err   error
+}
+
+func (i *tableIterator) next() bool {
+	for len(i.data) == 0 {
+		if i.err != nil || i.block == len(i.table.index) {
+			return false
+		}
+
+		i.data, i.err = i.table.readBlock(i.table.index[i.block])
+		if i.err != nil {
+			return false
+		}
+		i.block++
+	}
+
+	i.key, i.entry, i.data, i.err = decodeRecord(i.data)
+	return i.err == nil
+}
@@ -0,0 +1,112 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestTable(t *testing.T, path string, records map[string]entry, keys []string) *table {
+	t.Helper()
+
+	writer, err := createTable(path)
+	if err != nil {
+		t.Fatalf("cannot create table: %s", err)
+	}
+
+	for _, key := range keys {
+		if err = writer.add(key, records[key]); err != nil {
+			t.Fatalf("cannot add record: %s", err)
+		}
+	}
+
+	if err = writer.finish(); err != nil {
+		t.Fatalf("cannot finish table: %s", err)
+	}
+
+	opened, err := openTable(1, path)
+	if err != nil {
+		t.Fatalf("cannot open table: %s", err)
+	}
+
+	t.Cleanup(func() {
+		_ = opened.close()
+	})
+
+	return opened
+}
+
+func TestTable(t *testing.T) {
+	records := make(map[string]entry)
+	var keys []string
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key_%05d", i*2)
+		keys = append(keys, key)
+		records[key] = entry{value: strings.Repeat("v", i%50)}
+	}
+	records["key_00010"] = entry{deleted: true}
+
+	opened := writeTestTable(t, filepath.Join(t.TempDir(), "000001.sst"), records, keys)
+	if len(opened.index) < 2 {
+		t.Fatalf("want several blocks; got %d", len(opened.index))
+	}
+
+	for _, key := range []string{"key_00000", "key_01998", "key_03998"} {
+		found, ok, err := opened.get(key)
+		if err != nil || !ok || found != records[key] {
+			t.Errorf("want %+v for %s; got %+v, %t, %+v", records[key], key, found, ok, err)
+		}
+	}
+
+	if found, ok, _ := opened.get("key_00010"); !ok || !found.deleted {
+		t.Errorf("want tombstone; got %+v, %t", found, ok)
+	}
+
+	for _, key := range []string{"key_00001", "key_99999", "a"} {
+		if _, ok, err := opened.get(key); ok || err != nil {
+			t.Errorf("want %s not found; got %t, %+v", key, ok, err)
+		}
+	}
+
+	iterator := opened.iterator()
+	count := 0
+	for iterator.next() {
+		if iterator.key != keys[count] {
+			t.Fatalf("want %s; got %s", keys[count], iterator.key)
+		}
+		count++
+	}
+
+	if iterator.err != nil || count != len(keys) {
+		t.Errorf("want %d records; got %d, %+v", len(keys), count, iterator.err)
+	}
+}
+
+func TestTable_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.sst")
+	records := map[string]entry{"key": {value: "value"}}
+	writeTestTable(t, path, records, []string{"key"})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[1] ^= 0xff
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := openTable(1, path)
+	if err != nil {
+		t.Fatalf("want index and filter intact; got %+v", err)
+	}
+	defer opened.close()
+
+	if _, _, err = opened.get("key"); !errors.Is(err, errCorruptedTable) {
+		t.Errorf("want %+v; got %+v", errCorruptedTable, err)
+	}
+}
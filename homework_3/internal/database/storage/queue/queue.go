@@ -0,0 +1,420 @@
+package queue
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	DefaultVisibility  = 30 * time.Second
+
+	// DeadLetterSuffix - jobs which ran out of attempts are moved to the queue named with the suffix
+	DeadLetterSuffix = ":dlq"
+)
+
+var now = time.Now
+
+var (
+	ErrEmpty          = errors.New("queue: no jobs available")
+	ErrInvalidReceipt = errors.New("queue: invalid or expired receipt")
+)
+
+type state int
+
+const (
+	delayed state = iota
+	ready
+	leased
+)
+
+// Job - a queued message
+type Job struct {
+	ID          uint64
+	Queue       string
+	Payload     string
+	MaxAttempts int
+	Attempts    int
+	VisibleAt   time.Time
+	LeaseUntil  time.Time
+
+	state state
+	// generation is bumped on every state change to invalidate stale heap entries
+	generation uint64
+}
+
+// Lease - a job handed to a consumer
+type Lease struct {
+	Job     Job
+	Receipt string
+}
+
+// Manager - durable delayed job queues with visibility timeouts
+type Manager struct {
+	wake       chan struct{}
+	stop       chan struct{}
+	background sync.WaitGroup
+
+	mutex     sync.Mutex
+	lastID    uint64
+	jobs      map[uint64]*Job
+	ready     map[string]*jobsHeap
+	deadlines deadlinesHeap
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		jobs:  make(map[uint64]*Job),
+		ready: make(map[string]*jobsHeap),
+	}
+}
+
+// Start launches redelivery of jobs whose delay or lease expired
+func (m *Manager) Start() {
+	m.background.Add(1)
+	go func() {
+		defer m.background.Done()
+
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+
+		for {
+			m.mutex.Lock()
+			m.promote(now())
+			next, ok := m.deadlines.next()
+			m.mutex.Unlock()
+
+			wait := time.Hour
+			if ok {
+				wait = max(next.Sub(now()), 0)
+			}
+
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-m.wake:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the redelivery, it must be called once
+func (m *Manager) Close() {
+	close(m.stop)
+	m.background.Wait()
+}
+
+// NewJob prepares a job with a unique ID, it becomes visible to consumers only after Add
+func (m *Manager) NewJob(queue, payload string, delay time.Duration, maxAttempts int) Job {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	m.mutex.Lock()
+	m.lastID++
+	id := m.lastID
+	m.mutex.Unlock()
+
+	return Job{
+		ID:          id,
+		Queue:       queue,
+		Payload:     payload,
+		MaxAttempts: maxAttempts,
+		VisibleAt:   now().Add(delay),
+	}
+}
+
+// Add puts the job to its queue
+func (m *Manager) Add(job Job) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastID = max(m.lastID, job.ID)
+	stored := job
+	m.jobs[job.ID] = &stored
+	m.schedule(&stored, now())
+}
+
+// Next returns the lease of the oldest visible job of the queue for the visibility timeout,
+// the job is leased once the lease is applied with RestoreLease
+func (m *Manager) Next(queue string, visibility time.Duration) (Lease, error) {
+	if visibility <= 0 {
+		visibility = DefaultVisibility
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current := now()
+	m.promote(current)
+
+	jobs, ok := m.ready[queue]
+	if !ok {
+		return Lease{}, ErrEmpty
+	}
+
+	for jobs.Len() > 0 {
+		entry := (*jobs)[0]
+		job, ok := m.jobs[entry.id]
+		if !ok || job.generation != entry.generation || job.state != ready {
+			heap.Pop(jobs)
+			continue
+		}
+
+		leased := *job
+		leased.Attempts++
+		leased.LeaseUntil = current.Add(visibility)
+		return Lease{Job: leased, Receipt: FormatReceipt(leased.ID, leased.Attempts)}, nil
+	}
+
+	return Lease{}, ErrEmpty
+}
+
+// Leased reports whether the job of the queue is leased with the attempt
+func (m *Manager) Leased(queue string, id uint64, attempt int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	return ok && job.Queue == queue && job.state == leased && job.Attempts == attempt && now().Before(job.LeaseUntil)
+}
+
+// Ack deletes the job leased with the attempt, false means the job was released or leased again
+func (m *Manager) Ack(id uint64, attempt int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok || job.state != leased || job.Attempts != attempt {
+		return false
+	}
+
+	delete(m.jobs, id)
+	return true
+}
+
+// Nack returns the job leased with the attempt to its queue or moves it to the dead-letter queue
+// when it has no attempts left
+func (m *Manager) Nack(id uint64, attempt int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok || job.state != leased || job.Attempts != attempt {
+		return
+	}
+
+	m.release(job, now())
+}
+
+// RestoreLease applies a lease, both issued and previously persisted, a lease from another queue
+// means the job was moved to the dead-letter queue
+func (m *Manager) RestoreLease(queue string, id uint64, attempt int, leaseUntil time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+
+	if job.Queue != queue {
+		job.Queue = queue
+		job.Attempts = 0
+	}
+
+	if attempt > job.Attempts {
+		m.lease(job, attempt, leaseUntil)
+	}
+}
+
+// Export returns copies of all jobs and the last issued job ID
+func (m *Manager) Export() ([]Job, uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, m.lastID
+}
+
+// Import applies exported jobs as is, leased jobs stay leased until their lease expires
+func (m *Manager) Import(jobs []Job, lastID uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current := now()
+	for _, job := range jobs {
+		stored := job
+		m.jobs[job.ID] = &stored
+		if !job.LeaseUntil.IsZero() {
+			m.lease(&stored, job.Attempts, job.LeaseUntil)
+		} else {
+			m.schedule(&stored, current)
+		}
+	}
+	m.lastID = max(m.lastID, lastID)
+}
+
+// promote makes visible the jobs whose delay or lease expired by the moment
+func (m *Manager) promote(current time.Time) {
+	for m.deadlines.Len() > 0 && !current.Before(m.deadlines.deadlines[0].at) {
+		entry := heap.Pop(&m.deadlines).(deadlineEntry)
+		job, ok := m.jobs[entry.id]
+		if !ok || job.generation != entry.generation {
+			continue
+		}
+
+		switch job.state {
+		case delayed:
+			m.schedule(job, current)
+		case leased:
+			m.release(job, current)
+		}
+	}
+}
+
+func (m *Manager) lease(job *Job, attempt int, leaseUntil time.Time) {
+	job.Attempts = attempt
+	job.LeaseUntil = leaseUntil
+	job.state = leased
+	job.generation++
+
+	m.addDeadline(job, leaseUntil)
+}
+
+func (m *Manager) release(job *Job, current time.Time) {
+	job.LeaseUntil = time.Time{}
+	if job.Attempts >= job.MaxAttempts && !strings.HasSuffix(job.Queue, DeadLetterSuffix) {
+		job.Queue += DeadLetterSuffix
+		job.Attempts = 0
+		job.VisibleAt = current
+	}
+
+	m.schedule(job, current)
+}
+
+// schedule puts the job to the ready jobs of its queue or waits for its visibility
+func (m *Manager) schedule(job *Job, current time.Time) {
+	job.generation++
+	if current.Before(job.VisibleAt) {
+		job.state = delayed
+		m.addDeadline(job, job.VisibleAt)
+		return
+	}
+
+	job.state = ready
+	jobs, ok := m.ready[job.Queue]
+	if !ok {
+		jobs = &jobsHeap{}
+		m.ready[job.Queue] = jobs
+	}
+
+	heap.Push(jobs, jobEntry{id: job.ID, generation: job.generation})
+}
+
+func (m *Manager) addDeadline(job *Job, at time.Time) {
+	next, ok := m.deadlines.next()
+	heap.Push(&m.deadlines, deadlineEntry{at: at, id: job.ID, generation: job.generation})
+
+	if !ok || at.Before(next) {
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// FormatReceipt returns a handle identifying a single delivery of the job
+func FormatReceipt(id uint64, attempt int) string {
+	return fmt.Sprintf("%d.%d", id, attempt)
+}
+
+func ParseReceipt(receipt string) (uint64, int, error) {
+	idPart, attemptPart, found := strings.Cut(receipt, ".")
+	if !found {
+		return 0, 0, ErrInvalidReceipt
+	}
+
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidReceipt
+	}
+
+	attempt, err := strconv.Atoi(attemptPart)
+	if err != nil || attempt <= 0 {
+		return 0, 0, ErrInvalidReceipt
+	}
+
+	return id, attempt, nil
+}
+
+type jobEntry struct {
+	id         uint64
+	generation uint64
+}
+
+// jobsHeap - ready jobs of a queue in the order they were enqueued
+type jobsHeap []jobEntry
+
+func (h jobsHeap) Len() int           { return len(h) }
+func (h jobsHeap) Less(i, j int) bool { return h[i].id < h[j].id }
+func (h jobsHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *jobsHeap) Push(x any) {
+	*h = append(*h, x.(jobEntry))
+}
+
+func (h *jobsHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+type deadlineEntry struct {
+	at         time.Time
+	id         uint64
+	generation uint64
+}
+
+// deadlinesHeap - delays and leases of all queues by expiration time
+type deadlinesHeap struct {
+	deadlines []deadlineEntry
+}
+
+func (h *deadlinesHeap) next() (time.Time, bool) {
+	if len(h.deadlines) == 0 {
+		return time.Time{}, false
+	}
+
+	return h.deadlines[0].at, true
+}
+
+func (h *deadlinesHeap) Len() int           { return len(h.deadlines) }
+func (h *deadlinesHeap) Less(i, j int) bool { return h.deadlines[i].at.Before(h.deadlines[j].at) }
+func (h *deadlinesHeap) Swap(i, j int) {
+	h.deadlines[i], h.deadlines[j] = h.deadlines[j], h.deadlines[i]
+}
+
+func (h *deadlinesHeap) Push(x any) {
+	h.deadlines = append(h.deadlines, x.(deadlineEntry))
+}
+
+func (h *deadlinesHeap) Pop() any {
+	last := h.deadlines[len(h.deadlines)-1]
+	h.deadlines = h.deadlines[:len(h.deadlines)-1]
+	return last
+}
@@ -0,0 +1,236 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mockNow(current *time.Time) func() {
+	now = func() time.Time {
+		return *current
+	}
+
+	return func() { now = time.Now }
+}
+
+// dequeue leases the next job of the queue and applies the lease
+func dequeue(manager *Manager, queue string, visibility time.Duration) (Lease, error) {
+	lease, err := manager.Next(queue, visibility)
+	if err == nil {
+		manager.RestoreLease(queue, lease.Job.ID, lease.Job.Attempts, lease.Job.LeaseUntil)
+	}
+
+	return lease, err
+}
+
+func TestManager_Next(t *testing.T) {
+	current := time.Unix(100, 0)
+	defer mockNow(&current)()
+
+	manager := NewManager()
+	manager.Add(manager.NewJob("jobs", "first", 0, 0))
+	manager.Add(manager.NewJob("jobs", "delayed", time.Minute, 0))
+	manager.Add(manager.NewJob("jobs", "second", 0, 0))
+	manager.Add(manager.NewJob("other", "other", 0, 0))
+
+	for _, payload := range []string{"first", "second"} {
+		lease, err := dequeue(manager, "jobs", time.Second)
+		if err != nil {
+			t.Fatalf("want %+v; got %+v", nil, err)
+		}
+
+		if lease.Job.Payload != payload || lease.Job.Attempts != 1 {
+			t.Errorf("want %s job with 1 attempt; got %+v", payload, lease.Job)
+		}
+
+		if lease.Receipt != FormatReceipt(lease.Job.ID, 1) {
+			t.Errorf("want receipt %s; got %s", FormatReceipt(lease.Job.ID, 1), lease.Receipt)
+		}
+	}
+
+	if _, err := dequeue(manager, "jobs", time.Second); !errors.Is(err, ErrEmpty) {
+		t.Errorf("want %+v; got %+v", ErrEmpty, err)
+	}
+
+	if _, err := dequeue(manager, "unknown", time.Second); !errors.Is(err, ErrEmpty) {
+		t.Errorf("want %+v; got %+v", ErrEmpty, err)
+	}
+
+	// the lease of the first job expires before the delay of the delayed one
+	current = current.Add(time.Minute)
+	for _, payload := range []string{"first", "delayed", "second"} {
+		lease, err := dequeue(manager, "jobs", time.Hour)
+		if err != nil {
+			t.Fatalf("want %+v; got %+v", nil, err)
+		}
+
+		if lease.Job.Payload != payload {
+			t.Errorf("want %s job; got %+v", payload, lease.Job)
+		}
+	}
+}
+
+func TestManager_AckNack(t *testing.T) {
+	current := time.Unix(100, 0)
+	defer mockNow(&current)()
+
+	manager := NewManager()
+	job := manager.NewJob("jobs", "payload", 0, 2)
+	manager.Add(job)
+
+	lease, _ := dequeue(manager, "jobs", time.Second)
+	if !manager.Leased("jobs", job.ID, 1) {
+		t.Errorf("want job to be leased")
+	}
+
+	if manager.Leased("other", job.ID, 1) || manager.Leased("jobs", job.ID, 2) {
+		t.Errorf("want lease to be checked against queue and attempt")
+	}
+
+	manager.Nack(lease.Job.ID, 1)
+	lease, err := dequeue(manager, "jobs", time.Second)
+	if err != nil || lease.Job.Attempts != 2 {
+		t.Fatalf("want second attempt; got %+v, %+v", lease, err)
+	}
+
+	// the job runs out of attempts and goes to the dead-letter queue
+	manager.Nack(lease.Job.ID, 2)
+	if _, err = dequeue(manager, "jobs", time.Second); !errors.Is(err, ErrEmpty) {
+		t.Errorf("want %+v; got %+v", ErrEmpty, err)
+	}
+
+	lease, err = dequeue(manager, "jobs"+DeadLetterSuffix, time.Second)
+	if err != nil || lease.Job.ID != job.ID || lease.Job.Attempts != 1 {
+		t.Fatalf("want dead-lettered job; got %+v, %+v", lease, err)
+	}
+
+	// the job running out of attempts in the dead-letter queue stays there
+	manager.Nack(lease.Job.ID, 1)
+	lease, _ = dequeue(manager, "jobs"+DeadLetterSuffix, time.Second)
+	manager.Nack(lease.Job.ID, 2)
+	lease, err = dequeue(manager, "jobs"+DeadLetterSuffix, time.Second)
+	if err != nil || lease.Job.ID != job.ID || lease.Job.Attempts != 3 {
+		t.Fatalf("want job to stay in the dead-letter queue; got %+v, %+v", lease, err)
+	}
+
+	if manager.Ack(lease.Job.ID, 2) {
+		t.Errorf("want stale attempt not to be acknowledged")
+	}
+
+	if !manager.Ack(lease.Job.ID, 3) || manager.Leased("jobs"+DeadLetterSuffix, job.ID, 3) {
+		t.Errorf("want acknowledged job to be deleted")
+	}
+}
+
+func TestManager_NextNotApplied(t *testing.T) {
+	manager := NewManager()
+	job := manager.NewJob("jobs", "payload", 0, 0)
+	manager.Add(job)
+
+	lease, _ := manager.Next("jobs", time.Minute)
+	if manager.Leased("jobs", job.ID, lease.Job.Attempts) {
+		t.Errorf("want job not leased until the lease is applied")
+	}
+
+	lease, err := dequeue(manager, "jobs", time.Minute)
+	if err != nil || lease.Job.Attempts != 1 {
+		t.Errorf("want job with 1 attempt; got %+v, %+v", lease, err)
+	}
+}
+
+func TestManager_RestoreLease(t *testing.T) {
+	current := time.Unix(100, 0)
+	defer mockNow(&current)()
+
+	manager := NewManager()
+	manager.Add(Job{ID: 10, Queue: "jobs", Payload: "payload", MaxAttempts: 1, VisibleAt: current})
+	manager.RestoreLease("jobs", 10, 1, current.Add(time.Second))
+	manager.RestoreLease("jobs"+DeadLetterSuffix, 10, 1, current.Add(time.Minute))
+
+	if !manager.Leased("jobs"+DeadLetterSuffix, 10, 1) {
+		t.Errorf("want job to be leased from the dead-letter queue")
+	}
+
+	job := manager.NewJob("jobs", "payload", 0, 0)
+	if job.ID != 11 {
+		t.Errorf("want next job ID %d; got %d", 11, job.ID)
+	}
+}
+
+func TestManager_Start(t *testing.T) {
+	manager := NewManager()
+	manager.Start()
+
+	job := manager.NewJob("jobs", "payload", 0, 1)
+	manager.Add(job)
+	_, _ = dequeue(manager, "jobs", 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+
+	manager.mutex.Lock()
+	queueName := manager.jobs[job.ID].Queue
+	manager.mutex.Unlock()
+
+	if queueName != "jobs"+DeadLetterSuffix {
+		t.Errorf("want expired job to be dead-lettered by the timer; got queue %s", queueName)
+	}
+
+	manager.Close()
+	_, _ = dequeue(manager, "jobs"+DeadLetterSuffix, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+
+	manager.mutex.Lock()
+	state := manager.jobs[job.ID].state
+	manager.mutex.Unlock()
+
+	if state != leased {
+		t.Errorf("want expired lease not to be released after Close; got state %d", state)
+	}
+}
+
+func TestParseReceipt(t *testing.T) {
+	tests := []struct {
+		name            string
+		receipt         string
+		expectedID      uint64
+		expectedAttempt int
+		expectedErr     error
+	}{
+		{
+			name:            "Valid receipt",
+			receipt:         "12.3",
+			expectedID:      12,
+			expectedAttempt: 3,
+		},
+		{
+			name:        "Receipt without attempt",
+			receipt:     "12",
+			expectedErr: ErrInvalidReceipt,
+		},
+		{
+			name:        "Receipt with zero attempt",
+			receipt:     "12.0",
+			expectedErr: ErrInvalidReceipt,
+		},
+		{
+			name:        "Receipt with invalid ID",
+			receipt:     "a.1",
+			expectedErr: ErrInvalidReceipt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, attempt, err := ParseReceipt(tt.receipt)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("want %+v; got %+v", tt.expectedErr, err)
+			}
+
+			if id != tt.expectedID || attempt != tt.expectedAttempt {
+				t.Errorf("want %d.%d; got %d.%d", tt.expectedID, tt.expectedAttempt, id, attempt)
+			}
+		})
+	}
+}
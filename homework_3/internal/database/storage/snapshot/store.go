@@ -0,0 +1,243 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/lock"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/queue"
+)
+
+// A snapshot file holds the magic, the version, CRC32C of the body and the gob encoded state,
+// with a keyring the whole file is encrypted
+const (
+	magic      = "SNAP"
+	version    = 1
+	headerSize = len(magic) + 2 + 4
+
+	// retained - the previous snapshot is kept so that recovery falls back to it if the newest
+	// one is damaged, WAL keeps requests after the oldest retained snapshot
+	retained = 2
+)
+
+var (
+	fileNameRe = regexp.MustCompile(`^snapshot_(\d{20})\.snap$`)
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errDamaged = errors.New("snapshot is damaged")
+)
+
+// Key - a key of the engine with its stored value, a zero expiration moment means the key does not expire
+type Key struct {
+	Key       string
+	Value     string
+	ExpiresAt time.Time
+}
+
+// State - state of the storage as of the WAL request with the sequence number
+type State struct {
+	LSN uint64
+	// KeysIncluded - keys of engines persisting them or not able to dump them are not included,
+	// their changes are recovered from WAL
+	KeysIncluded bool
+	Keys         []Key
+	Vectors      map[string]map[string][]float32
+	Locks        map[string]lock.Lease
+	LastToken    uint64
+	Jobs         []queue.Job
+	LastJobID    uint64
+}
+
+// Store - snapshot files in the directory, snapshots are written at the interval
+type Store struct {
+	directory string
+	interval  time.Duration
+	keyring   *encryption.Keyring
+	// plaintext - snapshots written without encryption are read even though the store has a keyring
+	plaintext bool
+	logger    *common.Logger
+}
+
+func NewStore(directory string, interval time.Duration, keyring *encryption.Keyring, logger *common.Logger) (*Store, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	return &Store{directory: directory, interval: interval, keyring: keyring, logger: logger}, nil
+}
+
+// AllowPlaintext makes the store read snapshots written before the keyring was configured
+func (s *Store) AllowPlaintext() {
+	s.plaintext = true
+}
+
+// Interval returns how often snapshots are taken
+func (s *Store) Interval() time.Duration {
+	return s.interval
+}
+
+// Write saves the state atomically and removes snapshots older than the retained ones
+func (s *Store) Write(state *State) error {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(state); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	data := make([]byte, headerSize, headerSize+body.Len())
+	copy(data, magic)
+	binary.LittleEndian.PutUint16(data[len(magic):], version)
+	binary.LittleEndian.PutUint32(data[len(magic)+2:], crc32.Checksum(body.Bytes(), castagnoli))
+	data = append(data, body.Bytes()...)
+
+	if s.keyring != nil {
+		encrypted, err := s.keyring.Encrypt(data)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt snapshot: %w", err)
+		}
+		data = encrypted
+	}
+
+	if err := filesystem.WriteFileAtomic(s.fileName(state.LSN), data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	lsns, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for _, lsn := range lsns[:max(len(lsns)-retained, 0)] {
+		if err = os.Remove(s.fileName(lsn)); err != nil {
+			return fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Oldest returns the sequence number of the oldest retained snapshot, WAL requests up to it
+// are not needed by recovery
+func (s *Store) Oldest() (uint64, bool, error) {
+	lsns, err := s.list()
+	if err != nil || len(lsns) == 0 {
+		return 0, false, err
+	}
+
+	return lsns[0], true, nil
+}
+
+// Load returns the newest valid snapshot or nil if there are no snapshots, damaged snapshots are
+// skipped while there is an older one, snapshots failing authentication fail the load
+func (s *Store) Load() (*State, error) {
+	lsns, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(lsns) - 1; i >= 0; i-- {
+		state, err := s.read(lsns[i])
+		if errors.Is(err, errDamaged) {
+			s.logger.Error("snapshot %d is skipped: %s", lsns[i], err)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %d: %w", lsns[i], err)
+		}
+
+		return state, nil
+	}
+
+	if len(lsns) != 0 {
+		return nil, fmt.Errorf("failed to read snapshots: all %d snapshots are damaged", len(lsns))
+	}
+
+	return nil, nil
+}
+
+func (s *Store) read(lsn uint64) (*State, error) {
+	data, err := os.ReadFile(s.fileName(lsn))
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := encryption.ParseHeader(data); ok {
+		if s.keyring == nil {
+			return nil, fmt.Errorf("snapshot is encrypted with key %d, keyfile is not configured: %w", key, encryption.ErrUnknownKey)
+		}
+
+		if data, err = s.keyring.Decrypt(data); err != nil {
+			return nil, err
+		}
+	} else if s.keyring != nil && !s.plaintext {
+		return nil, fmt.Errorf("snapshot is expected to be encrypted: %w", encryption.ErrNotEncrypted)
+	}
+
+	if len(data) < headerSize || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: invalid header", errDamaged)
+	}
+
+	if v := binary.LittleEndian.Uint16(data[len(magic):]); v != version {
+		return nil, fmt.Errorf("snapshot version %d is not supported", v)
+	}
+
+	body := data[headerSize:]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(data[len(magic)+2:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errDamaged)
+	}
+
+	var state State
+	if err = gob.NewDecoder(bytes.NewReader(body)).Decode(&state); err != nil {
+		return nil, fmt.Errorf("%w: %s", errDamaged, err)
+	}
+
+	return &state, nil
+}
+
+// list returns sequence numbers of snapshots in the ascending order, unrelated files are ignored
+func (s *Store) list() ([]uint64, error) {
+	files, err := os.ReadDir(s.directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var lsns []uint64
+	for _, file := range files {
+		match := fileNameRe.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+
+		lsn, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot name %s: %w", file.Name(), err)
+		}
+
+		lsns = append(lsns, lsn)
+	}
+
+	sort.Slice(lsns, func(i, j int) bool {
+		return lsns[i] < lsns[j]
+	})
+
+	return lsns, nil
+}
+
+func (s *Store) fileName(lsn uint64) string {
+	return filepath.Join(s.directory, fmt.Sprintf("snapshot_%020d.snap", lsn))
+}
@@ -0,0 +1,159 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/lock"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/queue"
+)
+
+func testState(lsn uint64) *State {
+	expiresAt := time.Unix(0, 1730228421090000000).UTC()
+	return &State{
+		LSN:          lsn,
+		KeysIncluded: true,
+		Keys:         []Key{{Key: "key", Value: "value"}, {Key: "expiring", Value: "value", ExpiresAt: expiresAt}},
+		Vectors:      map[string]map[string][]float32{"items": {"item": {1, 0}}},
+		Locks:        map[string]lock.Lease{"lock": {Owner: "owner", Token: 3, ExpiresAt: expiresAt}},
+		LastToken:    3,
+		Jobs:         []queue.Job{{ID: 2, Queue: "jobs", Payload: "payload", VisibleAt: expiresAt}},
+		LastJobID:    2,
+	}
+}
+
+func TestStore_WriteLoad(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	keyring, _ := encryption.NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+
+	tests := map[string]*encryption.Keyring{
+		"Plain":     nil,
+		"Encrypted": keyring,
+	}
+
+	for name, keyring := range tests {
+		t.Run(name, func(t *testing.T) {
+			store, err := NewStore(t.TempDir(), time.Minute, keyring, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if state, err := store.Load(); state != nil || err != nil {
+				t.Errorf("want no snapshot; got %+v, %+v", state, err)
+			}
+
+			for lsn := uint64(1); lsn <= 3; lsn++ {
+				if err = store.Write(testState(lsn)); err != nil {
+					t.Fatalf("want %+v; got %+v", nil, err)
+				}
+			}
+
+			state, err := store.Load()
+			if err != nil {
+				t.Fatalf("want %+v; got %+v", nil, err)
+			}
+
+			if !reflect.DeepEqual(state, testState(3)) {
+				t.Errorf("want %+v; got %+v", testState(3), state)
+			}
+
+			if oldest, ok, err := store.Oldest(); oldest != 2 || !ok || err != nil {
+				t.Errorf("want %+v; got %+v, %+v, %+v", 2, oldest, ok, err)
+			}
+		})
+	}
+}
+
+func TestStore_LoadDamaged(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	store, err := NewStore(t.TempDir(), time.Minute, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for lsn := uint64(1); lsn <= 2; lsn++ {
+		if err = store.Write(testState(lsn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = os.WriteFile(store.fileName(3), []byte("unrelated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(store.fileName(2))
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(store.fileName(2), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := store.Load()
+	if err != nil || state.LSN != 1 {
+		t.Errorf("want fallback to %+v; got %+v, %+v", 1, state, err)
+	}
+
+	if err = os.WriteFile(store.fileName(1), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.Load(); err == nil {
+		t.Errorf("want error; got %+v", err)
+	}
+}
+
+func TestStore_LoadEncrypted(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	keyring, _ := encryption.NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	directory := t.TempDir()
+
+	store, err := NewStore(directory, time.Minute, keyring, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Write(testState(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	unkeyed, _ := NewStore(directory, time.Minute, nil, logger)
+	if _, err = unkeyed.Load(); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("want %+v; got %+v", encryption.ErrUnknownKey, err)
+	}
+
+	data, _ := os.ReadFile(store.fileName(1))
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(store.fileName(1), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.Load(); !errors.Is(err, encryption.ErrAuthentication) {
+		t.Errorf("want %+v; got %+v", encryption.ErrAuthentication, err)
+	}
+}
+
+func TestStore_LoadPlaintext(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	keyring, _ := encryption.NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	directory := t.TempDir()
+
+	unkeyed, _ := NewStore(directory, time.Minute, nil, logger)
+	if err := unkeyed.Write(testState(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ := NewStore(directory, time.Minute, keyring, logger)
+	if _, err := store.Load(); !errors.Is(err, encryption.ErrNotEncrypted) {
+		t.Errorf("want %+v; got %+v", encryption.ErrNotEncrypted, err)
+	}
+
+	store.AllowPlaintext()
+	if state, err := store.Load(); err != nil || state == nil || state.LSN != 1 {
+		t.Errorf("want plaintext snapshot loaded; got %+v, %+v", state, err)
+	}
+}
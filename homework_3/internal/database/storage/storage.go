@@ -1,31 +1,198 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"hash/maphash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/compression"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/lock"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/queue"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/snapshot"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/vector"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/wal"
 )
 
-var ErrNotFound = errors.New("storage: requested data not found")
+var (
+	ErrNotFound     = errors.New("storage: requested data not found")
+	ErrNotSupported = errors.New("storage: command is not supported by the engine")
+)
 
 type Engine interface {
-	Set(string, string)
+	Set(string, string) error
 	Get(string) (string, error)
-	Del(string)
+	Del(string) error
+}
+
+const (
+	StringType = "string"
+	NoneType   = "none"
+)
+
+// statsInterval - compression statistics are logged at it while values are compressed
+const statsInterval = time.Minute
+
+// Iterator - walks keys of an ordered engine in the ascending order
+type Iterator interface {
+	// Seek moves the iterator before the first key not less than the given one
+	Seek(string)
+	// Next moves to the following key, it returns false when there are no more keys
+	Next() bool
+	Key() string
+	Value() string
+	// Close releases the iterator, it returns the error which stopped the iteration if any
+	Close() error
+}
+
+// KeyValue - a key with its value returned by range queries
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+type orderedEngine interface {
+	Iterator() Iterator
+}
+
+// Snapshot - a consistent read-only view of keys as of a commit sequence number, writes made after
+// it was taken are not seen
+type Snapshot interface {
+	// Sequence returns the commit sequence number of the last write seen by the snapshot
+	Sequence() uint64
+	Get(string) (string, error)
+	Iterator() Iterator
+	// Close releases the snapshot, versions seen only by released snapshots are garbage-collected
+	Close() error
+}
+
+type snapshotEngine interface {
+	Snapshot() Snapshot
+}
+
+type keysEngine interface {
+	Exists(string) bool
+	Rename(string, string) error
+	RenameNX(string, string) (bool, error)
+	Copy(string, string, bool) (bool, error)
+	Size() int
+}
+
+type expiringEngine interface {
+	Exists(string) bool
+	Expire(string, time.Time) bool
+}
+
+type evictingEngine interface {
+	Victims(string, string) ([]string, error)
+}
+
+// renamingEngine - evicts keys to free room for a renamed key, the memory of the source key is freed by the rename
+type renamingEngine interface {
+	RenameVictims(string, string) ([]string, error)
 }
 
+// Checkpointer - keeps memtable switches of disk-based engines consistent with WAL segments
+type Checkpointer interface {
+	// Checkpoint runs the switch while no key change is in flight and returns the id of the WAL segment
+	// starting after it, key changes logged before the segment are persisted once the old memtable is flushed
+	Checkpoint(switchMemtable func()) (int64, error)
+	// Release lets WAL drop segments covered by the flushed checkpoint
+	Release(int64) error
+}
+
+// NoCheckpointer - used by disk-based engines when there is no WAL to keep consistent with
+type NoCheckpointer struct{}
+
+func (NoCheckpointer) Checkpoint(switchMemtable func()) (int64, error) {
+	switchMemtable()
+	return 0, nil
+}
+
+func (NoCheckpointer) Release(int64) error {
+	return nil
+}
+
+type persistentEngine interface {
+	SetCheckpointer(Checkpointer)
+	// LastCheckpoint returns the checkpoint of the last flushed memtable
+	LastCheckpoint() int64
+}
+
+// dumpingEngine - captures alive keys with their stored values, the returned function walks the captured
+// keys while they may change, a zero expiration moment means the key does not expire
+type dumpingEngine interface {
+	Dump() func(func(key, value string, expiresAt time.Time))
+}
+
+type backgroundEngine interface {
+	Start()
+}
+
+// closingEngine - engine holding files, they are closed once changes are no longer applied
+type closingEngine interface {
+	Close() error
+}
+
+type throttler interface {
+	Throttle(string, throttle.Limit) throttle.Result
+}
+
+// WAL - logs changes before they are applied, changes are applied by their commits in the order
+// they are logged once they are written
 type WAL interface {
-	Set(string, string) error
-	Del(string) error
-	Recover() ([]wal.Request, error)
+	Set(string, string, wal.Commit) error
+	Del(string, wal.Commit) error
+	Rename(string, string, wal.Commit) error
+	RenameNX(string, string, wal.Commit) error
+	Copy(string, string, bool, wal.Commit) error
+	Expire(string, int64, wal.Commit) error
+	Evict([]string, wal.Commit) error
+	Rotate() (int64, error)
+	Prune(int64, func(wal.Request) bool) error
+	VAdd(string, string, []string, wal.Commit) error
+	Lock(string, string, uint64, int64, wal.Commit) error
+	Refresh(string, string, int64, wal.Commit) error
+	Unlock(string, string, wal.Commit) error
+	Enqueue(string, uint64, string, int64, int, wal.Commit) error
+	Dequeue(string, uint64, int, int64, wal.Commit) error
+	Ack(string, uint64, int, wal.Commit) error
+	Nack(string, uint64, int, wal.Commit) error
+	Replay(func(wal.Request)) error
+	Advance(uint64)
+	LastAppended() uint64
+	Snapshots() *snapshot.Store
+	Stop(context.Context) error
 }
 
 type Storage struct {
+	// writes - held for reading by key changes between WAL and the engine, checkpoints wait for them
+	writes *sync.RWMutex
+	// leases - serialize decisions about the same lock or queue until they are logged and applied
+	leases *stripes
+	// async - key changes are acknowledged without waiting for WAL to sync them
+	async bool
+	// lsn - receives sequence numbers changes are logged with
+	lsn *uint64
+
 	engine Engine
-	wal    WAL
-	logger *common.Logger
+	// compressor - encodes values written to WAL and the engine, they are decoded when read
+	compressor *compression.Compressor
+	vectors    *vector.Store
+	locks      *lock.Manager
+	queues     *queue.Manager
+	wal        WAL
+	logger     *common.Logger
+
+	// stop - closed by Stop, periodic snapshots end and close stopped
+	stop    chan struct{}
+	stopped chan struct{}
 }
 
 func NewStorage(engine Engine, wal WAL, logger *common.Logger) (*Storage, error) {
@@ -38,56 +205,1036 @@ func NewStorage(engine Engine, wal WAL, logger *common.Logger) (*Storage, error)
 	}
 
 	storage := &Storage{
-		engine: engine,
-		wal:    wal,
-		logger: logger,
+		writes:     &sync.RWMutex{},
+		leases:     &stripes{seed: maphash.MakeSeed()},
+		engine:     engine,
+		compressor: compression.Uncompressed(),
+		vectors:    vector.NewStore(),
+		locks:      lock.NewManager(),
+		queues:     queue.NewManager(),
+		wal:        wal,
+		logger:     logger,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
 	}
 
 	if storage.wal != nil {
-		requests, err := storage.wal.Recover()
-		if err != nil {
+		var state *snapshot.State
+		if snapshots := storage.wal.Snapshots(); snapshots != nil {
+			var err error
+			if state, err = snapshots.Load(); err != nil {
+				logger.Error("failed to load snapshot: %s", err)
+				return nil, err
+			}
+		}
+
+		if state != nil {
+			storage.restoreSnapshot(state)
+		}
+
+		// requests are applied while segments are read, damaged, tampered or undecryptable data
+		// must not be silently skipped, torn tails of the last segment are truncated by WAL
+		if err := storage.wal.Replay(storage.restorer(state)); err != nil {
 			logger.Error("failed to recover data from WAL: %s", err)
-		} else {
-			storage.restore(requests)
+			return nil, err
 		}
+
+		if state != nil {
+			storage.wal.Advance(state.LSN)
+		}
+	}
+
+	if engine, ok := engine.(persistentEngine); ok {
+		engine.SetCheckpointer(storage)
 	}
 
 	return storage, nil
 }
 
-func (s *Storage) Set(key, value string) error {
+// Start launches background redelivery of queued jobs, background work of the engine, periodic
+// snapshots and logging of compression statistics
+func (s *Storage) Start() {
+	s.queues.Start()
+
+	if engine, ok := s.engine.(backgroundEngine); ok {
+		engine.Start()
+	}
+
+	snapshotting := s.wal != nil && s.wal.Snapshots() != nil
+	compressing := s.compressor.Codec() != compression.NoneCodec
+	if !snapshotting && !compressing {
+		close(s.stopped)
+		return
+	}
+
+	go func() {
+		defer close(s.stopped)
+
+		// a nil channel never fires when snapshots are not taken or values are not compressed
+		var snapshots, stats <-chan time.Time
+		if snapshotting {
+			ticker := time.NewTicker(s.wal.Snapshots().Interval())
+			defer ticker.Stop()
+			snapshots = ticker.C
+		}
+
+		if compressing {
+			ticker := time.NewTicker(statsInterval)
+			defer ticker.Stop()
+			stats = ticker.C
+		}
+
+		for {
+			select {
+			case <-snapshots:
+				if err := s.SaveSnapshot(); err != nil {
+					s.logger.Error("failed to save snapshot: %s", err)
+				}
+			case <-stats:
+				current := s.CompressionStats()
+				s.logger.Info("compression stats [values %d, compressed %d, raw bytes %d, stored bytes %d, ratio %.3f]",
+					current.Values, current.Compressed, current.RawBytes, current.StoredBytes, current.Ratio())
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends periodic snapshots, statistics logging and redelivery of jobs, stops WAL once pending changes are written and
+// closes the engine, changes made after it fail, it must be called once after Start
+func (s *Storage) Stop(ctx context.Context) error {
+	close(s.stop)
+	s.queues.Close()
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var err error
 	if s.wal != nil {
-		if err := s.wal.Set(key, value); err != nil {
-			return err
+		err = s.wal.Stop(ctx)
+	}
+
+	if engine, ok := s.engine.(closingEngine); ok {
+		err = errors.Join(err, engine.Close())
+	}
+
+	return err
+}
+
+// SaveSnapshot writes the state as of the last logged request and removes WAL segments covered by
+// the retained snapshots, keys of engines persisting them are left to their checkpoints
+func (s *Storage) SaveSnapshot() error {
+	if s.wal == nil || s.wal.Snapshots() == nil {
+		return ErrNotSupported
+	}
+
+	state, segment, err := s.captureSnapshot()
+	if err != nil {
+		return err
+	}
+
+	snapshots := s.wal.Snapshots()
+	if err = snapshots.Write(state); err != nil {
+		return err
+	}
+
+	oldest, ok, err := snapshots.Oldest()
+	if err != nil || !ok {
+		return err
+	}
+
+	var checkpoint int64
+	if engine, ok := s.engine.(persistentEngine); ok {
+		checkpoint = engine.LastCheckpoint()
+	}
+
+	// requests after the oldest snapshot are kept so that recovery can fall back to it
+	return s.wal.Prune(segment, func(request wal.Request) bool {
+		if request.LSN() > oldest {
+			return false
 		}
+
+		return state.KeysIncluded || !isKeyCommand(request.Command) || request.Segment() < checkpoint
+	})
+}
+
+// captureSnapshot collects the state as of a new WAL segment, requests of older segments are covered by
+// the state, keys are walked once changes are no longer paused
+func (s *Storage) captureSnapshot() (*snapshot.State, int64, error) {
+	state, dump, segment, err := s.captureState()
+	if err != nil {
+		return nil, 0, err
 	}
 
-	s.engine.Set(key, value)
-	return nil
+	if dump != nil {
+		state.KeysIncluded = true
+		dump(func(key, value string, expiresAt time.Time) {
+			state.Keys = append(state.Keys, snapshot.Key{Key: key, Value: value, ExpiresAt: expiresAt})
+		})
+	}
+
+	return state, segment, nil
+}
+
+// captureState starts a new WAL segment while no change is in flight, changes are paused only until
+// the keys of the engine are captured, the returned function walks them
+func (s *Storage) captureState() (*snapshot.State, func(func(string, string, time.Time)), int64, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	segment, err := s.wal.Rotate()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var dump func(func(string, string, time.Time))
+	if engine, ok := s.engine.(dumpingEngine); ok {
+		if _, persistent := s.engine.(persistentEngine); !persistent {
+			dump = engine.Dump()
+		}
+	}
+
+	state := &snapshot.State{LSN: s.wal.LastAppended()}
+	state.Vectors = s.vectors.Export()
+	state.Locks, state.LastToken = s.locks.Export()
+	state.Jobs, state.LastJobID = s.queues.Export()
+	return state, dump, segment, nil
+}
+
+func (s *Storage) Checkpoint(switchMemtable func()) (int64, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	if s.wal == nil {
+		switchMemtable()
+		return 0, nil
+	}
+
+	checkpoint, err := s.wal.Rotate()
+	if err != nil {
+		return 0, err
+	}
+
+	switchMemtable()
+	return checkpoint, nil
+}
+
+// Release drops WAL segments before the checkpoint, segments with requests to other subsystems are kept
+func (s *Storage) Release(checkpoint int64) error {
+	if s.wal == nil {
+		return nil
+	}
+
+	return s.wal.Prune(checkpoint, func(request wal.Request) bool {
+		return isKeyCommand(request.Command)
+	})
+}
+
+// isKeyCommand reports whether the request changes keys of the engine
+func isKeyCommand(command string) bool {
+	switch command {
+	case compute.SetCommand, compute.DelCommand, compute.RenameCommand, compute.RenameNXCommand,
+		compute.CopyCommand, compute.ExpireCommand, compute.EvictCommand:
+		return true
+	}
+
+	return false
+}
+
+// Async returns the storage whose changes are acknowledged once they are written to WAL without
+// waiting for them to be synced to disk, changes may be lost on a crash even in the strict sync mode
+func (s *Storage) Async() *Storage {
+	async := *s
+	async.async = true
+	return &async
+}
+
+// Sequenced returns the storage storing the sequence number every change is logged with into lsn,
+// it is left as is if the change is not logged
+func (s *Storage) Sequenced(lsn *uint64) *Storage {
+	sequenced := *s
+	sequenced.lsn = lsn
+	return &sequenced
+}
+
+// SetCompressor sets the compressor of values written after it, values written before are still read
+func (s *Storage) SetCompressor(compressor *compression.Compressor) {
+	s.compressor = compressor
+}
+
+// CompressionStats returns sizes of values written since the start before and after encoding
+func (s *Storage) CompressionStats() compression.Stats {
+	return s.compressor.Stats()
+}
+
+func (s *Storage) Set(key, value string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	stored := s.compressor.Encode(value)
+	if err := s.evict(key, stored); err != nil {
+		return err
+	}
+
+	return s.logApplied(func() error {
+		return s.engine.Set(key, stored)
+	}, func(commit wal.Commit) error {
+		return s.wal.Set(key, stored, commit)
+	})
 }
 
 func (s *Storage) Get(key string) (string, error) {
-	return s.engine.Get(key)
+	stored, err := s.engine.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	return compression.Decode(stored)
 }
 
 func (s *Storage) Del(key string) error {
-	if s.wal != nil {
-		if err := s.wal.Del(key); err != nil {
-			return err
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	return s.logApplied(func() error {
+		return s.engine.Del(key)
+	}, func(commit wal.Commit) error {
+		return s.wal.Del(key, commit)
+	})
+}
+
+func (s *Storage) Exists(key string) (bool, error) {
+	engine, ok := s.engine.(keysEngine)
+	if !ok {
+		return false, ErrNotSupported
+	}
+
+	return engine.Exists(key), nil
+}
+
+func (s *Storage) Type(key string) (string, error) {
+	exists, err := s.Exists(key)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return NoneType, nil
+	}
+
+	return StringType, nil
+}
+
+func (s *Storage) Rename(source, destination string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	engine, err := s.writableKeysEngine(source)
+	if err != nil {
+		return err
+	}
+
+	if err = s.evictRenamed(source, destination); err != nil {
+		return err
+	}
+
+	var renameErr error
+	err = s.log(func() {
+		renameErr = engine.Rename(source, destination)
+	}, func(commit wal.Commit) error {
+		return s.wal.Rename(source, destination, commit)
+	})
+	if err != nil {
+		return err
+	}
+
+	return renameErr
+}
+
+func (s *Storage) RenameNX(source, destination string) (bool, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	engine, err := s.writableKeysEngine(source)
+	if err != nil {
+		return false, err
+	}
+
+	if !engine.Exists(destination) {
+		if err = s.evictRenamed(source, destination); err != nil {
+			return false, err
 		}
 	}
 
-	s.engine.Del(key)
-	return nil
+	var renamed bool
+	var renameErr error
+	err = s.log(func() {
+		renamed, renameErr = engine.RenameNX(source, destination)
+	}, func(commit wal.Commit) error {
+		return s.wal.RenameNX(source, destination, commit)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return renamed, renameErr
+}
+
+func (s *Storage) Copy(source, destination string, replace bool) (bool, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	engine, err := s.writableKeysEngine(source)
+	if err != nil {
+		return false, err
+	}
+
+	value, err := s.engine.Get(source)
+	if err != nil {
+		return false, err
+	}
+
+	if err = s.evict(destination, value); err != nil {
+		return false, err
+	}
+
+	var copied bool
+	var copyErr error
+	err = s.log(func() {
+		copied, copyErr = engine.Copy(source, destination, replace)
+	}, func(commit wal.Commit) error {
+		return s.wal.Copy(source, destination, replace, commit)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return copied, copyErr
 }
 
-func (s *Storage) restore(requests []wal.Request) {
-	for _, request := range requests {
+// Expire sets the time to live of the key, it reports whether the key exists
+func (s *Storage) Expire(key string, ttl time.Duration) (bool, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	engine, ok := s.engine.(expiringEngine)
+	if !ok {
+		return false, ErrNotSupported
+	}
+
+	if !engine.Exists(key) {
+		return false, nil
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	var exists bool
+	err := s.log(func() {
+		exists = engine.Expire(key, expiresAt)
+	}, func(commit wal.Commit) error {
+		return s.wal.Expire(key, expiresAt.UnixNano(), commit)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (s *Storage) Size() (int, error) {
+	engine, ok := s.engine.(keysEngine)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	return engine.Size(), nil
+}
+
+// Snapshot pins the current state of keys for consistent multi-key reads, it must be closed
+func (s *Storage) Snapshot() (Snapshot, error) {
+	engine, ok := s.engine.(snapshotEngine)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	return decodingSnapshot{Snapshot: engine.Snapshot()}, nil
+}
+
+// Range returns keys from start up to end, which is not included, in the ascending order,
+// an empty end means no upper bound and a zero limit means no limit
+func (s *Storage) Range(start, end string, limit int) ([]KeyValue, error) {
+	return s.scan(start, limit, func(key string) bool {
+		return end == "" || key < end
+	})
+}
+
+// Prefix returns keys starting with the prefix in the ascending order, a zero limit means no limit
+func (s *Storage) Prefix(prefix string, limit int) ([]KeyValue, error) {
+	return s.scan(prefix, limit, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// scan iterates keys from start while they are in range, writes are not blocked by the iteration
+func (s *Storage) scan(start string, limit int, inRange func(string) bool) ([]KeyValue, error) {
+	engine, ok := s.engine.(orderedEngine)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	iterator := engine.Iterator()
+	decoding := &decodingIterator{Iterator: iterator}
+	var result []KeyValue
+	for decoding.Seek(start); decoding.Next() && inRange(decoding.Key()); {
+		result = append(result, KeyValue{Key: decoding.Key(), Value: decoding.Value()})
+		if len(result) == limit {
+			break
+		}
+	}
+
+	if err := decoding.Close(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// decodingSnapshot - returns values of the engine snapshot decoded
+type decodingSnapshot struct {
+	Snapshot
+}
+
+func (s decodingSnapshot) Get(key string) (string, error) {
+	stored, err := s.Snapshot.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	return compression.Decode(stored)
+}
+
+func (s decodingSnapshot) Iterator() Iterator {
+	return &decodingIterator{Iterator: s.Snapshot.Iterator()}
+}
+
+// decodingIterator - returns values of the engine iterator decoded, the iteration stops at a value
+// which cannot be decoded and the error is returned by Close
+type decodingIterator struct {
+	Iterator
+	value string
+	err   error
+}
+
+func (it *decodingIterator) Next() bool {
+	if it.err != nil || !it.Iterator.Next() {
+		return false
+	}
+
+	it.value, it.err = compression.Decode(it.Iterator.Value())
+	return it.err == nil
+}
+
+func (it *decodingIterator) Value() string {
+	return it.value
+}
+
+func (it *decodingIterator) Close() error {
+	if err := it.Iterator.Close(); err != nil {
+		return err
+	}
+
+	return it.err
+}
+
+// log writes the change to WAL which applies it in the order changes are logged, the change is not
+// applied if it cannot be written, without WAL it is applied at once
+func (s *Storage) log(apply func(), write func(wal.Commit) error) error {
+	if s.wal == nil {
+		apply()
+		return nil
+	}
+
+	return write(wal.Commit{Apply: apply, Async: s.async, LSN: s.lsn})
+}
+
+// logApplied logs the change as log does and returns the error of applying it, the change is
+// applied before the write is acknowledged
+func (s *Storage) logApplied(apply func() error, write func(wal.Commit) error) error {
+	var applyErr error
+	if err := s.log(func() { applyErr = apply() }, write); err != nil {
+		return err
+	}
+
+	return applyErr
+}
+
+// logSynced logs the change as log does but it is synced before it is acknowledged whatever the sync mode is
+func (s *Storage) logSynced(apply func(), write func(wal.Commit) error) error {
+	return s.log(apply, func(commit wal.Commit) error {
+		commit.Sync = true
+		return write(commit)
+	})
+}
+
+// stripes - mutexes picked by names, names sharing a mutex wait for each other
+type stripes struct {
+	seed    maphash.Seed
+	mutexes [64]sync.Mutex
+}
+
+// lock locks the mutex of the name and returns the function unlocking it
+func (s *stripes) lock(name string) func() {
+	mutex := &s.mutexes[maphash.String(s.seed, name)%uint64(len(s.mutexes))]
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// writableKeysEngine checks the source key exists before the change is written to WAL
+func (s *Storage) writableKeysEngine(source string) (keysEngine, error) {
+	engine, ok := s.engine.(keysEngine)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	if !engine.Exists(source) {
+		return nil, ErrNotFound
+	}
+
+	return engine, nil
+}
+
+// evict frees memory for the value before it is written, evicted keys are removed in the order
+// the eviction is logged so that a key written concurrently is removed both in memory and by recovery
+func (s *Storage) evict(key, value string) error {
+	engine, ok := s.engine.(evictingEngine)
+	if !ok {
+		return nil
+	}
+
+	victims, err := engine.Victims(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.logEviction(victims)
+}
+
+// evictRenamed frees memory for the source renamed to the destination as evict does
+func (s *Storage) evictRenamed(source, destination string) error {
+	engine, ok := s.engine.(renamingEngine)
+	if !ok {
+		return nil
+	}
+
+	victims, err := engine.RenameVictims(source, destination)
+	if err != nil {
+		return err
+	}
+
+	return s.logEviction(victims)
+}
+
+// logEviction removes the victims in the order the eviction is logged
+func (s *Storage) logEviction(victims []string) error {
+	if len(victims) == 0 {
+		return nil
+	}
+
+	return s.logApplied(func() error {
+		var err error
+		for _, victim := range victims {
+			err = errors.Join(err, s.engine.Del(victim))
+		}
+		return err
+	}, func(commit wal.Commit) error {
+		return s.wal.Evict(victims, commit)
+	})
+}
+
+func (s *Storage) VAdd(index, key string, vector []float32) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if err := s.vectors.Validate(index, len(vector)); err != nil {
+		return err
+	}
+
+	var addErr error
+	err := s.log(func() {
+		addErr = s.vectors.Add(index, key, vector)
+	}, func(commit wal.Commit) error {
+		coordinates := make([]string, 0, len(vector))
+		for _, value := range vector {
+			coordinates = append(coordinates, strconv.FormatFloat(float64(value), 'g', -1, 32))
+		}
+
+		return s.wal.VAdd(index, key, coordinates, commit)
+	})
+	if err != nil {
+		return err
+	}
+
+	return addErr
+}
+
+func (s *Storage) VSearch(index, metric string, limit int, query []float32, prefix string) ([]vector.Result, error) {
+	return s.vectors.Search(index, metric, limit, query, prefix)
+}
+
+// Lock acquires the lock for the owner and returns its fencing token, lock changes are synced before they
+// are acknowledged so that a token is never issued twice
+func (s *Storage) Lock(name, owner string, ttl time.Duration) (uint64, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+	defer s.leases.lock(name)()
+
+	lease, err := s.locks.Grant(name, owner, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.logSynced(func() {
+		s.locks.RestoreGrant(name, lease)
+	}, func(commit wal.Commit) error {
+		return s.wal.Lock(name, owner, lease.Token, lease.ExpiresAt.UnixNano(), commit)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return lease.Token, nil
+}
+
+func (s *Storage) Refresh(name, owner string, ttl time.Duration) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+	defer s.leases.lock(name)()
+
+	lease, err := s.locks.Extend(name, owner, ttl)
+	if err != nil {
+		return err
+	}
+
+	return s.logSynced(func() {
+		s.locks.RestoreRefresh(name, owner, lease.ExpiresAt)
+	}, func(commit wal.Commit) error {
+		return s.wal.Refresh(name, owner, lease.ExpiresAt.UnixNano(), commit)
+	})
+}
+
+func (s *Storage) Unlock(name, owner string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+	defer s.leases.lock(name)()
+
+	if err := s.locks.Held(name, owner); err != nil {
+		return err
+	}
+
+	return s.logSynced(func() {
+		_ = s.locks.Release(name, owner)
+	}, func(commit wal.Commit) error {
+		return s.wal.Unlock(name, owner, commit)
+	})
+}
+
+// Throttle accounts a request against the rate limiter of the key, limiters state is not persisted
+func (s *Storage) Throttle(key string, limit throttle.Limit) (throttle.Result, error) {
+	if err := limit.Validate(); err != nil {
+		return throttle.Result{}, err
+	}
+
+	engine, ok := s.engine.(throttler)
+	if !ok {
+		return throttle.Result{}, ErrNotSupported
+	}
+
+	return engine.Throttle(key, limit), nil
+}
+
+func (s *Storage) Enqueue(queueName, payload string, delay time.Duration, maxAttempts int) (uint64, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	job := s.queues.NewJob(queueName, payload, delay, maxAttempts)
+	err := s.log(func() {
+		s.queues.Add(job)
+	}, func(commit wal.Commit) error {
+		return s.wal.Enqueue(queueName, job.ID, payload, job.VisibleAt.UnixNano(), job.MaxAttempts, commit)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return job.ID, nil
+}
+
+func (s *Storage) Dequeue(queueName string, visibility time.Duration) (queue.Lease, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+	defer s.leases.lock(queueName)()
+
+	lease, err := s.queues.Next(queueName, visibility)
+	if err != nil {
+		return queue.Lease{}, err
+	}
+
+	job := lease.Job
+	err = s.log(func() {
+		s.queues.RestoreLease(queueName, job.ID, job.Attempts, job.LeaseUntil)
+	}, func(commit wal.Commit) error {
+		return s.wal.Dequeue(queueName, job.ID, job.Attempts, job.LeaseUntil.UnixNano(), commit)
+	})
+	if err != nil {
+		return queue.Lease{}, err
+	}
+
+	return lease, nil
+}
+
+func (s *Storage) Ack(queueName, receipt string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+	defer s.leases.lock(queueName)()
+
+	id, attempt, err := queue.ParseReceipt(receipt)
+	if err != nil {
+		return err
+	}
+
+	if !s.queues.Leased(queueName, id, attempt) {
+		return queue.ErrInvalidReceipt
+	}
+
+	return s.logApplied(func() error {
+		if !s.queues.Ack(id, attempt) {
+			return queue.ErrInvalidReceipt
+		}
+
+		return nil
+	}, func(commit wal.Commit) error {
+		return s.wal.Ack(queueName, id, attempt, commit)
+	})
+}
+
+func (s *Storage) Nack(queueName, receipt string) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+	defer s.leases.lock(queueName)()
+
+	id, attempt, err := queue.ParseReceipt(receipt)
+	if err != nil {
+		return err
+	}
+
+	if !s.queues.Leased(queueName, id, attempt) {
+		return queue.ErrInvalidReceipt
+	}
+
+	return s.log(func() {
+		s.queues.Nack(id, attempt)
+	}, func(commit wal.Commit) error {
+		return s.wal.Nack(queueName, id, attempt, commit)
+	})
+}
+
+// restorer returns a function applying requests logged after the snapshot if any, key changes are
+// applied from the start of WAL if the snapshot does not include keys
+func (s *Storage) restorer(state *snapshot.State) func(wal.Request) {
+	var checkpoint int64
+	if engine, ok := s.engine.(persistentEngine); ok {
+		checkpoint = engine.LastCheckpoint()
+	}
+
+	return func(request wal.Request) {
+		// key changes before the checkpoint are already persisted by the engine
+		if request.Segment() < checkpoint && isKeyCommand(request.Command) {
+			return
+		}
+
+		if state != nil && request.LSN() <= state.LSN && (state.KeysIncluded || !isKeyCommand(request.Command)) {
+			return
+		}
+
 		switch request.Command {
 		case compute.SetCommand:
-			s.engine.Set(request.Arguments[0], request.Arguments[1])
+			s.restoreChange(request.Command, request.Arguments[0], s.engine.Set(request.Arguments[0], request.Arguments[1]))
 		case compute.DelCommand:
-			s.engine.Del(request.Arguments[0])
+			s.restoreChange(request.Command, request.Arguments[0], s.engine.Del(request.Arguments[0]))
+		case compute.EvictCommand:
+			for _, key := range request.Arguments {
+				s.restoreChange(request.Command, key, s.engine.Del(key))
+			}
+		case compute.ExpireCommand:
+			s.restoreExpire(request.Arguments)
+		case compute.RenameCommand, compute.RenameNXCommand, compute.CopyCommand:
+			s.restoreKey(request.Command, request.Arguments)
+		case compute.VAddCommand:
+			s.restoreVector(request.Arguments)
+		case compute.LockCommand, compute.RefreshCommand:
+			s.restoreLease(request.Command, request.Arguments)
+		case compute.UnlockCommand:
+			_ = s.locks.Release(request.Arguments[0], request.Arguments[1])
+		case compute.EnqueueCommand, compute.DequeueCommand, compute.AckCommand, compute.NackCommand:
+			if err := s.restoreJob(request.Command, request.Arguments); err != nil {
+				s.logger.Error("failed to restore %s [queue %s]: %s", request.Command, request.Arguments[0], err)
+			}
 		}
 	}
 }
+
+func (s *Storage) restoreSnapshot(state *snapshot.State) {
+	for _, key := range state.Keys {
+		if err := s.engine.Set(key.Key, key.Value); err != nil {
+			s.logger.Error("failed to restore key %s: %s", key.Key, err)
+			continue
+		}
+
+		if key.ExpiresAt.IsZero() {
+			continue
+		}
+
+		if engine, ok := s.engine.(expiringEngine); ok {
+			engine.Expire(key.Key, key.ExpiresAt)
+		}
+	}
+
+	for index, vectors := range state.Vectors {
+		for key, vector := range vectors {
+			if err := s.vectors.Add(index, key, vector); err != nil {
+				s.logger.Error("failed to restore vector [index %s, key %s]: %s", index, key, err)
+			}
+		}
+	}
+
+	s.locks.Import(state.Locks, state.LastToken)
+	s.queues.Import(state.Jobs, state.LastJobID)
+	s.logger.Info("state has been restored from the snapshot at %d", state.LSN)
+}
+
+// restoreChange reports the key change which failed to be applied at startup
+func (s *Storage) restoreChange(command, key string, err error) {
+	if err != nil {
+		s.logger.Error("failed to restore %s [key %s]: %s", command, key, err)
+	}
+}
+
+func (s *Storage) restoreKey(command string, args []string) {
+	engine, ok := s.engine.(keysEngine)
+	if !ok {
+		s.logger.Error("failed to restore %s [key %s]: %s", command, args[0], ErrNotSupported)
+		return
+	}
+
+	switch command {
+	case compute.RenameCommand:
+		_ = engine.Rename(args[0], args[1])
+	case compute.RenameNXCommand:
+		_, _ = engine.RenameNX(args[0], args[1])
+	case compute.CopyCommand:
+		_, _ = engine.Copy(args[0], args[1], len(args) > 2)
+	}
+}
+
+func (s *Storage) restoreExpire(args []string) {
+	engine, ok := s.engine.(expiringEngine)
+	if !ok {
+		s.logger.Error("failed to restore EXPIRE [key %s]: %s", args[0], ErrNotSupported)
+		return
+	}
+
+	expiresAt, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		s.logger.Error("failed to restore EXPIRE [key %s]: %s", args[0], err)
+		return
+	}
+
+	engine.Expire(args[0], time.Unix(0, expiresAt))
+}
+
+func (s *Storage) restoreVector(args []string) {
+	coordinates, err := compute.ParseVector(args[2:])
+	if err == nil {
+		err = s.vectors.Add(args[0], args[1], coordinates)
+	}
+
+	if err != nil {
+		s.logger.Error("failed to restore vector [index %s, key %s]: %s", args[0], args[1], err)
+	}
+}
+
+func (s *Storage) restoreLease(command string, args []string) {
+	expiresAt, err := strconv.ParseInt(args[len(args)-1], 10, 64)
+	if err != nil {
+		s.logger.Error("failed to restore lock [name %s, owner %s]: %s", args[0], args[1], err)
+		return
+	}
+
+	if command == compute.RefreshCommand {
+		s.locks.RestoreRefresh(args[0], args[1], time.Unix(0, expiresAt))
+		return
+	}
+
+	token, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		s.logger.Error("failed to restore lock [name %s, owner %s]: %s", args[0], args[1], err)
+		return
+	}
+
+	s.locks.RestoreGrant(args[0], lock.Lease{Owner: args[1], Token: token, ExpiresAt: time.Unix(0, expiresAt)})
+}
+
+func (s *Storage) restoreJob(command string, args []string) error {
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case compute.EnqueueCommand:
+		visibleAt, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		maxAttempts, err := strconv.Atoi(args[4])
+		if err != nil {
+			return err
+		}
+
+		s.queues.Add(queue.Job{
+			ID:          id,
+			Queue:       args[0],
+			Payload:     args[2],
+			MaxAttempts: maxAttempts,
+			VisibleAt:   time.Unix(0, visibleAt),
+		})
+	case compute.DequeueCommand:
+		attempt, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+
+		leaseUntil, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		s.queues.RestoreLease(args[0], id, attempt, time.Unix(0, leaseUntil))
+	case compute.AckCommand, compute.NackCommand:
+		attempt, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+
+		if command == compute.AckCommand {
+			s.queues.Ack(id, attempt)
+		} else {
+			s.queues.Nack(id, attempt)
+		}
+	}
+
+	return nil
+}
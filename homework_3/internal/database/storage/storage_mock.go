@@ -1,5 +1,7 @@
 package storage
 
+import "time"
+
 // MockEngine is mock of Engine interface
 type MockEngine struct {
 	Key   string
@@ -13,11 +15,12 @@ func NewMockEngine() *MockEngine {
 }
 
 // Del mocks method
-func (m *MockEngine) Del(key string) {
+func (m *MockEngine) Del(key string) error {
 	if m.Key == key {
 		m.Key = ""
 		m.Value = ""
 	}
+	return nil
 }
 
 // Get mocks method
@@ -29,7 +32,18 @@ func (m *MockEngine) Get(key string) (string, error) {
 }
 
 // Set mocks method
-func (m *MockEngine) Set(key, value string) {
+func (m *MockEngine) Set(key, value string) error {
 	m.Key = key
 	m.Value = value
+	return nil
+}
+
+// Dump mocks method
+func (m *MockEngine) Dump() func(func(key, value string, expiresAt time.Time)) {
+	key, value := m.Key, m.Value
+	return func(fn func(key, value string, expiresAt time.Time)) {
+		if key != "" {
+			fn(key, value, time.Time{})
+		}
+	}
 }
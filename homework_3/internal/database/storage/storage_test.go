@@ -1,10 +1,25 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/compression"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/lock"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/queue"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/throttle"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/vector"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/wal"
 )
 
 func TestNewStorage(t *testing.T) {
@@ -103,6 +118,38 @@ func TestStorage_Set(t *testing.T) {
 	}
 }
 
+func TestStorage_Compression(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine := NewMockEngine()
+	storage, err := NewStorage(engine, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressor, _ := compression.NewCompressor(compression.FlateCodec, 0)
+	storage.SetCompressor(compressor)
+
+	value := strings.Repeat("some_value", 100)
+	storage.Set("some_key", value)
+	if len(engine.Value) >= len(value) {
+		t.Errorf("want value compressed in the engine; got %d bytes", len(engine.Value))
+	}
+
+	if stored, err := storage.Get("some_key"); err != nil || stored != value {
+		t.Errorf("want %+v; got %+v, %+v", value, stored, err)
+	}
+
+	if stats := storage.CompressionStats(); stats.Compressed != 1 {
+		t.Errorf("want 1 compressed value; got %+v", stats)
+	}
+
+	// statistics are logged in the background until the storage stops
+	storage.Start()
+	if err = storage.Stop(context.Background()); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+}
+
 func TestStorage_Del(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -138,3 +185,728 @@ func TestStorage_Del(t *testing.T) {
 		})
 	}
 }
+
+// restore applies the requests as they are recovered from WAL without a snapshot
+func restore(storage *Storage, requests ...wal.Request) {
+	apply := storage.restorer(nil)
+	for _, request := range requests {
+		apply(request)
+	}
+}
+
+func TestStorage_RestoreEvict(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	storage, err := NewStorage(NewMockEngine(), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore(storage,
+		wal.NewRequest(compute.SetCommand, []string{"key", "value"}),
+		wal.NewRequest(compute.EvictCommand, []string{"other_key", "key"}),
+	)
+
+	if _, err = storage.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want evicted key not restored; got %+v", err)
+	}
+}
+
+type checkpointedMockEngine struct {
+	*MockEngine
+	checkpointer Checkpointer
+	checkpoint   int64
+}
+
+func (e *checkpointedMockEngine) SetCheckpointer(checkpointer Checkpointer) {
+	e.checkpointer = checkpointer
+}
+
+func (e *checkpointedMockEngine) LastCheckpoint() int64 {
+	return e.checkpoint
+}
+
+func TestStorage_Checkpoint(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir()}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	engine := &checkpointedMockEngine{MockEngine: NewMockEngine()}
+	storage, err := NewStorage(engine, writeAheadLog, logger)
+	if err != nil || engine.checkpointer != storage {
+		t.Fatalf("want checkpointer set; got %+v", err)
+	}
+
+	if err = storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.VAdd("items", "item", []float32{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	switched := false
+	checkpoint, err := storage.Checkpoint(func() { switched = true })
+	if err != nil || !switched {
+		t.Fatalf("want memtable switched; got %+v", err)
+	}
+
+	if err = storage.Release(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine = &checkpointedMockEngine{MockEngine: NewMockEngine(), checkpoint: checkpoint}
+	storage, err = NewStorage(engine, recovered, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want key change before checkpoint skipped; got %+v", err)
+	}
+
+	if results, err := storage.VSearch("items", vector.CosineMetric, 1, []float32{1}, ""); err != nil || len(results) != 1 {
+		t.Errorf("want vector kept in WAL; got %+v, %+v", results, err)
+	}
+}
+
+func TestStorage_RecoverTampered(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	directory := t.TempDir()
+	keyfile := filepath.Join(directory, "keyfile")
+	if err := os.WriteFile(keyfile, []byte("1 "+strings.Repeat("01", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: filepath.Join(directory, "wal"), KeyFile: keyfile}
+	if err := os.Mkdir(config.DirPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	storage, err := NewStorage(NewMockEngine(), writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(config.DirPath, "*.log"))
+	data, _ := os.ReadFile(segments[0])
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(segments[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewStorage(NewMockEngine(), recovered, logger); !errors.Is(err, encryption.ErrAuthentication) {
+		t.Errorf("want %+v; got %+v", encryption.ErrAuthentication, err)
+	}
+}
+
+func TestStorage_Snapshot(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	directory := t.TempDir()
+	config := &common.WalConfig{
+		BatchSize:        1,
+		FlushingTimeout:  "1ms",
+		SegmentSize:      "1KB",
+		DirPath:          filepath.Join(directory, "wal"),
+		SnapshotInterval: "1h",
+		SnapshotDirPath:  filepath.Join(directory, "snapshots"),
+	}
+	if err := os.Mkdir(config.DirPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	storage, err := NewStorage(NewMockEngine(), writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.VAdd("items", "item", []float32{1, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Lock("lock", "owner_1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Enqueue("jobs", "payload", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	covered, _ := filepath.Glob(filepath.Join(config.DirPath, "*.log"))
+	if err = storage.SaveSnapshot(); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	for _, segment := range covered {
+		if _, err = os.Stat(segment); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("want segment %s removed; got %+v", segment, err)
+		}
+	}
+
+	if err = storage.Set("key", "other_value"); err != nil {
+		t.Fatal(err)
+	}
+	lsn := writeAheadLog.LastAppended()
+
+	recovered, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered.Start(context.Background())
+
+	restored, err := NewStorage(NewMockEngine(), recovered, logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if recovered.LastAppended() != lsn {
+		t.Errorf("want %+v; got %+v", lsn, recovered.LastAppended())
+	}
+
+	if value, err := restored.Get("key"); err != nil || value != "other_value" {
+		t.Errorf("want %+v; got %+v, %+v", "other_value", value, err)
+	}
+
+	if results, err := restored.VSearch("items", vector.CosineMetric, 1, []float32{1, 0}, ""); err != nil || len(results) != 1 {
+		t.Errorf("want %+v; got %+v, %+v", "item", results, err)
+	}
+
+	if _, err = restored.Lock("lock", "owner_2", time.Minute); !errors.Is(err, lock.ErrLocked) {
+		t.Errorf("want %+v; got %+v", lock.ErrLocked, err)
+	}
+
+	if lease, err := restored.Dequeue("jobs", time.Minute); err != nil || lease.Job.Payload != "payload" {
+		t.Errorf("want %+v; got %+v, %+v", "payload", lease, err)
+	}
+}
+
+func TestStorage_Stop(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir()}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	engine := &closingMockEngine{MockEngine: NewMockEngine()}
+	storage, err := NewStorage(engine, writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Start()
+
+	if err = storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Stop(context.Background()); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if engine.closes != 1 {
+		t.Errorf("want engine closed once; got %+v", engine.closes)
+	}
+
+	if err = storage.Set("key", "other_value"); !errors.Is(err, wal.ErrClosed) {
+		t.Errorf("want %+v; got %+v", wal.ErrClosed, err)
+	}
+
+	if value, _ := storage.Get("key"); value != "value" {
+		t.Errorf("want %+v; got %+v", "value", value)
+	}
+}
+
+func TestStorage_Async(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir(), SyncMode: wal.SyncNone}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	storage, err := NewStorage(NewMockEngine(), writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Async().Set("key", "value"); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if value, err := storage.Get("key"); err != nil || value != "value" {
+		t.Errorf("want %+v; got %+v, %+v", "value", value, err)
+	}
+
+	var requests []wal.Request
+	err = writeAheadLog.Replay(func(request wal.Request) { requests = append(requests, request) })
+	if err != nil || len(requests) != 1 {
+		t.Errorf("want async change logged; got %+v, %+v", requests, err)
+	}
+}
+
+func TestStorage_EngineError(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir()}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	failure := errors.New("disk is full")
+	tests := []struct {
+		name string
+		wal  WAL
+	}{
+		{name: "Without WAL"},
+		{name: "With WAL", wal: writeAheadLog},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(&failingMockEngine{MockEngine: NewMockEngine(), err: failure}, test.wal, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = storage.Set("key", "value"); !errors.Is(err, failure) {
+				t.Errorf("want %+v; got %+v", failure, err)
+			}
+
+			if err = storage.Del("key"); !errors.Is(err, failure) {
+				t.Errorf("want %+v; got %+v", failure, err)
+			}
+		})
+	}
+}
+
+func TestStorage_LockSynced(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir(), SyncMode: wal.SyncNone}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	storage, err := NewStorage(NewMockEngine(), writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if durable := writeAheadLog.LastDurable(); durable != 0 {
+		t.Errorf("want key change not synced; got durable %+v", durable)
+	}
+
+	if _, err = storage.Async().Lock("name", "owner", time.Minute); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	// grants are synced in any mode so that their fencing tokens survive a crash
+	if durable := writeAheadLog.LastDurable(); durable != 2 {
+		t.Errorf("want %+v; got %+v", 2, durable)
+	}
+}
+
+func TestStorage_Sequenced(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir()}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	storage, err := NewStorage(NewMockEngine(), writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	var lsn uint64
+	if err = storage.Sequenced(&lsn).Set("key", "new_value"); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if lsn != 2 || writeAheadLog.LastAppended() != lsn || writeAheadLog.LastDurable() != lsn {
+		t.Errorf("want sequence number %+v; got %+v, appended %+v, durable %+v", 2, lsn, writeAheadLog.LastAppended(), writeAheadLog.LastDurable())
+	}
+}
+
+func TestStorage_VAdd(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	storage, err := NewStorage(NewMockEngine(), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.VAdd("items", "item_1", []float32{1, 0}); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err = storage.VAdd("items", "item_2", []float32{1, 0, 1}); !errors.Is(err, vector.ErrDimensionMismatch) {
+		t.Errorf("want %+v; got %+v", vector.ErrDimensionMismatch, err)
+	}
+
+	restore(storage,
+		wal.NewRequest(compute.VAddCommand, []string{"items", "item_2", "0", "1"}),
+		wal.NewRequest(compute.VAddCommand, []string{"items", "item_3", "1"}),
+	)
+
+	results, err := storage.VSearch("items", vector.CosineMetric, 10, []float32{0, 1}, "")
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	expected := []vector.Result{{Key: "item_2", Score: 1}, {Key: "item_1", Score: 0}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("want %+v; got %+v", expected, results)
+	}
+}
+
+func TestStorage_Lock(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	storage, err := NewStorage(NewMockEngine(), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore(storage,
+		wal.NewRequest(compute.LockCommand, []string{"restored", "owner_1", "10", strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10)}),
+		wal.NewRequest(compute.LockCommand, []string{"released", "owner_1", "11", strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10)}),
+		wal.NewRequest(compute.UnlockCommand, []string{"released", "owner_1"}),
+	)
+
+	if _, err = storage.Lock("restored", "owner_2", time.Minute); !errors.Is(err, lock.ErrLocked) {
+		t.Errorf("want %+v; got %+v", lock.ErrLocked, err)
+	}
+
+	token, err := storage.Lock("released", "owner_2", time.Minute)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if token != 12 {
+		t.Errorf("want %+v; got %+v", 12, token)
+	}
+
+	if err = storage.Refresh("released", "owner_1", time.Minute); !errors.Is(err, lock.ErrNotOwner) {
+		t.Errorf("want %+v; got %+v", lock.ErrNotOwner, err)
+	}
+
+	if err = storage.Refresh("released", "owner_2", time.Minute); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err = storage.Unlock("released", "owner_2"); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+}
+
+func TestStorage_LeasesNotWritten(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	config := &common.WalConfig{BatchSize: 1, FlushingTimeout: "1ms", SegmentSize: "1KB", DirPath: t.TempDir()}
+	writeAheadLog, err := wal.NewWAL(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAheadLog.Start(context.Background())
+
+	storage, err := NewStorage(NewMockEngine(), writeAheadLog, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Lock("held", "owner", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Enqueue("jobs", "payload", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writeAheadLog.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes which could not be logged are not applied
+	if _, err = storage.Lock("free", "owner", time.Minute); !errors.Is(err, wal.ErrClosed) {
+		t.Errorf("want %+v; got %+v", wal.ErrClosed, err)
+	}
+
+	if err = storage.locks.Held("free", "owner"); !errors.Is(err, lock.ErrNotOwner) {
+		t.Errorf("want lock not held; got %+v", err)
+	}
+
+	if err = storage.Unlock("held", "owner"); !errors.Is(err, wal.ErrClosed) {
+		t.Errorf("want %+v; got %+v", wal.ErrClosed, err)
+	}
+
+	if err = storage.locks.Held("held", "owner"); err != nil {
+		t.Errorf("want lock still held; got %+v", err)
+	}
+
+	if _, err = storage.Dequeue("jobs", time.Minute); !errors.Is(err, wal.ErrClosed) {
+		t.Errorf("want %+v; got %+v", wal.ErrClosed, err)
+	}
+
+	if lease, err := storage.queues.Next("jobs", time.Minute); err != nil || lease.Job.Attempts != 1 {
+		t.Errorf("want job still visible; got %+v, %+v", lease, err)
+	}
+}
+
+func TestStorage_Throttle(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	storage, err := NewStorage(NewMockEngine(), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []throttle.Limit{{Count: 0, Period: time.Second}, {Count: 10, Period: 5 * time.Nanosecond}} {
+		if _, err = storage.Throttle("key", limit); !errors.Is(err, throttle.ErrInvalidLimit) {
+			t.Errorf("want %+v; got %+v", throttle.ErrInvalidLimit, err)
+		}
+	}
+
+	_, err = storage.Throttle("key", throttle.Limit{Count: 1, Period: time.Second})
+	if !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %+v; got %+v", ErrNotSupported, err)
+	}
+}
+
+func TestStorage_Queue(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	storage, err := NewStorage(NewMockEngine(), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaseUntil := strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10)
+	restore(storage,
+		wal.NewRequest(compute.EnqueueCommand, []string{"jobs", "1", "acked", "0", "5"}),
+		wal.NewRequest(compute.EnqueueCommand, []string{"jobs", "2", "leased", "0", "5"}),
+		wal.NewRequest(compute.EnqueueCommand, []string{"jobs", "3", "nacked", "0", "5"}),
+		wal.NewRequest(compute.DequeueCommand, []string{"jobs", "1", "1", leaseUntil}),
+		wal.NewRequest(compute.DequeueCommand, []string{"jobs", "2", "1", leaseUntil}),
+		wal.NewRequest(compute.DequeueCommand, []string{"jobs", "3", "1", leaseUntil}),
+		wal.NewRequest(compute.AckCommand, []string{"jobs", "1", "1"}),
+		wal.NewRequest(compute.NackCommand, []string{"jobs", "3", "1"}),
+	)
+
+	lease, err := storage.Dequeue("jobs", time.Minute)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	if lease.Job.Payload != "nacked" || lease.Receipt != "3.2" {
+		t.Errorf("want nacked job with receipt 3.2; got %+v", lease)
+	}
+
+	if _, err = storage.Dequeue("jobs", time.Minute); !errors.Is(err, queue.ErrEmpty) {
+		t.Errorf("want %+v; got %+v", queue.ErrEmpty, err)
+	}
+
+	if err = storage.Ack("jobs", "2.1"); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err = storage.Nack("jobs", "3.1"); !errors.Is(err, queue.ErrInvalidReceipt) {
+		t.Errorf("want %+v; got %+v", queue.ErrInvalidReceipt, err)
+	}
+
+	id, err := storage.Enqueue("jobs", "new", 0, 0)
+	if err != nil || id != 4 {
+		t.Errorf("want job 4; got %d, %+v", id, err)
+	}
+}
+
+// closingMockEngine - counts closes of the engine
+type closingMockEngine struct {
+	*MockEngine
+	closes int
+}
+
+func (e *closingMockEngine) Close() error {
+	e.closes++
+	return nil
+}
+
+// failingMockEngine - fails to write changes
+type failingMockEngine struct {
+	*MockEngine
+	err error
+}
+
+func (e *failingMockEngine) Set(string, string) error {
+	return e.err
+}
+
+func (e *failingMockEngine) Del(string) error {
+	return e.err
+}
+
+// orderedMockEngine - iterates over keys stored in the ascending order
+type orderedMockEngine struct {
+	*MockEngine
+	keys   []string
+	values map[string]string
+}
+
+func (e *orderedMockEngine) Iterator() Iterator {
+	return &sliceIterator{engine: e, position: -1}
+}
+
+type sliceIterator struct {
+	engine   *orderedMockEngine
+	position int
+}
+
+func (it *sliceIterator) Seek(key string) {
+	it.position = -1
+	for it.position+1 < len(it.engine.keys) && it.engine.keys[it.position+1] < key {
+		it.position++
+	}
+}
+
+func (it *sliceIterator) Next() bool {
+	it.position++
+	return it.position < len(it.engine.keys)
+}
+
+func (it *sliceIterator) Key() string {
+	return it.engine.keys[it.position]
+}
+
+func (it *sliceIterator) Value() string {
+	return it.engine.values[it.Key()]
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
+
+func TestStorage_RangePrefix(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine := &orderedMockEngine{
+		MockEngine: NewMockEngine(),
+		keys:       []string{"order:2023-12", "order:2024-01-05", "order:2024-01-20", "order:2024-02-01", "user:1"},
+		values:     map[string]string{"order:2024-01-05": "a", "order:2024-01-20": "b", "order:2024-02-01": "c", "user:1": "d"},
+	}
+
+	storage, err := NewStorage(engine, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		scan     func() ([]KeyValue, error)
+		expected []KeyValue
+	}{
+		{
+			name: "range",
+			scan: func() ([]KeyValue, error) { return storage.Range("order:2024-01", "order:2024-02", 0) },
+			expected: []KeyValue{
+				{Key: "order:2024-01-05", Value: "a"},
+				{Key: "order:2024-01-20", Value: "b"},
+			},
+		},
+		{
+			name:     "range with limit",
+			scan:     func() ([]KeyValue, error) { return storage.Range("order:2024-01", "", 1) },
+			expected: []KeyValue{{Key: "order:2024-01-05", Value: "a"}},
+		},
+		{
+			name:     "prefix",
+			scan:     func() ([]KeyValue, error) { return storage.Prefix("user:", 0) },
+			expected: []KeyValue{{Key: "user:1", Value: "d"}},
+		},
+		{
+			name: "empty prefix",
+			scan: func() ([]KeyValue, error) { return storage.Prefix("session:", 0) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.scan()
+			if err != nil || !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("want %+v; got %+v, %+v", tt.expected, result, err)
+			}
+		})
+	}
+}
+
+func TestStorage_KeysNotSupported(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	storage, err := NewStorage(NewMockEngine(), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Exists("key"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %+v; got %+v", ErrNotSupported, err)
+	}
+
+	if err = storage.Rename("key", "new_key"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %+v; got %+v", ErrNotSupported, err)
+	}
+
+	if _, err = storage.Expire("key", time.Minute); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %+v; got %+v", ErrNotSupported, err)
+	}
+
+	if _, err = storage.Size(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %+v; got %+v", ErrNotSupported, err)
+	}
+
+	if _, err = storage.Range("a", "b", 0); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %+v; got %+v", ErrNotSupported, err)
+	}
+
+	if _, err = storage.Snapshot(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("want %+v; got %+v", ErrNotSupported, err)
+	}
+}
@@ -0,0 +1,141 @@
+package throttle
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// GCRA - generic cell rate algorithm, a token bucket that refills continuously
+	GCRA = "GCRA"
+	// SlidingWindow - weighted count of requests in the current and the previous fixed windows
+	SlidingWindow = "WINDOW"
+)
+
+var ErrInvalidLimit = errors.New("throttle: invalid limit")
+
+// Limit - count requests per period, GCRA additionally allows bursts of max burst requests
+// above the rate, the sliding window does not use max burst
+type Limit struct {
+	Algorithm string
+	MaxBurst  int
+	Count     int
+	Period    time.Duration
+}
+
+// Result - outcome of a single request
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// State - rate limiter state of a single key
+type State struct {
+	// theoretical arrival time of the next request for GCRA
+	tat time.Time
+
+	windowStart time.Time
+	current     int
+	previous    int
+}
+
+// Validate checks the limit, the period must be at least a nanosecond per request
+func (l Limit) Validate() error {
+	if l.MaxBurst < 0 || l.Count <= 0 || l.Period <= 0 || l.Period/time.Duration(l.Count) == 0 {
+		return ErrInvalidLimit
+	}
+
+	switch l.Algorithm {
+	case "", GCRA, SlidingWindow:
+		return nil
+	}
+
+	return ErrInvalidLimit
+}
+
+// Take accounts a request made at the moment against the state
+func (l Limit) Take(state *State, now time.Time) Result {
+	if l.Algorithm == SlidingWindow {
+		return l.takeWindow(state, now)
+	}
+
+	return l.takeGCRA(state, now)
+}
+
+func (l Limit) takeGCRA(state *State, now time.Time) Result {
+	interval := l.Period / time.Duration(l.Count)
+	tolerance := interval * time.Duration(l.MaxBurst+1)
+
+	tat := state.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-tolerance)
+	if now.Before(allowAt) {
+		return Result{
+			Allowed:    false,
+			Remaining:  0,
+			RetryAfter: allowAt.Sub(now),
+		}
+	}
+
+	state.tat = newTat
+	return Result{
+		Allowed:   true,
+		Remaining: int(now.Sub(allowAt) / interval),
+	}
+}
+
+func (l Limit) takeWindow(state *State, now time.Time) Result {
+	start := now.Truncate(l.Period)
+	if !start.Equal(state.windowStart) {
+		if start.Sub(state.windowStart) == l.Period {
+			state.previous = state.current
+		} else {
+			state.previous = 0
+		}
+
+		state.current = 0
+		state.windowStart = start
+	}
+
+	weight := 1 - float64(now.Sub(start))/float64(l.Period)
+	estimated := float64(state.previous)*weight + float64(state.current)
+	if estimated+1 > float64(l.Count) {
+		return Result{
+			Allowed:    false,
+			Remaining:  0,
+			RetryAfter: l.windowRetryAfter(state, now),
+		}
+	}
+
+	state.current++
+	return Result{
+		Allowed:   true,
+		Remaining: int(float64(l.Count) - estimated - 1),
+	}
+}
+
+// windowRetryAfter returns the time left until the weighted count leaves room for one more request
+func (l Limit) windowRetryAfter(state *State, now time.Time) time.Duration {
+	end := state.windowStart.Add(l.Period)
+	if state.current+1 > l.Count || state.previous == 0 {
+		return end.Sub(now)
+	}
+
+	// previous * (1 - elapsed/period) + current + 1 <= count
+	elapsed := time.Duration(float64(l.Period) * (1 - float64(l.Count-state.current-1)/float64(state.previous)))
+	return state.windowStart.Add(elapsed).Sub(now)
+}
+
+// Idle reports whether the state no longer affects future requests and can be dropped
+func (l Limit) Idle(state *State, now time.Time) bool {
+	if l.Algorithm == SlidingWindow {
+		return !now.Before(state.windowStart.Add(2 * l.Period))
+	}
+
+	return !now.Before(state.tat)
+}
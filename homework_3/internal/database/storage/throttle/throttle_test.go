@@ -0,0 +1,117 @@
+package throttle
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimit_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       Limit
+		expectedErr error
+	}{
+		{
+			name:  "Valid default limit",
+			limit: Limit{MaxBurst: 0, Count: 10, Period: time.Second},
+		},
+		{
+			name:  "Valid sliding window limit",
+			limit: Limit{Algorithm: SlidingWindow, Count: 10, Period: time.Second},
+		},
+		{
+			name:        "Negative max burst",
+			limit:       Limit{MaxBurst: -1, Count: 10, Period: time.Second},
+			expectedErr: ErrInvalidLimit,
+		},
+		{
+			name:        "Zero period",
+			limit:       Limit{Count: 10},
+			expectedErr: ErrInvalidLimit,
+		},
+		{
+			name:        "Period shorter than a nanosecond per request",
+			limit:       Limit{Count: 10, Period: 5 * time.Nanosecond},
+			expectedErr: ErrInvalidLimit,
+		},
+		{
+			name:        "Unknown algorithm",
+			limit:       Limit{Algorithm: "LEAKY", Count: 10, Period: time.Second},
+			expectedErr: ErrInvalidLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limit.Validate()
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("want %+v; got %+v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestLimit_Take_GCRA(t *testing.T) {
+	limit := Limit{Algorithm: GCRA, MaxBurst: 2, Count: 1, Period: time.Second}
+	state := &State{}
+	now := time.Unix(100, 0)
+
+	for i, remaining := range []int{2, 1, 0} {
+		result := limit.Take(state, now)
+		if !result.Allowed || result.Remaining != remaining {
+			t.Errorf("request %d: want allowed with %d remaining; got %+v", i, remaining, result)
+		}
+	}
+
+	result := limit.Take(state, now)
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Errorf("want denied with retry after 1s; got %+v", result)
+	}
+
+	result = limit.Take(state, now.Add(time.Second))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("want allowed with 0 remaining; got %+v", result)
+	}
+
+	if limit.Idle(state, now.Add(3*time.Second)) {
+		t.Errorf("want state to be active before the bucket refills")
+	}
+
+	if !limit.Idle(state, now.Add(4*time.Second)) {
+		t.Errorf("want state to be idle after the bucket refills")
+	}
+}
+
+func TestLimit_Take_SlidingWindow(t *testing.T) {
+	limit := Limit{Algorithm: SlidingWindow, Count: 4, Period: time.Second}
+	state := &State{}
+	start := time.Unix(100, 0)
+
+	for i := 0; i < 4; i++ {
+		result := limit.Take(state, start.Add(500*time.Millisecond))
+		if !result.Allowed || result.Remaining != 3-i {
+			t.Errorf("request %d: want allowed with %d remaining; got %+v", i, 3-i, result)
+		}
+	}
+
+	result := limit.Take(state, start.Add(500*time.Millisecond))
+	if result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("want denied with retry after 500ms; got %+v", result)
+	}
+
+	// previous window weight is 0.75 so 4 * 0.75 = 3 requests are still accounted
+	result = limit.Take(state, start.Add(1250*time.Millisecond))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("want allowed with 0 remaining; got %+v", result)
+	}
+
+	result = limit.Take(state, start.Add(1250*time.Millisecond))
+	if result.Allowed || result.RetryAfter != 250*time.Millisecond {
+		t.Errorf("want denied with retry after 250ms; got %+v", result)
+	}
+
+	if !limit.Idle(state, start.Add(3*time.Second)) {
+		t.Errorf("want state to be idle after two windows")
+	}
+}
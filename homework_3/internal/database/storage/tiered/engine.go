@@ -0,0 +1,361 @@
+package tiered
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/bitcask"
+)
+
+const TieredEngine = "tiered"
+
+const (
+	defaultDirectory    = "data/tiered"
+	defaultMemoryBudget = 64 << 20
+
+	// spillDirectory - cold entries are only a spill of the memory, WAL restores all keys at startup
+	// so the directory is cleared
+	spillDirectory = "spill"
+	// entryOverhead - approximate memory taken by a hot entry besides the key and the value
+	entryOverhead = 96
+	statsInterval = time.Minute
+)
+
+type entry struct {
+	key   string
+	value string
+}
+
+// Stats - reads served by each tier
+type Stats struct {
+	HotHits  uint64
+	ColdHits uint64
+	Misses   uint64
+	HotKeys  int
+	ColdKeys int
+}
+
+// HitRatio returns the share of found keys served from memory
+func (s Stats) HitRatio() float64 {
+	if s.HotHits+s.ColdHits == 0 {
+		return 0
+	}
+
+	return float64(s.HotHits) / float64(s.HotHits+s.ColdHits)
+}
+
+// Engine - keeps recently used entries in memory within the budget, least recently used entries
+// are spilled to a bitcask store and promoted back when they are read
+type Engine struct {
+	logger *common.Logger
+	budget int
+
+	// m - guards both tiers, a key is stored in exactly one of them, I/O of the cold store runs outside of it
+	m       sync.Mutex
+	hot     map[string]*list.Element
+	lru     *list.List
+	used    int
+	spilled map[string]struct{}
+
+	// coldMutex - taken before m, serializes I/O of the cold store with applying its outcome so that keys
+	// are neither spilled nor promoted by others while their records are read or written
+	coldMutex sync.Mutex
+	cold      *bitcask.Engine
+
+	hotHits  atomic.Uint64
+	coldHits atomic.Uint64
+	misses   atomic.Uint64
+
+	// stop - closed by Close, the statistics logging ends and marks background done
+	stop       chan struct{}
+	background sync.WaitGroup
+}
+
+func NewEngine(directory string, memoryBudget, dataFileSize int, logger *common.Logger) (*Engine, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	if directory == "" {
+		directory = defaultDirectory
+	}
+
+	if memoryBudget <= 0 {
+		memoryBudget = defaultMemoryBudget
+	}
+
+	spill := filepath.Join(directory, spillDirectory)
+	if err := os.RemoveAll(spill); err != nil {
+		return nil, fmt.Errorf("failed to clear spill directory: %w", err)
+	}
+
+	cold, err := bitcask.NewEngine(spill, dataFileSize, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Engine{
+		logger:  logger,
+		budget:  memoryBudget,
+		hot:     make(map[string]*list.Element),
+		lru:     list.New(),
+		cold:    cold,
+		spilled: make(map[string]struct{}),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// Start launches background merges of the cold store and periodic logging of tier statistics
+func (e *Engine) Start() {
+	e.cold.Start()
+
+	e.background.Add(1)
+	go func() {
+		defer e.background.Done()
+
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-e.stop:
+				return
+			}
+
+			stats := e.Stats()
+			e.logger.Info("tiered engine stats [hot hits %d, cold hits %d, misses %d, hit ratio %.3f, hot keys %d, cold keys %d]",
+				stats.HotHits, stats.ColdHits, stats.Misses, stats.HitRatio(), stats.HotKeys, stats.ColdKeys)
+		}
+	}()
+}
+
+// Close stops the statistics logging and closes the cold store, it must be called once after the engine
+// is no longer used
+func (e *Engine) Close() error {
+	close(e.stop)
+	e.background.Wait()
+
+	return e.cold.Close()
+}
+
+func (e *Engine) Set(key, value string) error {
+	e.m.Lock()
+	_, spilled := e.spilled[key]
+	delete(e.spilled, key)
+	full := e.put(key, value)
+	e.m.Unlock()
+
+	if spilled || full {
+		e.coldMutex.Lock()
+		if spilled {
+			e.dropCold(key)
+		}
+		e.spill()
+		e.coldMutex.Unlock()
+	}
+
+	e.logger.Debug("successful SET query [key %s, value %s]", key, value)
+	return nil
+}
+
+// Get promotes a cold entry back to memory
+func (e *Engine) Get(key string) (string, error) {
+	value, promoted, err := e.lookup(key)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		e.logger.Debug("GET query [key %s]: key not found", key)
+		return "", err
+	case err != nil:
+		e.logger.Error("GET query [key %s]: %s", key, err)
+		return "", err
+	case promoted:
+		e.logger.Debug("successful GET query [key %s, value %s]: promoted from cold tier", key, value)
+	default:
+		e.logger.Debug("successful GET query [key %s, value %s]", key, value)
+	}
+
+	return value, nil
+}
+
+// lookup returns the value of the key and whether it has been promoted from the cold tier
+func (e *Engine) lookup(key string) (string, bool, error) {
+	e.m.Lock()
+	value, cold, err := e.lookupHot(key)
+	e.m.Unlock()
+	if !cold {
+		return value, false, err
+	}
+
+	e.coldMutex.Lock()
+	defer e.coldMutex.Unlock()
+
+	// the key may have been promoted, changed or deleted while the cold store was busy
+	e.m.Lock()
+	value, cold, err = e.lookupHot(key)
+	e.m.Unlock()
+	if !cold {
+		return value, false, err
+	}
+
+	value, err = e.cold.Get(key)
+	if err != nil {
+		return "", false, err
+	}
+
+	e.m.Lock()
+	if _, ok := e.spilled[key]; !ok {
+		// the key changed while its record was read
+		value, _, err = e.lookupHot(key)
+		e.m.Unlock()
+		return value, false, err
+	}
+
+	e.coldHits.Add(1)
+	delete(e.spilled, key)
+	e.put(key, value)
+	e.m.Unlock()
+
+	_ = e.cold.Del(key)
+	e.spill()
+	return value, true, nil
+}
+
+// lookupHot returns the value of the hot key, true means the key is cold, the lock must be held
+func (e *Engine) lookupHot(key string) (string, bool, error) {
+	if element, ok := e.hot[key]; ok {
+		e.lru.MoveToFront(element)
+		e.hotHits.Add(1)
+		return element.Value.(*entry).value, false, nil
+	}
+
+	if _, ok := e.spilled[key]; ok {
+		return "", true, nil
+	}
+
+	e.misses.Add(1)
+	return "", false, storage.ErrNotFound
+}
+
+func (e *Engine) Del(key string) error {
+	e.m.Lock()
+	if element, ok := e.hot[key]; ok {
+		e.removeHot(element)
+	}
+
+	_, spilled := e.spilled[key]
+	delete(e.spilled, key)
+	e.m.Unlock()
+
+	if spilled {
+		e.coldMutex.Lock()
+		e.dropCold(key)
+		e.coldMutex.Unlock()
+	}
+
+	e.logger.Debug("successful DEL query [key %s]", key)
+	return nil
+}
+
+// Stats returns counters of reads and the number of keys in each tier
+func (e *Engine) Stats() Stats {
+	e.m.Lock()
+	hotKeys, coldKeys := len(e.hot), len(e.spilled)
+	e.m.Unlock()
+
+	return Stats{
+		HotHits:  e.hotHits.Load(),
+		ColdHits: e.coldHits.Load(),
+		Misses:   e.misses.Load(),
+		HotKeys:  hotKeys,
+		ColdKeys: coldKeys,
+	}
+}
+
+// put stores the entry in memory as the most recently used one and reports whether entries are over
+// the budget, the lock must be held
+func (e *Engine) put(key, value string) bool {
+	if element, ok := e.hot[key]; ok {
+		current := element.Value.(*entry)
+		e.used += len(value) - len(current.value)
+		current.value = value
+		e.lru.MoveToFront(element)
+	} else {
+		e.hot[key] = e.lru.PushFront(&entry{key: key, value: value})
+		e.used += entrySize(key, value)
+	}
+
+	return e.overBudget()
+}
+
+// overBudget reports whether entries take more memory than the budget and there is an entry to spill,
+// the entry written last always stays, the lock must be held
+func (e *Engine) overBudget() bool {
+	return e.used > e.budget && e.lru.Len() > 1
+}
+
+// spill writes the least recently used entries over the budget to the cold store, entries read or
+// changed while they are written and entries which cannot be spilled stay in memory, coldMutex
+// must be held
+func (e *Engine) spill() {
+	for {
+		e.m.Lock()
+		if !e.overBudget() {
+			e.m.Unlock()
+			return
+		}
+
+		coldest := e.lru.Back()
+		spilled := *coldest.Value.(*entry)
+		e.m.Unlock()
+
+		if err := e.cold.Set(spilled.key, spilled.value); err != nil {
+			e.logger.Error("failed to spill key %s, memory budget is exceeded: %s", spilled.key, err)
+			return
+		}
+
+		e.m.Lock()
+		moved := e.lru.Back() == coldest && coldest.Value.(*entry).value == spilled.value
+		if moved {
+			e.spilled[spilled.key] = struct{}{}
+			e.removeHot(coldest)
+		}
+		e.m.Unlock()
+
+		if !moved {
+			_ = e.cold.Del(spilled.key)
+		}
+	}
+}
+
+// dropCold deletes the record of the key unless it has been spilled again, spilled keys decide which
+// entries are cold so a record left in the cold store when it fails to be deleted is never read,
+// coldMutex must be held
+func (e *Engine) dropCold(key string) {
+	e.m.Lock()
+	_, spilled := e.spilled[key]
+	e.m.Unlock()
+
+	if !spilled {
+		_ = e.cold.Del(key)
+	}
+}
+
+// removeHot drops the entry from memory, the lock must be held
+func (e *Engine) removeHot(element *list.Element) {
+	removed := e.lru.Remove(element).(*entry)
+	delete(e.hot, removed.key)
+	e.used -= entrySize(removed.key, removed.value)
+}
+
+func entrySize(key, value string) int {
+	return len(key) + len(value) + entryOverhead
+}
@@ -0,0 +1,222 @@
+package tiered
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+)
+
+func newTestEngine(t *testing.T, directory string, memoryBudget int) *Engine {
+	t.Helper()
+
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(directory, memoryBudget, 0, logger)
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	t.Cleanup(func() { _ = engine.Close() })
+	return engine
+}
+
+func TestNewEngine(t *testing.T) {
+	if _, err := NewEngine(t.TempDir(), 0, 0, nil); err == nil {
+		t.Errorf("want error without logger")
+	}
+
+	directory := t.TempDir()
+	stale := filepath.Join(directory, spillDirectory, "bitcask_42.data")
+	if err := os.MkdirAll(filepath.Dir(stale), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := newTestEngine(t, directory, 0)
+	if engine.budget != defaultMemoryBudget {
+		t.Errorf("want default memory budget; got %d", engine.budget)
+	}
+
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want spill directory cleared; got %+v", err)
+	}
+}
+
+func TestEngine_StartClose(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	engine, err := NewEngine(t.TempDir(), 0, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Start()
+
+	closed := make(chan error, 1)
+	go func() { closed <- engine.Close() }()
+
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Errorf("want %+v; got %+v", nil, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("want background statistics logging stopped by Close")
+	}
+}
+
+func TestEngine_SpillAndPromote(t *testing.T) {
+	// the budget holds three entries
+	engine := newTestEngine(t, t.TempDir(), 3*entrySize("key_0", "value_0"))
+
+	for i := 0; i < 5; i++ {
+		engine.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+
+	stats := engine.Stats()
+	if stats.HotKeys != 3 || stats.ColdKeys != 2 {
+		t.Fatalf("want 3 hot and 2 cold keys; got %+v", stats)
+	}
+
+	if _, ok := engine.spilled["key_0"]; !ok {
+		t.Errorf("want the least recently used key spilled")
+	}
+
+	// reading a cold key promotes it and spills the least recently used hot key
+	for i := 0; i < 5; i++ {
+		value, err := engine.Get(fmt.Sprintf("key_%d", i))
+		if err != nil || value != fmt.Sprintf("value_%d", i) {
+			t.Errorf("want value_%d; got %s, %+v", i, value, err)
+		}
+	}
+
+	if _, err := engine.Get("key_5"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("want %+v; got %+v", storage.ErrNotFound, err)
+	}
+
+	stats = engine.Stats()
+	if stats.HotHits != 0 || stats.ColdHits != 5 || stats.Misses != 1 || stats.HotKeys != 3 || stats.ColdKeys != 2 {
+		t.Errorf("want 5 cold hits and 1 miss; got %+v", stats)
+	}
+
+	if value, err := engine.Get("key_4"); err != nil || value != "value_4" {
+		t.Errorf("want value_4; got %s, %+v", value, err)
+	}
+
+	if ratio := engine.Stats().HitRatio(); ratio != 1.0/6 {
+		t.Errorf("want hit ratio 1/6; got %f", ratio)
+	}
+}
+
+func TestEngine_SetDelCold(t *testing.T) {
+	engine := newTestEngine(t, t.TempDir(), 2*entrySize("key_0", "value_0"))
+
+	for i := 0; i < 4; i++ {
+		engine.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+
+	// key_0 and key_1 are cold, overwriting and deleting them must not leave stale cold values
+	engine.Set("key_0", "new_value_0")
+	engine.Del("key_1")
+	engine.Del("key_3")
+
+	tests := map[string]string{
+		"key_0": "new_value_0",
+		"key_1": "",
+		"key_2": "value_2",
+		"key_3": "",
+	}
+
+	for key, expected := range tests {
+		value, err := engine.Get(key)
+		if expected == "" {
+			if !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("want %s not found; got %s, %+v", key, value, err)
+			}
+			continue
+		}
+
+		if err != nil || value != expected {
+			t.Errorf("want %s for %s; got %s, %+v", expected, key, value, err)
+		}
+	}
+
+	if engine.used > engine.budget {
+		t.Errorf("want memory within budget; got %d of %d", engine.used, engine.budget)
+	}
+}
+
+func TestEngine_HotWhileColdBusy(t *testing.T) {
+	engine := newTestEngine(t, t.TempDir(), 3*entrySize("key_0", "value_0"))
+	engine.Set("key_0", "value_0")
+
+	// the cold store is busy, hot keys are read and written without waiting for it
+	engine.coldMutex.Lock()
+	defer engine.coldMutex.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		engine.Set("key_1", "value_1")
+		_, err := engine.Get("key_0")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("want %+v; got %+v", nil, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("want hot keys not to wait for the cold store")
+	}
+}
+
+func TestEngine_Concurrent(t *testing.T) {
+	engine := newTestEngine(t, t.TempDir(), 4*entrySize("key_00", "value_0_00"))
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("key_%02d", i%20)
+				switch i % 3 {
+				case 0:
+					engine.Set(key, fmt.Sprintf("value_%d_%02d", worker, i%20))
+				case 1:
+					engine.Get(key)
+				case 2:
+					engine.Del(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every key is either gone or holds a value written for it, reads agree with each other
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key_%02d", i)
+		first, err := engine.Get(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+
+		second, _ := engine.Get(key)
+		if err != nil || first[len(first)-2:] != key[len(key)-2:] || first != second {
+			t.Errorf("want a value of %s; got %s, %s, %+v", key, first, second, err)
+		}
+	}
+
+	stats := engine.Stats()
+	if stats.HotKeys+stats.ColdKeys > 20 || engine.used > engine.budget {
+		t.Errorf("want tiers within the budget; got %+v, used %d", stats, engine.used)
+	}
+}
@@ -0,0 +1,259 @@
+package vector
+
+import (
+	"container/heap"
+	"errors"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// minChunkSize - a number of vectors below which splitting a search between workers is not worth it
+const minChunkSize = 1024
+
+var (
+	ErrIndexNotFound     = errors.New("vector: index not found")
+	ErrDimensionMismatch = errors.New("vector: dimension mismatch")
+	ErrInvalidLimit      = errors.New("vector: invalid results limit")
+)
+
+// Result - a single search hit
+type Result struct {
+	Key   string
+	Score float32
+}
+
+type entry struct {
+	key    string
+	vector []float32
+}
+
+// Index - a set of vectors of the same dimension
+type Index struct {
+	dimension int
+
+	mutex   sync.RWMutex
+	vectors map[string][]float32
+}
+
+// Store - vector indexes by name
+type Store struct {
+	workers int
+
+	mutex   sync.RWMutex
+	indexes map[string]*Index
+}
+
+func NewStore() *Store {
+	return &Store{
+		workers: runtime.GOMAXPROCS(0),
+		indexes: make(map[string]*Index),
+	}
+}
+
+// Validate checks that a vector of the dimension can be added to the index
+func (s *Store) Validate(index string, dimension int) error {
+	if dimension == 0 {
+		return ErrDimensionMismatch
+	}
+
+	s.mutex.RLock()
+	idx, ok := s.indexes[index]
+	s.mutex.RUnlock()
+
+	if ok && idx.dimension != dimension {
+		return ErrDimensionMismatch
+	}
+
+	return nil
+}
+
+// Add stores the vector by the key, the index is created with the vector dimension on the first call
+func (s *Store) Add(index, key string, vector []float32) error {
+	if len(vector) == 0 {
+		return ErrDimensionMismatch
+	}
+
+	s.mutex.Lock()
+	idx, ok := s.indexes[index]
+	if !ok {
+		idx = &Index{dimension: len(vector), vectors: make(map[string][]float32)}
+		s.indexes[index] = idx
+	}
+	s.mutex.Unlock()
+
+	if idx.dimension != len(vector) {
+		return ErrDimensionMismatch
+	}
+
+	stored := make([]float32, len(vector))
+	copy(stored, vector)
+
+	idx.mutex.Lock()
+	idx.vectors[key] = stored
+	idx.mutex.Unlock()
+
+	return nil
+}
+
+// Export returns copies of vectors by keys of every index
+func (s *Store) Export() map[string]map[string][]float32 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	exported := make(map[string]map[string][]float32, len(s.indexes))
+	for name, idx := range s.indexes {
+		idx.mutex.RLock()
+		vectors := make(map[string][]float32, len(idx.vectors))
+		for key, vector := range idx.vectors {
+			vectors[key] = append([]float32(nil), vector...)
+		}
+		idx.mutex.RUnlock()
+
+		exported[name] = vectors
+	}
+
+	return exported
+}
+
+// Search returns up to limit keys of the index closest to the query vector by the metric,
+// only keys starting with the prefix are considered
+func (s *Store) Search(index, metricName string, limit int, query []float32, prefix string) ([]Result, error) {
+	if limit <= 0 {
+		return nil, ErrInvalidLimit
+	}
+
+	m, err := parseMetric(metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	idx, ok := s.indexes[index]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+
+	if idx.dimension != len(query) {
+		return nil, ErrDimensionMismatch
+	}
+
+	entries := idx.entries(prefix)
+	chunks := s.split(entries)
+
+	partial := make([][]Result, len(chunks))
+	var wg sync.WaitGroup
+	wg.Add(len(chunks))
+	for i, chunk := range chunks {
+		go func(i int, chunk []entry) {
+			defer wg.Done()
+			partial[i] = topK(chunk, m, limit, query)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	var results []Result
+	for _, p := range partial {
+		results = append(results, p...)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return ranksBefore(m, results[i], results[j])
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func (s *Store) split(entries []entry) [][]entry {
+	workers := s.workers
+	if maxWorkers := len(entries) / minChunkSize; maxWorkers < workers {
+		workers = maxWorkers
+	}
+
+	if workers <= 1 {
+		return [][]entry{entries}
+	}
+
+	chunkSize := (len(entries) + workers - 1) / workers
+	chunks := make([][]entry, 0, workers)
+	for start := 0; start < len(entries); start += chunkSize {
+		end := min(start+chunkSize, len(entries))
+		chunks = append(chunks, entries[start:end])
+	}
+
+	return chunks
+}
+
+// entries returns a snapshot of the index, stored vectors are never modified in place so they can be shared
+func (i *Index) entries(prefix string) []entry {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	entries := make([]entry, 0, len(i.vectors))
+	for key, vector := range i.vectors {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, entry{key: key, vector: vector})
+		}
+	}
+
+	return entries
+}
+
+func topK(entries []entry, m metric, limit int, query []float32) []Result {
+	h := &resultsHeap{metric: m}
+	for _, e := range entries {
+		result := Result{Key: e.key, Score: m.score(query, e.vector)}
+		if h.Len() < limit {
+			heap.Push(h, result)
+		} else if ranksBefore(m, result, h.results[0]) {
+			h.results[0] = result
+			heap.Fix(h, 0)
+		}
+	}
+
+	return h.results
+}
+
+// ranksBefore reports whether a is a better hit than b, ties are broken by key to keep results stable
+func ranksBefore(m metric, a, b Result) bool {
+	if a.Score != b.Score {
+		return m.better(a.Score, b.Score)
+	}
+
+	return a.Key < b.Key
+}
+
+// resultsHeap - keeps the worst of the collected hits on top
+type resultsHeap struct {
+	metric  metric
+	results []Result
+}
+
+func (h *resultsHeap) Len() int {
+	return len(h.results)
+}
+
+func (h *resultsHeap) Less(i, j int) bool {
+	return ranksBefore(h.metric, h.results[j], h.results[i])
+}
+
+func (h *resultsHeap) Swap(i, j int) {
+	h.results[i], h.results[j] = h.results[j], h.results[i]
+}
+
+func (h *resultsHeap) Push(x any) {
+	h.results = append(h.results, x.(Result))
+}
+
+func (h *resultsHeap) Pop() any {
+	last := h.results[len(h.results)-1]
+	h.results = h.results[:len(h.results)-1]
+	return last
+}
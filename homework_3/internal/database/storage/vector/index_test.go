@@ -0,0 +1,147 @@
+package vector
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestStore_Add(t *testing.T) {
+	store := NewStore()
+
+	if err := store.Add("items", "item_1", []float32{1, 2}); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err := store.Add("items", "item_2", []float32{1, 2, 3}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("want %+v; got %+v", ErrDimensionMismatch, err)
+	}
+
+	if err := store.Validate("items", 3); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("want %+v; got %+v", ErrDimensionMismatch, err)
+	}
+
+	if err := store.Validate("other_items", 3); err != nil {
+		t.Errorf("want %+v; got %+v", nil, err)
+	}
+
+	if err := store.Add("items", "item_3", nil); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("want %+v; got %+v", ErrDimensionMismatch, err)
+	}
+}
+
+func TestStore_Search(t *testing.T) {
+	store := NewStore()
+	_ = store.Add("items", "user:1", []float32{1, 0})
+	_ = store.Add("items", "user:2", []float32{0, 1})
+	_ = store.Add("items", "user:3", []float32{1, 1})
+	_ = store.Add("items", "item:1", []float32{1, 0.1})
+
+	tests := []struct {
+		name        string
+		index       string
+		metric      string
+		limit       int
+		query       []float32
+		prefix      string
+		expected    []string
+		expectedErr error
+	}{
+		{
+			name:     "Cosine search",
+			index:    "items",
+			metric:   CosineMetric,
+			limit:    2,
+			query:    []float32{1, 0},
+			expected: []string{"user:1", "item:1"},
+		},
+		{
+			name:     "L2 search with prefix",
+			index:    "items",
+			metric:   L2Metric,
+			limit:    2,
+			query:    []float32{1, 0},
+			prefix:   "user:",
+			expected: []string{"user:1", "user:3"},
+		},
+		{
+			name:     "Dot search with limit larger than index",
+			index:    "items",
+			metric:   DotMetric,
+			limit:    10,
+			query:    []float32{0, 2},
+			expected: []string{"user:2", "user:3", "item:1", "user:1"},
+		},
+		{
+			name:        "Search in unknown index",
+			index:       "unknown",
+			metric:      DotMetric,
+			limit:       10,
+			query:       []float32{0, 2},
+			expectedErr: ErrIndexNotFound,
+		},
+		{
+			name:        "Search with wrong dimension",
+			index:       "items",
+			metric:      DotMetric,
+			limit:       10,
+			query:       []float32{0, 2, 3},
+			expectedErr: ErrDimensionMismatch,
+		},
+		{
+			name:        "Search with unknown metric",
+			index:       "items",
+			metric:      "HAMMING",
+			limit:       10,
+			query:       []float32{0, 2},
+			expectedErr: ErrUnknownMetric,
+		},
+		{
+			name:        "Search with invalid limit",
+			index:       "items",
+			metric:      DotMetric,
+			limit:       0,
+			query:       []float32{0, 2},
+			expectedErr: ErrInvalidLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.Search(tt.index, tt.metric, tt.limit, tt.query, tt.prefix)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("want %+v; got %+v", tt.expectedErr, err)
+			}
+
+			var keys []string
+			for _, result := range results {
+				keys = append(keys, result.Key)
+			}
+
+			if !reflect.DeepEqual(keys, tt.expected) {
+				t.Errorf("want %+v; got %+v", tt.expected, keys)
+			}
+		})
+	}
+}
+
+func TestStore_Search_Parallel(t *testing.T) {
+	store := NewStore()
+	store.workers = 4
+
+	const vectorsCount = 4 * minChunkSize
+	for i := 0; i < vectorsCount; i++ {
+		_ = store.Add("items", fmt.Sprintf("key_%d", i), []float32{float32(i), 1})
+	}
+
+	results, err := store.Search("items", L2Metric, 3, []float32{2000, 1}, "")
+	if err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	expected := []Result{{Key: "key_2000", Score: 0}, {Key: "key_1999", Score: 1}, {Key: "key_2001", Score: 1}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("want %+v; got %+v", expected, results)
+	}
+}
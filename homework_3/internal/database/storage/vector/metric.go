@@ -0,0 +1,76 @@
+package vector
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	CosineMetric = "COSINE"
+	L2Metric     = "L2"
+	DotMetric    = "DOT"
+)
+
+var ErrUnknownMetric = errors.New("vector: unknown metric")
+
+// metric - scoring function and the order in which its scores are ranked
+type metric struct {
+	score func(a, b []float32) float32
+	// better reports whether score a ranks higher than score b
+	better func(a, b float32) bool
+}
+
+func parseMetric(name string) (metric, error) {
+	switch name {
+	case CosineMetric:
+		return metric{score: cosine, better: greater}, nil
+	case L2Metric:
+		return metric{score: euclidean, better: less}, nil
+	case DotMetric:
+		return metric{score: dot, better: greater}, nil
+	}
+
+	return metric{}, ErrUnknownMetric
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
+
+func cosine(a, b []float32) float32 {
+	var product, normA, normB float64
+	for i := range a {
+		product += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return float32(product / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
+
+func euclidean(a, b []float32) float32 {
+	var sum float64
+	for i := range a {
+		diff := float64(a[i]) - float64(b[i])
+		sum += diff * diff
+	}
+
+	return float32(math.Sqrt(sum))
+}
+
+func greater(a, b float32) bool {
+	return a > b
+}
+
+func less(a, b float32) bool {
+	return a < b
+}
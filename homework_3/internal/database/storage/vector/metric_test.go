@@ -0,0 +1,77 @@
+package vector
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMetric(t *testing.T) {
+	tests := []struct {
+		name        string
+		metric      string
+		a           []float32
+		b           []float32
+		expected    float32
+		expectedErr error
+	}{
+		{
+			name:     "Cosine of orthogonal vectors",
+			metric:   CosineMetric,
+			a:        []float32{1, 0},
+			b:        []float32{0, 1},
+			expected: 0,
+		},
+		{
+			name:     "Cosine of collinear vectors",
+			metric:   CosineMetric,
+			a:        []float32{1, 1},
+			b:        []float32{2, 2},
+			expected: 1,
+		},
+		{
+			name:     "Cosine with zero vector",
+			metric:   CosineMetric,
+			a:        []float32{0, 0},
+			b:        []float32{2, 2},
+			expected: 0,
+		},
+		{
+			name:     "L2 distance",
+			metric:   L2Metric,
+			a:        []float32{0, 0},
+			b:        []float32{3, 4},
+			expected: 5,
+		},
+		{
+			name:     "Dot product",
+			metric:   DotMetric,
+			a:        []float32{1, 2, 3},
+			b:        []float32{4, 5, 6},
+			expected: 32,
+		},
+		{
+			name:        "Unknown metric",
+			metric:      "cosine",
+			expectedErr: ErrUnknownMetric,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseMetric(tt.metric)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("want %+v; got %+v", tt.expectedErr, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			score := m.score(tt.a, tt.b)
+			if math.Abs(float64(score-tt.expected)) > 1e-6 {
+				t.Errorf("want %+v; got %+v", tt.expected, score)
+			}
+		})
+	}
+}
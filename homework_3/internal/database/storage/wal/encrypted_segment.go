@@ -0,0 +1,204 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
+)
+
+// frameLengthSize - every encrypted batch is prefixed by its length
+const frameLengthSize = 4
+
+type headerSegment interface {
+	segment
+	SetHeader([]byte)
+}
+
+// encryptedSegment - encrypts every written batch with the active key of the keyring, segments start
+// with the header holding the key id so they stay readable after the key is rotated, the header
+// of the records format follows it unencrypted and both are authenticated with every batch
+type encryptedSegment struct {
+	headerSegment
+	keyring *encryption.Keyring
+	header  []byte
+	// plaintext - segments written before encryption was enabled are read, they are rejected otherwise
+	plaintext bool
+	logger    *common.Logger
+}
+
+func newEncryptedSegment(segment headerSegment, keyring *encryption.Keyring, plaintext bool, logger *common.Logger) *encryptedSegment {
+	header := keyring.Header()
+	segment.SetHeader(header)
+
+	return &encryptedSegment{headerSegment: segment, keyring: keyring, header: header, plaintext: plaintext, logger: logger}
+}
+
+// SetHeader sets the header written after the encryption header
+func (s *encryptedSegment) SetHeader(header []byte) {
+	s.header = append(s.keyring.Header(), header...)
+	s.headerSegment.SetHeader(s.header)
+}
+
+func (s *encryptedSegment) Write(data []byte) error {
+	sealed, err := s.keyring.Seal(s.keyring.Active(), data, s.header)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt batch: %w", err)
+	}
+
+	frame := make([]byte, frameLengthSize, frameLengthSize+len(sealed))
+	binary.LittleEndian.PutUint32(frame, uint32(len(sealed)))
+	return s.headerSegment.Write(append(frame, sealed...))
+}
+
+// Read returns the records format header and decrypted batches of the segment, segments written
+// before encryption was enabled are returned as they are if plaintext is allowed, a partially
+// written last batch is reported as a torn tail
+func (s *encryptedSegment) Read(id int64) ([]byte, error) {
+	data, err := s.headerSegment.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := encryption.ParseHeader(data); !ok {
+		if encryption.IsPartialHeader(data) {
+			return nil, &tornTailError{err: errors.New("partially written encryption header")}
+		}
+
+		// a segment with nothing written yet has no header
+		if isZero(data) {
+			return data, nil
+		}
+
+		if !s.plaintext {
+			s.logger.Error("WAL segment %d is not encrypted", id)
+			return nil, fmt.Errorf("segment %d: %w", id, encryption.ErrNotEncrypted)
+		}
+
+		s.logger.Info("WAL segment %d is not encrypted", id)
+		return data, nil
+	}
+
+	plain, _, err := s.decrypt(id, data)
+	return plain, err
+}
+
+// Truncate cuts the segment at the size of its decrypted data, the size must fall between batches
+func (s *encryptedSegment) Truncate(id int64, size int) (int64, error) {
+	offset, err := s.fileOffset(id, size)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.headerSegment.Truncate(id, offset)
+}
+
+// Reopen continues writing the segment after the batch ending at the size of its decrypted data,
+// segments encrypted with another key are not reopened
+func (s *encryptedSegment) Reopen(id int64, size int) (bool, error) {
+	segment, ok := s.headerSegment.(reopenSegment)
+	if !ok {
+		return false, nil
+	}
+
+	offset, err := s.fileOffset(id, size)
+	if err != nil {
+		return false, err
+	}
+
+	return segment.Reopen(id, offset)
+}
+
+// fileOffset returns the offset in the segment file of the batch end at the size of decrypted data,
+// the size must fall between batches
+func (s *encryptedSegment) fileOffset(id int64, size int) (int, error) {
+	data, err := s.headerSegment.Read(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := encryption.ParseHeader(data); !ok {
+		return size, nil
+	}
+
+	_, bounds, err := s.decrypt(id, data)
+	var torn *tornTailError
+	if err != nil && !errors.As(err, &torn) {
+		return 0, err
+	}
+
+	for _, bound := range bounds {
+		if bound.plain == size {
+			return bound.file, nil
+		}
+	}
+
+	return 0, fmt.Errorf("segment %d: offset %d is inside an authenticated batch", id, size)
+}
+
+// bound - end of a batch in the decrypted data and in the segment file
+type bound struct {
+	plain int
+	file  int
+}
+
+// decrypt returns the records format header followed by decrypted batches and ends of the header
+// and every batch, a last batch cut short is reported as a torn tail, other batches failing
+// authentication fail the read
+func (s *encryptedSegment) decrypt(id int64, data []byte) ([]byte, []bound, error) {
+	key, _ := encryption.ParseHeader(data)
+
+	// segments of gob records have no records format header
+	headerSize := encryption.HeaderSize
+	if hasSegmentHeader(data[headerSize:]) {
+		headerSize += segmentHeaderSize
+	}
+
+	header := data[:headerSize]
+	var buffer bytes.Buffer
+	buffer.Write(header[encryption.HeaderSize:])
+	bounds := []bound{{plain: buffer.Len(), file: headerSize}}
+	for offset := headerSize; offset < len(data); {
+		// preallocated space follows the last batch
+		if isZero(data[offset:]) {
+			break
+		}
+
+		torn := len(data)-offset < frameLengthSize
+		if !torn {
+			torn = len(data)-offset-frameLengthSize < int(binary.LittleEndian.Uint32(data[offset:]))
+		}
+
+		if torn {
+			return buffer.Bytes(), bounds, &tornTailError{size: buffer.Len(), err: fmt.Errorf("truncated batch at offset %d", offset)}
+		}
+
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		frame := data[offset+frameLengthSize : offset+frameLengthSize+length]
+		batch, err := s.keyring.Open(key, frame, header)
+		if err != nil && isTornFrame(frame, data[offset+frameLengthSize+length:]) {
+			return buffer.Bytes(), bounds, &tornTailError{size: buffer.Len(), err: fmt.Errorf("partially written batch at offset %d", offset)}
+		}
+
+		offset += frameLengthSize
+		if err != nil {
+			s.logger.Error("WAL segment %d cannot be decrypted at offset %d: %s", id, offset, err)
+			return nil, nil, fmt.Errorf("segment %d: %w", id, err)
+		}
+
+		buffer.Write(batch)
+		offset += length
+		bounds = append(bounds, bound{plain: buffer.Len(), file: offset})
+	}
+
+	return buffer.Bytes(), bounds, nil
+}
+
+// isTornFrame reports whether the batch failing authentication is a write cut short in preallocated space:
+// its unwritten end is left zeroed and only zeros follow it
+func isTornFrame(frame, rest []byte) bool {
+	return len(frame) != 0 && frame[len(frame)-1] == 0 && isZero(rest)
+}
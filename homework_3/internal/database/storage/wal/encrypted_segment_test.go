@@ -0,0 +1,163 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
+)
+
+func newTestEncryptedManager(t *testing.T, directory string, keys map[uint32][]byte, plaintext bool) *LogsManager {
+	t.Helper()
+
+	logger, _ := common.NewLogger("", "")
+	var segment segment = filesystem.NewSegment(directory, 1024)
+	if keys != nil {
+		keyring, err := encryption.NewKeyring(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		segment = newEncryptedSegment(segment.(headerSegment), keyring, plaintext, logger)
+	}
+
+	logsManager, err := NewLogsManager(segment, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sequence numbers continue after the recovered requests, some tests expect recovery errors
+	_, _ = replayLogs(logsManager)
+	return logsManager
+}
+
+func readCommands(t *testing.T, logsManager *LogsManager) ([]string, error) {
+	t.Helper()
+
+	requests, err := replayLogs(logsManager)
+	var commands []string
+	for _, request := range requests {
+		commands = append(commands, request.Command+" "+request.Arguments[0])
+	}
+
+	return commands, err
+}
+
+func TestEncryptedSegment_Rotation(t *testing.T) {
+	directory := t.TempDir()
+	first := map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)}
+	rotated := map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32), 2: bytes.Repeat([]byte{2}, 32)}
+
+	// segments written before encryption was enabled stay readable when plaintext is allowed
+	plain := newTestEncryptedManager(t, directory, nil, false)
+	plain.Write([]Request{NewRequest(compute.SetCommand, []string{"plain_key", "value"})})
+
+	logsManager := newTestEncryptedManager(t, directory, first, true)
+	logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"first_key", "secret_value"})})
+	logsManager.Write([]Request{NewRequest(compute.DelCommand, []string{"first_key"})})
+
+	logsManager = newTestEncryptedManager(t, directory, rotated, true)
+	logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"rotated_key", "secret_value"})})
+
+	commands, err := readCommands(t, logsManager)
+	expected := []string{"SET plain_key", "SET first_key", "DEL first_key", "SET rotated_key"}
+	if err != nil || !reflect.DeepEqual(commands, expected) {
+		t.Errorf("want %+v; got %+v, %+v", expected, commands, err)
+	}
+
+	if _, err = readCommands(t, newTestEncryptedManager(t, directory, rotated, false)); !errors.Is(err, encryption.ErrNotEncrypted) {
+		t.Errorf("want %+v; got %+v", encryption.ErrNotEncrypted, err)
+	}
+
+	segment := filesystem.NewSegment(directory, 1024)
+	ids, _ := segment.List()
+	for i, id := range ids[1:] {
+		data, _ := segment.Read(id)
+		if key, ok := encryption.ParseHeader(data); !ok || key != uint32(i+1) || bytes.Contains(data, []byte("secret_value")) {
+			t.Errorf("want segment %d encrypted with key %d; got %q", id, i+1, data)
+		}
+	}
+
+	// the key of the first encrypted segment is gone
+	if _, err = readCommands(t, newTestEncryptedManager(t, directory, map[uint32][]byte{2: rotated[2]}, true)); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("want %+v; got %+v", encryption.ErrUnknownKey, err)
+	}
+
+	if _, err = readCommands(t, newTestEncryptedManager(t, directory, nil, false)); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("want %+v without keyfile; got %+v", encryption.ErrUnknownKey, err)
+	}
+}
+
+func TestEncryptedSegment_Tampered(t *testing.T) {
+	directory := t.TempDir()
+	keys := map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)}
+
+	logsManager := newTestEncryptedManager(t, directory, keys, false)
+	logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key", "value"})})
+
+	segment := filesystem.NewSegment(directory, 1024)
+	ids, _ := segment.List()
+	path := directory + "/wal_" + strconv.FormatInt(ids[0], 10) + ".log"
+	data, _ := os.ReadFile(path)
+
+	if _, err := replayLogs(newTestEncryptedManager(t, directory, keys, false)); err != nil {
+		t.Fatalf("want %+v; got %+v", nil, err)
+	}
+
+	// a partially written batch is a torn tail, a modified one fails authentication
+	if err := os.WriteFile(path, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, err := replayLogs(newTestEncryptedManager(t, directory, keys, false))
+	if info, _ := os.Stat(path); err != nil || len(requests) != 0 || info.Size() != int64(encryption.HeaderSize+segmentHeaderSize) {
+		t.Errorf("want torn batch truncated; got %d requests, %+v", len(requests), err)
+	}
+
+	// the modified last byte is not zero so that it cannot be an unwritten end of the batch
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 0xff
+	if tampered[len(tampered)-1] == 0 {
+		tampered[len(tampered)-1] = 1
+	}
+
+	if err = os.WriteFile(path, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = replayLogs(newTestEncryptedManager(t, directory, keys, false)); !errors.Is(err, encryption.ErrAuthentication) {
+		t.Errorf("want %+v; got %+v", encryption.ErrAuthentication, err)
+	}
+
+	// a batch cut short in preallocated space ends with zeros followed by zeros, it is a torn tail
+	torn := bytes.Clone(data)
+	clear(torn[len(torn)-8:])
+	if err = os.WriteFile(path, append(torn, make([]byte, 64)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, err = replayLogs(newTestEncryptedManager(t, directory, keys, false))
+	if info, _ := os.Stat(path); err != nil || len(requests) != 0 || info.Size() != int64(encryption.HeaderSize+segmentHeaderSize) {
+		t.Errorf("want batch cut short in preallocated space truncated; got %d requests, %+v", len(requests), err)
+	}
+
+	// zeros of preallocated space following a modified batch do not make it a torn tail
+	if err = os.WriteFile(path, append(tampered, make([]byte, 64)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = replayLogs(newTestEncryptedManager(t, directory, keys, false)); !errors.Is(err, encryption.ErrAuthentication) {
+		t.Errorf("want %+v; got %+v", encryption.ErrAuthentication, err)
+	}
+
+	if info, _ := os.Stat(path); info.Size() != int64(len(tampered)+64) {
+		t.Errorf("want tampered segment kept; got size %d", info.Size())
+	}
+}
@@ -0,0 +1,129 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
+)
+
+const (
+	// segmentMagic - cannot start gob encoded data, so segments without the header are read as gob
+	segmentMagic      = "\xffWAL"
+	segmentVersion    = 1
+	segmentHeaderSize = len(segmentMagic) + 2
+)
+
+var ErrCorrupted = errors.New("wal: record is corrupted")
+
+// commands - logged commands by their opcodes, opcodes are stored in segments and must never change
+var commands = [...]string{
+	1:  compute.SetCommand,
+	2:  compute.DelCommand,
+	3:  compute.RenameCommand,
+	4:  compute.RenameNXCommand,
+	5:  compute.CopyCommand,
+	6:  compute.ExpireCommand,
+	7:  compute.EvictCommand,
+	8:  compute.VAddCommand,
+	9:  compute.LockCommand,
+	10: compute.RefreshCommand,
+	11: compute.UnlockCommand,
+	12: compute.EnqueueCommand,
+	13: compute.DequeueCommand,
+	14: compute.AckCommand,
+	15: compute.NackCommand,
+}
+
+var opcodes = func() map[string]byte {
+	opcodes := make(map[string]byte, len(commands))
+	for opcode, command := range commands {
+		if command != "" {
+			opcodes[command] = byte(opcode)
+		}
+	}
+
+	return opcodes
+}()
+
+func segmentHeader() []byte {
+	return binary.LittleEndian.AppendUint16([]byte(segmentMagic), segmentVersion)
+}
+
+// parseSegmentHeader returns the data following the header, false means the segment is written as gob
+func parseSegmentHeader(data []byte) ([]byte, bool, error) {
+	if !hasSegmentHeader(data) {
+		return data, false, nil
+	}
+
+	if version := binary.LittleEndian.Uint16(data[len(segmentMagic):]); version != segmentVersion {
+		return nil, false, fmt.Errorf("segment version %d is not supported", version)
+	}
+
+	return data[segmentHeaderSize:], true, nil
+}
+
+func hasSegmentHeader(data []byte) bool {
+	return len(data) >= segmentHeaderSize && string(data[:len(segmentMagic)]) == segmentMagic
+}
+
+// isPartialSegmentHeader reports whether the data is a beginning of the segment header cut short
+func isPartialSegmentHeader(data []byte) bool {
+	if len(data) == 0 || len(data) >= segmentHeaderSize {
+		return false
+	}
+
+	return strings.HasPrefix(segmentMagic, string(data[:min(len(data), len(segmentMagic))]))
+}
+
+// tornTailError - the segment ends with a partially written record, data from the size on is damaged
+type tornTailError struct {
+	size int
+	err  error
+}
+
+func (e *tornTailError) Error() string {
+	return fmt.Sprintf("torn tail at offset %d: %s", e.size, e.err)
+}
+
+func (e *tornTailError) Unwrap() error {
+	return e.err
+}
+
+// isTornTail reports whether the damaged data starting with a record which failed to decode can be
+// a partial write: no valid record follows it. The length of the damaged record cannot be trusted,
+// so the data after its start is scanned for a record, preallocated zeros after the last one are skipped
+func isTornTail(data []byte, versioned bool, err error) bool {
+	if !versioned {
+		return errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	end := len(data)
+	for end > 0 && data[end-1] == 0 {
+		end--
+	}
+
+	for offset := 1; offset < end && len(data)-offset >= recordHeaderSize+minPayloadSize; offset++ {
+		if new(Request).Decode(bytes.NewBuffer(data[offset:])) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isZero reports whether the data holds only zeros, preallocated space of segments after the last
+// record is zeroed
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -4,18 +4,45 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
 )
 
+// progressInterval - how often progress of a long recovery is logged
+const progressInterval = 5 * time.Second
+
 type segment interface {
 	Write([]byte) error
-	ReadAll() ([][]byte, error)
+	List() ([]int64, error)
+	Read(int64) ([]byte, error)
+	Rotate() (int64, error)
+	Remove(int64) error
+	Truncate(int64, int) (int64, error)
+	Sync() error
+	Close() error
+}
+
+// reopenSegment - segments continuing the last segment after restart instead of starting a new one
+type reopenSegment interface {
+	Reopen(int64, int) (bool, error)
 }
 
 type LogsManager struct {
 	segment segment
 	logger  *common.Logger
+	// syncBatches - batches are synced before they are acknowledged unless all their requests are async,
+	// batches with requests demanding sync are synced anyway
+	syncBatches bool
+
+	// m - guards sequence numbers of the last record and of the last one synced to disk, segments must
+	// be read before the first write to continue the sequence
+	m       sync.Mutex
+	lsn     uint64
+	durable uint64
 }
 
 func NewLogsManager(segment segment, logger *common.Logger) (*LogsManager, error) {
@@ -27,60 +54,326 @@ func NewLogsManager(segment segment, logger *common.Logger) (*LogsManager, error
 		return nil, errors.New("logger is invalid")
 	}
 
-	return &LogsManager{segment: segment, logger: logger}, nil
+	if segment, ok := segment.(headerSegment); ok {
+		segment.SetHeader(segmentHeader())
+	}
+
+	return &LogsManager{segment: segment, logger: logger, syncBatches: true}, nil
 }
 
+// Write assigns sequence numbers to the requests and writes them in a single batch
 func (l *LogsManager) Write(requests []Request) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
 	var buffer bytes.Buffer
-	for _, req := range requests {
-		if err := req.Encode(&buffer); err != nil {
+	for i := range requests {
+		requests[i].lsn = l.lsn + uint64(i) + 1
+		if err := requests[i].Encode(&buffer); err != nil {
 			l.acknowledge(requests, err)
 			return
 		}
 	}
 
+	synced := l.syncRequired(requests)
 	err := l.segment.Write(buffer.Bytes())
+	if err == nil && synced {
+		err = l.segment.Sync()
+	}
+
 	if err != nil {
 		l.logger.Error("failed to write request data: %s", err)
+	} else {
+		l.lsn += uint64(len(requests))
+		if synced {
+			l.durable = l.lsn
+		}
 	}
 
 	l.acknowledge(requests, err)
 }
 
-func (l *LogsManager) Read() ([]Request, error) {
-	segmentsData, err := l.segment.ReadAll()
+// Replay passes requests of all segments to apply in their order reading one segment at a time and
+// remembers the sequence number of the last one, requests of gob segments get sequence numbers following
+// the previous request. A partially written tail of the last segment is truncated, damaged data anywhere
+// else fails the replay after requests before it have been applied
+func (l *LogsManager) Replay(apply func(Request)) error {
+	ids, err := l.segment.List()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read segments: %w", err)
+	}
+
+	var lsn uint64
+	var replayed, end int
+	logged := time.Now()
+	for i, id := range ids {
+		data, err := l.segment.Read(id)
+		var torn *tornTailError
+		if err != nil && !errors.As(err, &torn) {
+			return fmt.Errorf("failed to read segments: %w", err)
+		}
+
+		var requests []Request
+		requests, end, err = l.readSegment(nil, id, data)
+		if err != nil && (torn != nil || !errors.As(err, &torn)) {
+			return fmt.Errorf("failed to read segments: %w", err)
+		}
+
+		if torn != nil {
+			if i != len(ids)-1 {
+				return fmt.Errorf("failed to read segments: segment %d is damaged in the middle of the log: %w", id, torn)
+			}
+
+			if err = l.discardTail(id, torn); err != nil {
+				return fmt.Errorf("failed to read segments: %w", err)
+			}
+			end = torn.size
+		}
+
+		for _, request := range requests {
+			if request.lsn == 0 {
+				request.lsn = lsn + 1
+			}
+
+			if request.lsn <= lsn {
+				return fmt.Errorf("failed to read segments: segment %d: sequence number %d follows %d", id, request.lsn, lsn)
+			}
+			lsn = request.lsn
+
+			apply(request)
+		}
+
+		replayed += len(requests)
+		if time.Since(logged) >= progressInterval {
+			l.logger.Info("WAL recovery: %d of %d segments, %d requests have been replayed", i+1, len(ids), replayed)
+			logged = time.Now()
+		}
+	}
+
+	l.m.Lock()
+	l.lsn = max(l.lsn, lsn)
+	l.durable = max(l.durable, lsn)
+	l.m.Unlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	l.logger.Info("WAL has been recovered: %d requests of %d segments", replayed, len(ids))
+	return l.reopen(ids[len(ids)-1], end)
+}
+
+// reopen continues writing the last segment after its last record
+func (l *LogsManager) reopen(id int64, end int) error {
+	segment, ok := l.segment.(reopenSegment)
+	if !ok {
+		return nil
+	}
+
+	reopened, err := segment.Reopen(id, end)
+	if err != nil {
+		return fmt.Errorf("failed to reopen segment %d: %w", id, err)
+	}
+
+	if reopened {
+		l.logger.Info("WAL segment %d has been reopened at offset %d", id, end)
+	}
+
+	return nil
+}
+
+// LastAppended returns the sequence number of the last written or read request
+func (l *LogsManager) LastAppended() uint64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.lsn
+}
+
+// LastDurable returns the sequence number of the last request synced to disk, requests up to it
+// survive a crash
+func (l *LogsManager) LastDurable() uint64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.durable
+}
+
+// synced moves the durable sequence number to the request written before the sync
+func (l *LogsManager) synced(lsn uint64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.durable = max(l.durable, lsn)
+}
+
+// Advance continues the sequence after the number if it is behind, requests up to it may be
+// removed from segments
+func (l *LogsManager) Advance(lsn uint64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.lsn = max(l.lsn, lsn)
+	l.durable = max(l.durable, lsn)
+}
+
+// discardTail truncates the torn tail of the segment and reports what has been lost
+func (l *LogsManager) discardTail(id int64, torn *tornTailError) error {
+	discarded, err := l.segment.Truncate(id, torn.size)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read segments: %w", err)
+		return fmt.Errorf("failed to truncate torn tail of segment %d: %w", id, err)
 	}
 
-	var requests []Request
-	for _, data := range segmentsData {
-		requests, err = l.readSegment(requests, data)
+	l.logger.Error("WAL segment %d has a torn tail, %d bytes have been discarded after offset %d: %s", id, discarded, torn.size, torn.err)
+	return nil
+}
+
+// Sync flushes written requests to disk
+func (l *LogsManager) Sync() error {
+	lsn := l.LastAppended()
+	if err := l.segment.Sync(); err != nil {
+		return err
+	}
+
+	l.synced(lsn)
+	return nil
+}
+
+// Close syncs and closes the current segment
+func (l *LogsManager) Close() error {
+	lsn := l.LastAppended()
+	if err := l.segment.Close(); err != nil {
+		return err
+	}
+
+	l.synced(lsn)
+	return nil
+}
+
+// Rotate starts a new segment and returns its id, later requests are written to it or to newer segments
+func (l *LogsManager) Rotate() (int64, error) {
+	return l.segment.Rotate()
+}
+
+// Prune removes segments older than the segment with the given id if all their requests are covered
+func (l *LogsManager) Prune(before int64, covered func(Request) bool) error {
+	ids, err := l.segment.List()
+	if err != nil {
+		return fmt.Errorf("failed to prune segments: %w", err)
+	}
+
+	for _, id := range ids {
+		if id >= before {
+			break
+		}
+
+		data, err := l.segment.Read(id)
+		if err != nil {
+			return fmt.Errorf("failed to prune segments: %w", err)
+		}
+
+		requests, _, err := l.readSegment(nil, id, data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read segments: %w", err)
+			return fmt.Errorf("failed to prune segments: %w", err)
+		}
+
+		if !allCovered(requests, covered) {
+			continue
+		}
+
+		if err = l.segment.Remove(id); err != nil {
+			return fmt.Errorf("failed to prune segments: %w", err)
+		}
+
+		l.logger.Debug("WAL segment %d has been pruned", id)
+	}
+
+	return nil
+}
+
+// syncRequired reports whether the batch must be synced before its requests are acknowledged
+func (l *LogsManager) syncRequired(requests []Request) bool {
+	for _, request := range requests {
+		if request.sync || l.syncBatches && !request.async {
+			return true
 		}
 	}
 
-	return requests, nil
+	return false
 }
 
+func allCovered(requests []Request, covered func(Request) bool) bool {
+	for _, request := range requests {
+		if !covered(request) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// acknowledge applies written requests in their order and sends every request its status
 func (l *LogsManager) acknowledge(requests []Request, err error) {
 	for _, req := range requests {
+		if err == nil && req.apply != nil {
+			req.apply()
+		}
+
+		if err == nil && req.logged != nil {
+			*req.logged = req.lsn
+		}
+
 		req.doneStatus <- err
 		close(req.doneStatus)
 	}
 }
 
-func (l *LogsManager) readSegment(requests []Request, data []byte) ([]Request, error) {
-	buffer := bytes.NewBuffer(data)
+// readSegment appends requests of the segment and returns the end of the last one, a damaged record
+// which can be a partial write is reported as a torn tail along with requests before it
+func (l *LogsManager) readSegment(requests []Request, id int64, data []byte) ([]Request, int, error) {
+	if key, ok := encryption.ParseHeader(data); ok {
+		return nil, 0, fmt.Errorf("segment %d is encrypted with key %d, keyfile is not configured: %w", id, key, encryption.ErrUnknownKey)
+	}
+
+	// a segment preallocated before its header was written
+	if isZero(data) {
+		return requests, 0, nil
+	}
+
+	if isPartialSegmentHeader(data) || encryption.IsPartialHeader(data) {
+		return requests, 0, &tornTailError{err: errors.New("partially written segment header")}
+	}
+
+	records, versioned, err := parseSegmentHeader(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("segment %d: %w", id, err)
+	}
+
+	buffer := bytes.NewBuffer(records)
 	for buffer.Len() > 0 {
-		var request Request
-		if err := request.Decode(buffer); err != nil {
-			return nil, fmt.Errorf("failed to parse logs data: %w", err)
+		// preallocated space follows the last record
+		if versioned && isZero(buffer.Bytes()) {
+			break
+		}
+
+		offset := len(data) - buffer.Len()
+		request := Request{segment: id}
+		if versioned {
+			err = request.Decode(buffer)
+		} else {
+			err = request.decodeGob(buffer)
+		}
+
+		if err != nil && isTornTail(data[offset:], versioned, err) {
+			return requests, offset, &tornTailError{size: offset, err: err}
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse logs data: segment %d, offset %d: %w", id, offset, err)
 		}
 
 		requests = append(requests, request)
 	}
 
-	return requests, nil
+	return requests, len(data) - buffer.Len(), nil
 }
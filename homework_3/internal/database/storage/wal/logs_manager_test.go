@@ -1,14 +1,28 @@
 package wal
 
 import (
+	"bytes"
 	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
 )
 
+// replayLogs collects requests replayed by the logs manager, requests before damaged data are
+// returned with the error
+func replayLogs(logsManager *LogsManager) ([]Request, error) {
+	var requests []Request
+	err := logsManager.Replay(func(request Request) { requests = append(requests, request) })
+	return requests, err
+}
+
 func TestNewLogsManager(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -101,6 +115,69 @@ func TestLogsManager_Write(t *testing.T) {
 	}
 }
 
+func TestLogsManager_WriteSync(t *testing.T) {
+	tests := []struct {
+		name          string
+		syncBatches   bool
+		async         []bool
+		sync          []bool
+		expectedSyncs int
+		// expectedDurable - the last durable sequence number before the next periodic sync
+		expectedDurable uint64
+	}{
+		{name: "Sync mode", syncBatches: true, async: []bool{false, false}, expectedSyncs: 1, expectedDurable: 2},
+		{name: "Sync mode with an async request", syncBatches: true, async: []bool{true, false}, expectedSyncs: 1, expectedDurable: 2},
+		{name: "Sync mode with async requests", syncBatches: true, async: []bool{true, true}, expectedSyncs: 0},
+		{name: "Periodic sync mode", syncBatches: false, async: []bool{false, false}, expectedSyncs: 0},
+		{name: "Periodic sync mode with a synced request", syncBatches: false, async: []bool{false, false}, sync: []bool{false, true}, expectedSyncs: 1, expectedDurable: 2},
+		{name: "Sync mode with async and synced requests", syncBatches: true, async: []bool{true, false}, sync: []bool{false, true}, expectedSyncs: 1, expectedDurable: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lsn uint64
+			requests := []Request{MockRequest1, MockRequest2}
+			for i := range requests {
+				requests[i].doneStatus = make(chan error, 1)
+				requests[i].async = test.async[i]
+				requests[i].sync = test.sync != nil && test.sync[i]
+			}
+			requests[1].logged = &lsn
+
+			segment := NewMockWalSegment(false)
+			logger, _ := common.NewLogger("", "")
+			logsManager, err := NewLogsManager(segment, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logsManager.syncBatches = test.syncBatches
+
+			logsManager.Write(requests)
+			for _, request := range requests {
+				if err = <-request.doneStatus; err != nil {
+					t.Errorf("want %+v; got %+v", nil, err)
+				}
+			}
+
+			if segment.Syncs != test.expectedSyncs {
+				t.Errorf("want %+v syncs; got %+v", test.expectedSyncs, segment.Syncs)
+			}
+
+			if lsn != 2 || logsManager.LastAppended() != 2 {
+				t.Errorf("want sequence number %+v; got %+v, %+v", 2, lsn, logsManager.LastAppended())
+			}
+
+			if logsManager.LastDurable() != test.expectedDurable {
+				t.Errorf("want durable %+v; got %+v", test.expectedDurable, logsManager.LastDurable())
+			}
+
+			if err = logsManager.Sync(); err != nil || logsManager.LastDurable() != 2 {
+				t.Errorf("want durable %+v after sync; got %+v, %+v", 2, logsManager.LastDurable(), err)
+			}
+		})
+	}
+}
+
 func TestLogsManager_Write_WithSegmentError(t *testing.T) {
 
 	MockRequest1.doneStatus = make(chan error, 1)
@@ -148,7 +225,7 @@ func TestLogsManager_Read(t *testing.T) {
 		t.Errorf("logs manager creation issue: %s", err)
 	}
 
-	requests, err := logsManager.Read()
+	requests, err := replayLogs(logsManager)
 	if err != nil {
 		t.Errorf("logs manager read issue: %s", err)
 	}
@@ -163,6 +240,10 @@ func TestLogsManager_Read(t *testing.T) {
 		MockRequest1,
 		MockRequest2,
 	}
+	for i := range expectedRequests {
+		expectedRequests[i].segment = MockSegmentID
+		expectedRequests[i].lsn = uint64(i + 1)
+	}
 	if !reflect.DeepEqual(requests, expectedRequests) {
 		t.Errorf("logs manager read issue: got %+v requests, expected %+v", requests, expectedRequests)
 	}
@@ -180,7 +261,7 @@ func TestLogsManager_Read_WithSegmentError(t *testing.T) {
 		t.Errorf("logs manager creation issue: %s", err)
 	}
 
-	requests, err := logsManager.Read()
+	requests, err := replayLogs(logsManager)
 	if err == nil || err.Error() != TestReadAllSegmentError {
 		t.Errorf("logs manager read issue: expected error %s, got %s", TestReadAllSegmentError, err)
 	}
@@ -189,3 +270,376 @@ func TestLogsManager_Read_WithSegmentError(t *testing.T) {
 		t.Errorf("logs manager read issue: expected 0 requests, got %d", len(requests))
 	}
 }
+
+func TestLogsManager_Replay(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	directory := t.TempDir()
+	logsManager, err := NewLogsManager(filesystem.NewSegment(directory, 1024), logger)
+	if err != nil {
+		t.Fatalf("logs manager creation issue: %s", err)
+	}
+
+	var ids []int64
+	for _, key := range []string{"1", "2", "3"} {
+		logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{key, "value"})})
+		id, _ := logsManager.Rotate()
+		ids = append(ids, id)
+	}
+
+	// requests are applied as segments are read, damage in the middle of the log stops the replay
+	path := filepath.Join(directory, "wal_"+strconv.FormatInt(ids[0], 10)+".log")
+	data, _ := os.ReadFile(path)
+	if err = os.WriteFile(path, flip(data, len(data)-1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	err = logsManager.Replay(func(request Request) {
+		keys = append(keys, request.Arguments[0])
+	})
+
+	if err == nil || !reflect.DeepEqual(keys, []string{"1"}) {
+		t.Errorf("want %+v applied before error; got %+v, %+v", []string{"1"}, keys, err)
+	}
+}
+
+func TestLogsManager_Prune(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	logsManager, err := NewLogsManager(filesystem.NewSegment(t.TempDir(), 1024), logger)
+	if err != nil {
+		t.Fatalf("logs manager creation issue: %s", err)
+	}
+
+	write := func(command string, args ...string) {
+		logsManager.Write([]Request{NewRequest(command, args)})
+	}
+
+	write(compute.SetCommand, "key", "value")
+	if _, err = logsManager.Rotate(); err != nil {
+		t.Fatalf("logs manager rotate issue: %s", err)
+	}
+
+	write(compute.DelCommand, "key")
+	write(compute.VAddCommand, "index", "key", "1")
+	before, err := logsManager.Rotate()
+	if err != nil {
+		t.Fatalf("logs manager rotate issue: %s", err)
+	}
+
+	write(compute.SetCommand, "key", "value")
+
+	err = logsManager.Prune(before, func(request Request) bool {
+		return request.Command == compute.SetCommand || request.Command == compute.DelCommand
+	})
+	if err != nil {
+		t.Fatalf("logs manager prune issue: %s", err)
+	}
+
+	requests, err := replayLogs(logsManager)
+	if err != nil {
+		t.Fatalf("logs manager read issue: %s", err)
+	}
+
+	var commands []string
+	for _, request := range requests {
+		commands = append(commands, request.Command)
+	}
+
+	expected := []string{compute.DelCommand, compute.VAddCommand, compute.SetCommand}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("want segments with uncovered requests kept %+v; got %+v", expected, commands)
+	}
+
+	if requests[2].Segment() != before {
+		t.Errorf("want request read from segment %d; got %d", before, requests[2].Segment())
+	}
+}
+
+func TestLogsManager_Preallocated(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	keyring, _ := encryption.NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+
+	tests := map[string]*encryption.Keyring{
+		"Plain":     nil,
+		"Encrypted": keyring,
+	}
+
+	for name, keyring := range tests {
+		t.Run(name, func(t *testing.T) {
+			directory := t.TempDir()
+			newManager := func() *LogsManager {
+				fileSegment := filesystem.NewSegment(directory, 1024)
+				fileSegment.SetPreallocation(true)
+
+				var segment segment = fileSegment
+				if keyring != nil {
+					segment = newEncryptedSegment(fileSegment, keyring, false, logger)
+				}
+
+				logsManager, _ := NewLogsManager(segment, logger)
+				return logsManager
+			}
+
+			logsManager := newManager()
+			logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key", "1"})})
+			before, _ := logsManager.Rotate()
+			logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key", "2"})})
+
+			// the removed segment is reused by the next one
+			if err := logsManager.Prune(before, func(Request) bool { return true }); err != nil {
+				t.Fatalf("want %+v; got %+v", nil, err)
+			}
+
+			if _, err := logsManager.Rotate(); err != nil {
+				t.Fatalf("want %+v; got %+v", nil, err)
+			}
+
+			logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key", "3"})})
+			_ = logsManager.Close()
+
+			// zeros after the last record are not damage
+			var values []string
+			requests, err := replayLogs(newManager())
+			for _, request := range requests {
+				values = append(values, request.Arguments[1])
+			}
+
+			if err != nil || !reflect.DeepEqual(values, []string{"2", "3"}) {
+				t.Errorf("want %+v; got %+v, %+v", []string{"2", "3"}, values, err)
+			}
+		})
+	}
+}
+
+func TestLogsManager_Reopen(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	keyring, _ := encryption.NewKeyring(map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+
+	tests := []struct {
+		name        string
+		preallocate bool
+		keyring     *encryption.Keyring
+	}{
+		{name: "Plain"},
+		{name: "Preallocated", preallocate: true},
+		{name: "Encrypted", preallocate: true, keyring: keyring},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			newManager := func() *LogsManager {
+				fileSegment := filesystem.NewSegment(directory, 1024)
+				fileSegment.SetPreallocation(test.preallocate)
+
+				var segment segment = fileSegment
+				if test.keyring != nil {
+					segment = newEncryptedSegment(fileSegment, test.keyring, false, logger)
+				}
+
+				logsManager, _ := NewLogsManager(segment, logger)
+				return logsManager
+			}
+
+			for _, value := range []string{"1", "2"} {
+				logsManager := newManager()
+				if _, err := replayLogs(logsManager); err != nil {
+					t.Fatalf("want %+v; got %+v", nil, err)
+				}
+
+				logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key", value})})
+				_ = logsManager.Close()
+			}
+
+			// the last segment is continued after its last record on restart
+			var values []string
+			requests, err := replayLogs(newManager())
+			for _, request := range requests {
+				values = append(values, request.Arguments[1])
+			}
+
+			if err != nil || !reflect.DeepEqual(values, []string{"1", "2"}) {
+				t.Errorf("want %+v; got %+v, %+v", []string{"1", "2"}, values, err)
+			}
+
+			if ids, _ := filesystem.NewSegment(directory, 1024).List(); len(ids) != 1 {
+				t.Errorf("want 1 segment; got %+v", ids)
+			}
+		})
+	}
+}
+
+func TestLogsManager_ReadGobSegments(t *testing.T) {
+	directory := t.TempDir()
+	legacy, err := os.ReadFile("test_data/wal_1730228421090.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(directory, "wal_1000.log"), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger, _ := common.NewLogger("", "")
+	logsManager, err := NewLogsManager(filesystem.NewSegment(directory, 1024), logger)
+	if err != nil {
+		t.Fatalf("logs manager creation issue: %s", err)
+	}
+
+	if _, err = replayLogs(logsManager); err != nil {
+		t.Fatalf("logs manager read issue: %s", err)
+	}
+
+	// requests written after gob segments are read continue their sequence numbers
+	logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"3", "c"})})
+
+	requests, err := replayLogs(logsManager)
+	if err != nil {
+		t.Fatalf("logs manager read issue: %s", err)
+	}
+
+	var lsns []uint64
+	for _, request := range requests {
+		lsns = append(lsns, request.LSN())
+	}
+
+	if !reflect.DeepEqual(lsns, []uint64{1, 2, 3, 4}) || requests[3].Arguments[0] != "3" {
+		t.Errorf("want sequence numbers 1 to 4; got %+v", lsns)
+	}
+
+	ids, _ := filesystem.NewSegment(directory, 1024).List()
+	data, _ := os.ReadFile(filepath.Join(directory, "wal_"+strconv.FormatInt(ids[1], 10)+".log"))
+	if !hasSegmentHeader(data) {
+		t.Errorf("want segment started with header; got %q", data)
+	}
+}
+
+func TestLogsManager_TornTail(t *testing.T) {
+	tests := []struct {
+		name             string
+		damage           func(first, last []byte) ([]byte, []byte)
+		expectedError    bool
+		expectedRequests int
+		// expectedLastSize - size of the last segment after recovery by the size it was written with
+		// and the size of its last record
+		expectedLastSize func(written, lastRecord int) int
+	}{
+		{
+			name:             "Truncated last record",
+			damage:           func(first, last []byte) ([]byte, []byte) { return first, last[:len(last)-3] },
+			expectedRequests: 3,
+			expectedLastSize: func(written, lastRecord int) int { return written - lastRecord },
+		},
+		{
+			name:             "Corrupted last record",
+			damage:           func(first, last []byte) ([]byte, []byte) { return first, flip(last, len(last)-1) },
+			expectedRequests: 3,
+			expectedLastSize: func(written, lastRecord int) int { return written - lastRecord },
+		},
+		{
+			name: "Zero filled tail",
+			damage: func(first, last []byte) ([]byte, []byte) {
+				return first, append(bytes.Clone(last), make([]byte, 64)...)
+			},
+			expectedRequests: 4,
+			// zeros are preallocated space after the last record
+			expectedLastSize: func(written, _ int) int { return written + 64 },
+		},
+		{
+			name: "Truncated last record in preallocated space",
+			damage: func(first, last []byte) ([]byte, []byte) {
+				return first, append(bytes.Clone(last[:len(last)-3]), make([]byte, 64)...)
+			},
+			expectedRequests: 3,
+			expectedLastSize: func(written, lastRecord int) int { return written - lastRecord },
+		},
+		{
+			name:             "Preallocated segment without header",
+			damage:           func(first, last []byte) ([]byte, []byte) { return first, make([]byte, 64) },
+			expectedRequests: 2,
+			expectedLastSize: func(_, _ int) int { return 64 },
+		},
+		{
+			name:             "Partially written header",
+			damage:           func(first, last []byte) ([]byte, []byte) { return first, last[:2] },
+			expectedRequests: 2,
+			expectedLastSize: func(_, _ int) int { return 0 },
+		},
+		{
+			name: "Corrupted record followed by valid one",
+			damage: func(first, last []byte) ([]byte, []byte) {
+				return first, flip(last, segmentHeaderSize+recordHeaderSize)
+			},
+			expectedError: true,
+		},
+		{
+			name: "Corrupted length followed by valid record",
+			damage: func(first, last []byte) ([]byte, []byte) {
+				return first, flip(last, segmentHeaderSize+3)
+			},
+			expectedError: true,
+		},
+		{
+			name: "Corrupted length in the middle segment",
+			damage: func(first, last []byte) ([]byte, []byte) {
+				return flip(first, segmentHeaderSize+3), last
+			},
+			expectedError: true,
+		},
+		{
+			name:          "Truncated middle segment",
+			damage:        func(first, last []byte) ([]byte, []byte) { return first[:len(first)-3], last },
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := t.TempDir()
+			logger, _ := common.NewLogger("", "")
+			logsManager, _ := NewLogsManager(filesystem.NewSegment(directory, 1024), logger)
+
+			logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key_1", "value_1"})})
+			logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key_2", "value_2"})})
+			if _, err := logsManager.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			logsManager.Write([]Request{NewRequest(compute.SetCommand, []string{"key_3", "value_3"})})
+			logsManager.Write([]Request{NewRequest(compute.DelCommand, []string{"key_1"})})
+
+			segments, _ := filepath.Glob(filepath.Join(directory, "*.log"))
+			first, _ := os.ReadFile(segments[0])
+			last, _ := os.ReadFile(segments[1])
+			size := len(last)
+			first, last = tt.damage(first, last)
+			_ = os.WriteFile(segments[0], first, 0644)
+			_ = os.WriteFile(segments[1], last, 0644)
+
+			recovered, _ := NewLogsManager(filesystem.NewSegment(directory, 1024), logger)
+			requests, err := replayLogs(recovered)
+			if tt.expectedError {
+				if !errors.Is(err, ErrCorrupted) {
+					t.Errorf("want %+v; got %+v", ErrCorrupted, err)
+				}
+				return
+			}
+
+			if err != nil || len(requests) != tt.expectedRequests {
+				t.Fatalf("want %d requests; got %d, %+v", tt.expectedRequests, len(requests), err)
+			}
+
+			var lastRecord bytes.Buffer
+			_ = (&Request{Command: compute.DelCommand, Arguments: []string{"key_1"}, lsn: 4}).Encode(&lastRecord)
+			expectedSize := tt.expectedLastSize(size, lastRecord.Len())
+			if info, _ := os.Stat(segments[1]); info.Size() != int64(expectedSize) {
+				t.Errorf("want last segment truncated to %d bytes; got %d", expectedSize, info.Size())
+			}
+		})
+	}
+}
+
+func flip(data []byte, i int) []byte {
+	damaged := bytes.Clone(data)
+	damaged[i] ^= 0xff
+	return damaged
+}
@@ -2,13 +2,44 @@ package wal
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
+	"hash/crc32"
 )
 
+// A record is encoded as
+//
+//	length   uint32 - size of the payload
+//	checksum uint32 - CRC32C of the length and the payload
+//	payload:
+//	  lsn      uint64 - log sequence number, it grows by one with every record
+//	  opcode   uint8
+//	  count    uvarint - number of arguments
+//	  argument uvarint length followed by bytes, repeated count times
+const (
+	recordHeaderSize  = 8
+	minPayloadSize    = 8 + 1 + 1
+	maxArgumentsCount = 1 << 16
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 type Request struct {
 	Command    string
 	Arguments  []string
 	doneStatus chan error
+	// apply - applies the change once it is written, requests are applied in the order they are logged
+	apply func()
+	// async - the request does not need the batch to be synced before it is acknowledged
+	async bool
+	// sync - the batch of the request is synced before it is acknowledged whatever the sync mode is
+	sync bool
+	// logged - receives the sequence number of the request once it is written
+	logged *uint64
+	// segment - id of the segment the request has been read from
+	segment int64
+	lsn     uint64
 }
 
 func NewRequest(command string, args []string) Request {
@@ -20,11 +51,100 @@ func NewRequest(command string, args []string) Request {
 }
 
 func (r *Request) Encode(buffer *bytes.Buffer) error {
-	encoder := gob.NewEncoder(buffer)
-	return encoder.Encode(*r)
+	opcode, ok := opcodes[r.Command]
+	if !ok {
+		return fmt.Errorf("command %s cannot be logged", r.Command)
+	}
+
+	start := buffer.Len()
+	buffer.Write(make([]byte, recordHeaderSize))
+	buffer.Write(binary.LittleEndian.AppendUint64(nil, r.lsn))
+	buffer.WriteByte(opcode)
+
+	var length [binary.MaxVarintLen64]byte
+	buffer.Write(length[:binary.PutUvarint(length[:], uint64(len(r.Arguments)))])
+	for _, argument := range r.Arguments {
+		buffer.Write(length[:binary.PutUvarint(length[:], uint64(len(argument)))])
+		buffer.WriteString(argument)
+	}
+
+	record := buffer.Bytes()[start:]
+	binary.LittleEndian.PutUint32(record, uint32(len(record)-recordHeaderSize))
+	binary.LittleEndian.PutUint32(record[4:], checksum(record))
+	return nil
 }
 
+// Decode reads the next record, errors of damaged records wrap ErrCorrupted
 func (r *Request) Decode(buffer *bytes.Buffer) error {
+	if buffer.Len() < recordHeaderSize {
+		return fmt.Errorf("%w: truncated record header", ErrCorrupted)
+	}
+
+	header := buffer.Bytes()[:recordHeaderSize]
+	length := int(binary.LittleEndian.Uint32(header))
+	if length < minPayloadSize {
+		return fmt.Errorf("%w: invalid record length %d", ErrCorrupted, length)
+	}
+
+	if buffer.Len()-recordHeaderSize < length {
+		return fmt.Errorf("%w: truncated record, want %d bytes, got %d", ErrCorrupted, length, buffer.Len()-recordHeaderSize)
+	}
+
+	record := buffer.Next(recordHeaderSize + length)
+	if expected := binary.LittleEndian.Uint32(record[4:]); checksum(record) != expected {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+
+	payload := record[recordHeaderSize:]
+	lsn, opcode := binary.LittleEndian.Uint64(payload), payload[8]
+	if int(opcode) >= len(commands) || commands[opcode] == "" {
+		return fmt.Errorf("%w: invalid opcode %d", ErrCorrupted, opcode)
+	}
+
+	payload = payload[9:]
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count > maxArgumentsCount {
+		return fmt.Errorf("%w: invalid arguments count", ErrCorrupted)
+	}
+	payload = payload[n:]
+
+	arguments := make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || size > uint64(len(payload)-n) {
+			return fmt.Errorf("%w: invalid argument %d", ErrCorrupted, i)
+		}
+
+		arguments = append(arguments, string(payload[n:n+int(size)]))
+		payload = payload[n+int(size):]
+	}
+
+	if len(payload) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrCorrupted, len(payload))
+	}
+
+	r.Command, r.Arguments, r.lsn = commands[opcode], arguments, lsn
+	return nil
+}
+
+// decodeGob reads a request of segments written before the binary format
+func (r *Request) decodeGob(buffer *bytes.Buffer) error {
 	decoder := gob.NewDecoder(buffer)
 	return decoder.Decode(r)
 }
+
+// Segment returns id of the segment the recovered request has been read from
+func (r *Request) Segment() int64 {
+	return r.segment
+}
+
+// LSN returns the log sequence number of the request
+func (r *Request) LSN() uint64 {
+	return r.lsn
+}
+
+// checksum returns CRC32C of the record skipping its checksum field
+func checksum(record []byte) uint32 {
+	sum := crc32.Update(0, castagnoli, record[:4])
+	return crc32.Update(sum, castagnoli, record[recordHeaderSize:])
+}
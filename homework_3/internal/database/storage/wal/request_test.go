@@ -2,6 +2,7 @@ package wal
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -82,3 +83,40 @@ func TestMultipleSerialization(t *testing.T) {
 		t.Errorf("comparison issue: got %+v requests, expected %+v", requests, expectedRequests)
 	}
 }
+
+func TestRecordCorruption(t *testing.T) {
+	request := Request{Command: compute.EnqueueCommand, Arguments: []string{"queue", "1", "", "0", "3"}, lsn: 42}
+
+	var buffer bytes.Buffer
+	if err := request.Encode(&buffer); err != nil {
+		t.Fatalf("cannot encode request: %s", err)
+	}
+	record := buffer.Bytes()
+
+	var decoded Request
+	if err := decoded.Decode(bytes.NewBuffer(record)); err != nil || !reflect.DeepEqual(decoded, request) {
+		t.Fatalf("want %+v; got %+v, %+v", request, decoded, err)
+	}
+
+	// every bit flip is detected by the checksum or the length check
+	for i := range record {
+		for bit := 0; bit < 8; bit++ {
+			damaged := bytes.Clone(record)
+			damaged[i] ^= 1 << bit
+
+			if err := new(Request).Decode(bytes.NewBuffer(damaged)); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("want %+v for bit %d of byte %d flipped; got %+v", ErrCorrupted, bit, i, err)
+			}
+		}
+	}
+
+	for size := 0; size < len(record); size++ {
+		if err := new(Request).Decode(bytes.NewBuffer(record[:size])); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("want %+v for record truncated to %d bytes; got %+v", ErrCorrupted, size, err)
+		}
+	}
+
+	if err := (&Request{Command: compute.GetCommand}).Encode(&buffer); err == nil {
+		t.Errorf("want error for command which is not logged")
+	}
+}
@@ -1,36 +1,88 @@
 package wal
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/filesystem"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/encryption"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/snapshot"
 )
 
 const (
 	defaultFlushingTimeout = 10 * time.Millisecond
 	defaultSegmentSize     = 10_485_760
+	defaultSyncInterval    = time.Second
 )
 
+// Durability modes of written batches
+const (
+	// SyncAlways - every batch is synced to disk before its requests are acknowledged
+	SyncAlways = "always"
+	// SyncInterval - segments are synced periodically, requests written since the last sync may be lost
+	SyncInterval = "interval"
+	// SyncNone - segments are synced by the OS, they are synced only when closed
+	SyncNone = "none"
+)
+
+var ErrClosed = errors.New("wal: closed")
+
+// Commit - how a logged change is completed
+type Commit struct {
+	// Apply - applies the change once it is written, changes are applied in the order they are logged
+	Apply func()
+	// Async - the change is acknowledged once it is written without waiting for the batch to be synced
+	Async bool
+	// Sync - the change is synced before it is acknowledged whatever the sync mode is, it overrides Async
+	Sync bool
+	// LSN - receives the sequence number the change is logged with before it is acknowledged
+	LSN *uint64
+}
+
 type logManager interface {
 	Write([]Request)
-	Read() ([]Request, error)
+	Replay(func(Request)) error
+	Rotate() (int64, error)
+	Prune(int64, func(Request) bool) error
+	LastAppended() uint64
+	LastDurable() uint64
+	Advance(uint64)
+	Sync() error
+	Close() error
 }
 
 type WAL struct {
 	logsManager     logManager
 	batchSize       int
 	segmentSize     int
-	batches         chan []Request
-	writeStatus     <-chan error
 	flushingTimeout time.Duration
-	logger          *common.Logger
+	// syncInterval - period of syncs in the interval mode, zero in other modes
+	syncInterval time.Duration
+	snapshots    *snapshot.Store
+	logger       *common.Logger
 
-	mutex sync.Mutex
-	batch []Request
+	// mutex - guards requests waiting to be written, the single writer takes them in the order
+	// they were pushed
+	mutex   sync.Mutex
+	pending []Request
+	// closed - requests pushed after Stop fail at once
+	closed  bool
+	started bool
+	// batches - signals the writer that a batch of the configured size is pending
+	batches chan struct{}
+	// stop - closed by Stop, the writer writes pending requests and closes done
+	stop     chan struct{}
+	stopping sync.Once
+	done     chan struct{}
+	// closing - the logs manager is closed once either by Stop or when the context of the writer is done
+	closing  sync.Once
+	closeErr error
 }
 
 func NewWAL(cfg *common.WalConfig, logger *common.Logger) (*WAL, error) {
@@ -52,78 +104,315 @@ func NewWAL(cfg *common.WalConfig, logger *common.Logger) (*WAL, error) {
 		segmentSize = defaultSegmentSize
 	}
 
-	segment := filesystem.NewSegment(cfg.DirPath, segmentSize)
+	var keyring *encryption.Keyring
+	fileSegment := filesystem.NewSegment(cfg.DirPath, segmentSize)
+	fileSegment.SetPreallocation(cfg.PreallocateSegments)
+
+	var segment segment = fileSegment
+	if cfg.KeyFile != "" {
+		keyring, err = encryption.LoadKeyring(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		segment = newEncryptedSegment(segment.(headerSegment), keyring, cfg.AllowPlaintext, logger)
+		logger.Info("WAL segments are encrypted with key %d", keyring.Active())
+	}
+
+	var snapshots *snapshot.Store
+	if cfg.SnapshotInterval != "" {
+		interval, err := time.ParseDuration(cfg.SnapshotInterval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid snapshot interval: %s", cfg.SnapshotInterval)
+		}
+
+		snapshots, err = snapshot.NewStore(cfg.SnapshotDirPath, interval, keyring, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.AllowPlaintext {
+			snapshots.AllowPlaintext()
+		}
+	}
+
 	logsManager, err := NewLogsManager(segment, logger)
 	if err != nil {
 		return nil, err
 	}
 
+	var syncInterval time.Duration
+	switch cfg.SyncMode {
+	case "", SyncAlways:
+	case SyncInterval:
+		syncInterval, err = time.ParseDuration(cfg.SyncInterval)
+		if err != nil || syncInterval <= 0 {
+			syncInterval = defaultSyncInterval
+		}
+		logsManager.syncBatches = false
+	case SyncNone:
+		logsManager.syncBatches = false
+	default:
+		return nil, fmt.Errorf("sync mode '%s' not supported", cfg.SyncMode)
+	}
+
 	wal := &WAL{
 		logsManager:     logsManager,
 		batchSize:       cfg.BatchSize,
-		batches:         make(chan []Request, 1),
+		batches:         make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
 		flushingTimeout: timeout,
+		syncInterval:    syncInterval,
 		segmentSize:     segmentSize,
+		snapshots:       snapshots,
 		logger:          logger,
 	}
 
 	return wal, nil
 }
 
-func (w *WAL) Start() {
+// Start launches the writer, full batches are written as soon as they are pushed and the rest
+// is written when the flushing timeout passes since the last write. The writer stops when
+// the context is done or WAL is stopped, requests pushed before are written
+func (w *WAL) Start(ctx context.Context) {
+	w.mutex.Lock()
+	w.started = true
+	w.mutex.Unlock()
+
 	go func() {
+		defer close(w.done)
+
 		ticker := time.NewTicker(w.flushingTimeout)
 		defer ticker.Stop()
 
+		// a nil channel never fires when segments are not synced periodically
+		var syncs <-chan time.Time
+		if w.syncInterval > 0 {
+			syncTicker := time.NewTicker(w.syncInterval)
+			defer syncTicker.Stop()
+			syncs = syncTicker.C
+		}
+
 		for {
 			select {
-			case batch := <-w.batches:
-				w.logsManager.Write(batch)
+			case <-syncs:
+				if err := w.logsManager.Sync(); err != nil {
+					w.logger.Error("failed to sync WAL segment: %s", err)
+				}
+			case <-w.batches:
+				w.flush(false)
 				ticker.Reset(w.flushingTimeout)
 			case <-ticker.C:
-				w.flushBatch()
+				w.flush(true)
+			case <-ctx.Done():
+				w.close()
+				w.flush(true)
+				if err := w.closeLogs(); err != nil {
+					w.logger.Error("failed to close WAL segment: %s", err)
+				}
+				return
+			case <-w.stop:
+				w.flush(true)
+				return
 			}
 		}
 	}()
 }
 
-func (w *WAL) Set(key, value string) error {
-	w.push(compute.SetCommand, []string{key, value})
-	return <-w.writeStatus
+// Stop fails new requests with ErrClosed, waits until pending requests are written and closes
+// the current segment, the context bounds the wait. Stopping a stopped WAL returns the result
+// of closing the segment
+func (w *WAL) Stop(ctx context.Context) error {
+	w.close()
+
+	w.mutex.Lock()
+	started := w.started
+	w.mutex.Unlock()
+
+	if started {
+		w.stopping.Do(func() { close(w.stop) })
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to write pending requests: %w", ctx.Err())
+		}
+	} else {
+		w.flush(true)
+	}
+
+	return w.closeLogs()
+}
+
+// close makes new requests fail
+func (w *WAL) close() {
+	w.mutex.Lock()
+	w.closed = true
+	w.mutex.Unlock()
+}
+
+func (w *WAL) closeLogs() error {
+	w.closing.Do(func() {
+		w.closeErr = w.logsManager.Close()
+	})
+
+	return w.closeErr
+}
+
+// Set logs the value of the key, the change is applied after the request is written and before
+// requests logged after it are applied, it is not applied if the write fails
+func (w *WAL) Set(key, value string, commit Commit) error {
+	return <-w.push(compute.SetCommand, []string{key, value}, commit)
+}
+
+func (w *WAL) Del(key string, commit Commit) error {
+	return <-w.push(compute.DelCommand, []string{key}, commit)
+}
+
+func (w *WAL) Rename(source, destination string, commit Commit) error {
+	return <-w.push(compute.RenameCommand, []string{source, destination}, commit)
+}
+
+func (w *WAL) RenameNX(source, destination string, commit Commit) error {
+	return <-w.push(compute.RenameNXCommand, []string{source, destination}, commit)
+}
+
+func (w *WAL) Copy(source, destination string, replace bool, commit Commit) error {
+	args := []string{source, destination}
+	if replace {
+		args = append(args, compute.ReplaceOption)
+	}
+
+	return <-w.push(compute.CopyCommand, args, commit)
+}
+
+func (w *WAL) Expire(key string, expiresAt int64, commit Commit) error {
+	return <-w.push(compute.ExpireCommand, []string{key, strconv.FormatInt(expiresAt, 10)}, commit)
+}
+
+// Evict records keys removed by the engine to free memory as a single request
+func (w *WAL) Evict(keys []string, commit Commit) error {
+	return <-w.push(compute.EvictCommand, keys, commit)
+}
+
+func (w *WAL) VAdd(index, key string, coordinates []string, commit Commit) error {
+	return <-w.push(compute.VAddCommand, append([]string{index, key}, coordinates...), commit)
+}
+
+func (w *WAL) Lock(name, owner string, token uint64, expiresAt int64, commit Commit) error {
+	return <-w.push(compute.LockCommand, []string{name, owner, strconv.FormatUint(token, 10), strconv.FormatInt(expiresAt, 10)}, commit)
+}
+
+func (w *WAL) Refresh(name, owner string, expiresAt int64, commit Commit) error {
+	return <-w.push(compute.RefreshCommand, []string{name, owner, strconv.FormatInt(expiresAt, 10)}, commit)
+}
+
+func (w *WAL) Unlock(name, owner string, commit Commit) error {
+	return <-w.push(compute.UnlockCommand, []string{name, owner}, commit)
+}
+
+func (w *WAL) Enqueue(queue string, id uint64, payload string, visibleAt int64, maxAttempts int, commit Commit) error {
+	args := []string{queue, strconv.FormatUint(id, 10), payload, strconv.FormatInt(visibleAt, 10), strconv.Itoa(maxAttempts)}
+	return <-w.push(compute.EnqueueCommand, args, commit)
+}
+
+func (w *WAL) Dequeue(queue string, id uint64, attempt int, leaseUntil int64, commit Commit) error {
+	args := []string{queue, strconv.FormatUint(id, 10), strconv.Itoa(attempt), strconv.FormatInt(leaseUntil, 10)}
+	return <-w.push(compute.DequeueCommand, args, commit)
 }
 
-func (w *WAL) Del(key string) error {
-	w.push(compute.DelCommand, []string{key})
-	return <-w.writeStatus
+func (w *WAL) Ack(queue string, id uint64, attempt int, commit Commit) error {
+	return <-w.push(compute.AckCommand, []string{queue, strconv.FormatUint(id, 10), strconv.Itoa(attempt)}, commit)
 }
 
-func (w *WAL) Recover() ([]Request, error) {
-	return w.logsManager.Read()
+func (w *WAL) Nack(queue string, id uint64, attempt int, commit Commit) error {
+	return <-w.push(compute.NackCommand, []string{queue, strconv.FormatUint(id, 10), strconv.Itoa(attempt)}, commit)
 }
 
-func (w *WAL) push(cmd string, args []string) {
+// Replay passes logged requests to apply in their order while segments are read
+func (w *WAL) Replay(apply func(Request)) error {
+	return w.logsManager.Replay(apply)
+}
+
+// Advance continues the sequence of requests after the number, segments up to it may have been
+// removed after a snapshot
+func (w *WAL) Advance(lsn uint64) {
+	w.logsManager.Advance(lsn)
+}
+
+// LastAppended returns the sequence number of the last written request
+func (w *WAL) LastAppended() uint64 {
+	return w.logsManager.LastAppended()
+}
+
+// LastDurable returns the sequence number of the last request synced to disk, with the none sync mode
+// it moves only when WAL is stopped
+func (w *WAL) LastDurable() uint64 {
+	return w.logsManager.LastDurable()
+}
+
+// Snapshots returns the store of snapshots, nil if snapshots are not taken
+func (w *WAL) Snapshots() *snapshot.Store {
+	return w.snapshots
+}
+
+// Rotate starts a new segment and returns its id, requests written before the call are in older segments
+func (w *WAL) Rotate() (int64, error) {
+	return w.logsManager.Rotate()
+}
+
+// Prune removes segments older than the given one if all their requests are covered
+func (w *WAL) Prune(before int64, covered func(Request) bool) error {
+	return w.logsManager.Prune(before, covered)
+}
+
+// push queues the request and returns the channel its own write status is sent to, the change
+// is applied once the request is written and before the status is sent
+func (w *WAL) push(cmd string, args []string, commit Commit) <-chan error {
 	request := NewRequest(cmd, args)
+	request.apply, request.async, request.sync, request.logged = commit.Apply, commit.Async && !commit.Sync, commit.Sync, commit.LSN
 
 	w.mutex.Lock()
-	w.batch = append(w.batch, request)
-	if len(w.batch) == w.batchSize {
-		w.batches <- w.batch
-		w.batch = nil
+	if w.closed {
+		w.mutex.Unlock()
+		request.doneStatus <- ErrClosed
+		return request.doneStatus
 	}
+
+	w.pending = append(w.pending, request)
+	full := w.batchSize > 0 && len(w.pending) >= w.batchSize
 	w.mutex.Unlock()
 
-	w.writeStatus = request.doneStatus
-}
+	if full {
+		select {
+		case w.batches <- struct{}{}:
+		default:
+		}
+	}
 
-func (w *WAL) flushBatch() {
-	var batch []Request
+	return request.doneStatus
+}
 
+// flush writes pending requests in batches of the configured size, a partial batch is left
+// pending unless all requests are flushed
+func (w *WAL) flush(all bool) {
 	w.mutex.Lock()
-	batch = w.batch
-	w.batch = nil
+	size := len(w.pending)
+	if !all && w.batchSize > 0 {
+		size -= size % w.batchSize
+	}
+
+	requests := w.pending[:size]
+	w.pending = append([]Request(nil), w.pending[size:]...)
 	w.mutex.Unlock()
 
-	if len(batch) != 0 {
-		w.logsManager.Write(batch)
+	for len(requests) != 0 {
+		size = len(requests)
+		if w.batchSize > 0 {
+			size = min(size, w.batchSize)
+		}
+
+		w.logsManager.Write(requests[:size])
+		requests = requests[size:]
 	}
 }
@@ -27,6 +27,8 @@ var MockRequest2 = Request{
 // MockWalSegment is a mock of wal Segment interface
 type MockWalSegment struct {
 	ShouldFailWrite bool
+	// Syncs - number of Sync calls
+	Syncs int
 }
 
 func NewMockWalSegment(shouldFailWrite bool) *MockWalSegment {
@@ -80,25 +82,49 @@ func (mws *MockWalSegment) Write(data []byte) error {
 	return nil
 }
 
-func (mws *MockWalSegment) ReadAll() ([][]byte, error) {
+// MockSegmentID - id of the single segment returned by the mock
+const MockSegmentID = 1
+
+func (mws *MockWalSegment) List() ([]int64, error) {
 	if mws.ShouldFailWrite {
 		return nil, errors.New("read all segment error")
 	}
 
-	var writeBuffer bytes.Buffer
-	err := MockRequest1.Encode(&writeBuffer)
+	return []int64{MockSegmentID}, nil
+}
+
+func (mws *MockWalSegment) Read(id int64) ([]byte, error) {
+	writeBuffer := bytes.NewBuffer(segmentHeader())
+	err := MockRequest1.Encode(writeBuffer)
 	if err != nil {
 		return nil, err
 	}
 
-	err = MockRequest2.Encode(&writeBuffer)
+	err = MockRequest2.Encode(writeBuffer)
 	if err != nil {
 		return nil, err
 	}
 
-	result := [][]byte{
-		writeBuffer.Bytes(),
-	}
+	return writeBuffer.Bytes(), nil
+}
+
+func (mws *MockWalSegment) Rotate() (int64, error) {
+	return MockSegmentID + 1, nil
+}
+
+func (mws *MockWalSegment) Remove(id int64) error {
+	return nil
+}
+
+func (mws *MockWalSegment) Truncate(id int64, size int) (int64, error) {
+	return 0, nil
+}
 
-	return result, nil
+func (mws *MockWalSegment) Close() error {
+	return nil
+}
+
+func (mws *MockWalSegment) Sync() error {
+	mws.Syncs++
+	return nil
 }
@@ -1,7 +1,10 @@
 package wal
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
 	"reflect"
 	"sync"
@@ -56,6 +59,18 @@ func TestNewWAL(t *testing.T) {
 				SegmentSize: "abc",
 			},
 		},
+		{
+			name: "New WAL with unsupported sync mode",
+			logger: func() *common.Logger {
+				logger, _ := common.NewLogger("", "")
+				return logger
+			}(),
+			config: &common.WalConfig{
+				SyncMode: "sometimes",
+			},
+			expectedNilObj: true,
+			expectedError:  errors.New("sync mode 'sometimes' not supported"),
+		},
 		{
 			name: "New valid WAL",
 			logger: func() *common.Logger {
@@ -142,6 +157,7 @@ func TestWAL_Start_WriteByTimeout(t *testing.T) {
 		BatchSize:       5,
 		FlushingTimeout: "200ms",
 		SegmentSize:     "1KB",
+		DirPath:         t.TempDir(),
 	}
 
 	wal, err := NewWAL(config, logger)
@@ -150,8 +166,8 @@ func TestWAL_Start_WriteByTimeout(t *testing.T) {
 	}
 
 	start := time.Now()
-	wal.Start()
-	err = wal.Set("key1", "value1")
+	wal.Start(context.Background())
+	err = wal.Set("key1", "value1", Commit{})
 	duration := time.Since(start)
 
 	if err != nil {
@@ -169,6 +185,7 @@ func TestWAL_Start_WriteByBatchSize(t *testing.T) {
 		BatchSize:       3,
 		FlushingTimeout: "200ms",
 		SegmentSize:     "1KB",
+		DirPath:         t.TempDir(),
 	}
 
 	wal, err := NewWAL(config, logger)
@@ -180,12 +197,12 @@ func TestWAL_Start_WriteByBatchSize(t *testing.T) {
 	wg.Add(3)
 
 	start := time.Now()
-	wal.Start()
+	wal.Start(context.Background())
 
 	go func() {
 		defer wg.Done()
 
-		err = wal.Set("key1", "value1")
+		err := wal.Set("key1", "value1", Commit{})
 		if err != nil {
 			t.Errorf("wal write error: %s", err)
 		}
@@ -194,7 +211,7 @@ func TestWAL_Start_WriteByBatchSize(t *testing.T) {
 	go func() {
 		defer wg.Done()
 
-		err = wal.Del("key1")
+		err := wal.Del("key1", Commit{})
 		if err != nil {
 			t.Errorf("wal write error: %s", err)
 		}
@@ -203,7 +220,7 @@ func TestWAL_Start_WriteByBatchSize(t *testing.T) {
 	go func() {
 		defer wg.Done()
 
-		err = wal.Set("key1", "value1")
+		err := wal.Set("key1", "value1", Commit{})
 		if err != nil {
 			t.Errorf("wal write error: %s", err)
 		}
@@ -231,7 +248,7 @@ func TestWAL_Recover(t *testing.T) {
 		t.Errorf("wal cannot be created: %s", err)
 	}
 
-	requests, err := wal.Recover()
+	requests, err := replay(wal)
 	if err != nil {
 		t.Errorf("wal recover error: %s", err)
 	}
@@ -264,3 +281,184 @@ func TestWAL_Recover(t *testing.T) {
 		t.Errorf("wal recover got argumetns %+v, expected %+v", requests[0].Arguments, []string{"1"})
 	}
 }
+
+// recordingSegment - fails every other write and remembers keys of written and failed requests
+type recordingSegment struct {
+	*MockWalSegment
+
+	m       sync.Mutex
+	writes  int
+	written []string
+	failed  map[string]bool
+}
+
+func (s *recordingSegment) Write(data []byte) error {
+	var keys []string
+	for buffer := bytes.NewBuffer(data); buffer.Len() > 0; {
+		var request Request
+		if err := request.Decode(buffer); err != nil {
+			return err
+		}
+
+		keys = append(keys, request.Arguments[0])
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.writes++
+	if s.writes%2 == 0 {
+		for _, key := range keys {
+			s.failed[key] = true
+		}
+
+		return errors.New(TestWriteSegmentError)
+	}
+
+	s.written = append(s.written, keys...)
+	return nil
+}
+
+func TestWAL_ConcurrentWriters(t *testing.T) {
+	logger, _ := common.NewLogger("", "")
+	segment := &recordingSegment{MockWalSegment: NewMockWalSegment(false), failed: make(map[string]bool)}
+	logsManager, err := NewLogsManager(segment, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wal := &WAL{
+		logsManager:     logsManager,
+		batchSize:       7,
+		flushingTimeout: time.Millisecond,
+		batches:         make(chan struct{}, 1),
+		logger:          logger,
+	}
+	wal.Start(context.Background())
+
+	const writers = 500
+	var applied []string
+	errs := make([]error, writers)
+
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := range writers {
+		go func() {
+			defer wg.Done()
+
+			key := fmt.Sprintf("key_%d", i)
+			errs[i] = wal.Set(key, "value", Commit{Apply: func() {
+				applied = append(applied, key)
+			}})
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		key := fmt.Sprintf("key_%d", i)
+		if failed := segment.failed[key]; failed != (err != nil) {
+			t.Errorf("want %s failed %+v; got %+v", key, failed, err)
+		}
+	}
+
+	if len(segment.written)+len(segment.failed) != writers {
+		t.Errorf("want %+v requests; got %+v", writers, len(segment.written)+len(segment.failed))
+	}
+
+	if !reflect.DeepEqual(applied, segment.written) {
+		t.Errorf("want applied in WAL order %+v; got %+v", segment.written, applied)
+	}
+}
+
+func TestWAL_Stop(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(*WAL, context.CancelFunc) error
+	}{
+		{
+			name: "Stop",
+			stop: func(wal *WAL, _ context.CancelFunc) error {
+				return wal.Stop(context.Background())
+			},
+		},
+		{
+			name: "Context done",
+			stop: func(wal *WAL, cancel context.CancelFunc) error {
+				cancel()
+				<-wal.done
+				return nil
+			},
+		},
+		{
+			name: "Stop after context done",
+			stop: func(wal *WAL, cancel context.CancelFunc) error {
+				cancel()
+				<-wal.done
+				return wal.Stop(context.Background())
+			},
+		},
+		{
+			name: "Stop twice",
+			stop: func(wal *WAL, _ context.CancelFunc) error {
+				if err := wal.Stop(context.Background()); err != nil {
+					return err
+				}
+
+				return wal.Stop(context.Background())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger, _ := common.NewLogger("", "")
+			config := &common.WalConfig{BatchSize: 5, FlushingTimeout: "1h", SegmentSize: "1KB", DirPath: t.TempDir()}
+			wal, err := NewWAL(config, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			wal.Start(ctx)
+
+			written := make(chan error, 1)
+			go func() {
+				written <- wal.Set("key", "value", Commit{})
+			}()
+
+			for pending := 0; pending == 0; time.Sleep(time.Millisecond) {
+				wal.mutex.Lock()
+				pending = len(wal.pending)
+				wal.mutex.Unlock()
+			}
+
+			if err = test.stop(wal, cancel); err != nil {
+				t.Fatalf("want %+v; got %+v", nil, err)
+			}
+
+			if err = <-written; err != nil {
+				t.Errorf("want pending request written; got %+v", err)
+			}
+
+			if err = wal.Set("key", "value", Commit{}); !errors.Is(err, ErrClosed) {
+				t.Errorf("want %+v; got %+v", ErrClosed, err)
+			}
+
+			requests, err := replay(wal)
+			if err != nil || len(requests) != 1 {
+				t.Errorf("want 1 request; got %+v, %+v", requests, err)
+			}
+		})
+	}
+}
+
+// replay collects requests replayed by WAL
+func replay(wal *WAL) ([]Request, error) {
+	var requests []Request
+	err := wal.Replay(func(request Request) {
+		requests = append(requests, request)
+	})
+
+	return requests, err
+}
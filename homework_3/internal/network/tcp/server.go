@@ -83,7 +83,7 @@ func NewServer(cfg *common.Config, db database, logger *common.Logger) (*Server,
 // Run - serve
 func (s *Server) Run() {
 	defer func() {
-		if err := s.lis.Close(); err != nil {
+		if err := s.lis.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			s.logger.Error("failed to close listener %s", err.Error())
 		}
 		s.logger.Debug("successfully closed listener %s", s.lis.Addr().String())
@@ -112,6 +112,11 @@ func (s *Server) Run() {
 	}
 }
 
+// Close stops accepting connections, Run returns after it
+func (s *Server) Close() error {
+	return s.lis.Close()
+}
+
 // TODO decompose this function
 func (s *Server) handle(conn net.Conn) {
 	defer func() {
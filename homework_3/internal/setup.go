@@ -1,23 +1,56 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/common"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/compute"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/bitcask"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/btree"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/compression"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/engine"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/lsm"
+	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/tiered"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/database/storage/wal"
 	"github.com/sadovnikoff/GoConcurrencyCourse/homework_3/internal/network/tcp"
 )
 
 type NetworkLayer interface {
 	Run()
+	Close() error
 }
 
-func Setup(cfg *common.Config, logger *common.Logger) (NetworkLayer, error) {
+// Server - serves the database until the context is done, then the storage is shut down
+type Server struct {
+	network NetworkLayer
+	storage *storage.Storage
+	logger  *common.Logger
+}
+
+// Run serves connections until the context is done
+func (s *Server) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		if err := s.network.Close(); err != nil {
+			s.logger.Error("failed to stop accepting connections: %s", err)
+		}
+	}()
+
+	s.network.Run()
+}
+
+// Shutdown writes pending changes and closes WAL and the engine, changes made after it fail
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.storage.Stop(ctx)
+}
+
+// Setup builds the server, WAL keeps writing after the context is done until Shutdown drains it
+func Setup(ctx context.Context, cfg *common.Config, logger *common.Logger) (*Server, error) {
 	if cfg == nil {
 		return nil, errors.New("config is nil")
 	}
@@ -27,17 +60,9 @@ func Setup(cfg *common.Config, logger *common.Logger) (NetworkLayer, error) {
 		return nil, err
 	}
 
-	var dbEngine storage.Engine
-	if cfg.Engine.Type == engine.InMemoryEngine {
-		logger.Debug("setup server: in-memory engine has been chosen")
-		dbEngine, err = engine.NewEngine(logger)
-		if err != nil {
-			logger.Debug("setup server: in-memory engine cannot be set up")
-			return nil, err
-		}
-	} else {
-		logger.Debug("setup server: engine [%s] not supported", cfg.Engine.Type)
-		return nil, fmt.Errorf("engine type '%s' not supported", cfg.Engine.Type)
+	dbEngine, err := newEngine(cfg.Engine, logger)
+	if err != nil {
+		return nil, err
 	}
 
 	dbWAL, err := wal.NewWAL(cfg.Wal, logger)
@@ -46,12 +71,24 @@ func Setup(cfg *common.Config, logger *common.Logger) (NetworkLayer, error) {
 		return nil, err
 	}
 
-	storageLayer, err := storage.NewStorage(dbEngine, dbWAL, logger)
+	// a nil *wal.WAL must not be passed as a non-nil storage.WAL
+	var storageWAL storage.WAL
+	if dbWAL != nil {
+		storageWAL = dbWAL
+	}
+
+	storageLayer, err := storage.NewStorage(dbEngine, storageWAL, logger)
 	if err != nil {
 		logger.Debug("setup server: storage cannot be set up")
 		return nil, err
 	}
 
+	compressor, err := newCompressor(cfg.Engine, logger)
+	if err != nil {
+		return nil, err
+	}
+	storageLayer.SetCompressor(compressor)
+
 	db, err := database.NewDatabase(computeLayer, storageLayer, logger)
 	if err != nil {
 		logger.Debug("setup server: database cannot be set up")
@@ -65,8 +102,165 @@ func Setup(cfg *common.Config, logger *common.Logger) (NetworkLayer, error) {
 	}
 
 	if dbWAL != nil {
-		go dbWAL.Start()
+		// the signal must not stop WAL before the changes in flight are written, it is stopped by Storage.Stop
+		dbWAL.Start(context.WithoutCancel(ctx))
+	}
+
+	storageLayer.Start()
+
+	return &Server{network: server, storage: storageLayer, logger: logger}, nil
+}
+
+func newEngine(cfg *common.EngineConfig, logger *common.Logger) (storage.Engine, error) {
+	maxMemory, err := parseOptionalSize(cfg.MaxMemory)
+	if err != nil {
+		logger.Debug("setup server: invalid engine max memory [%s]", cfg.MaxMemory)
+		return nil, err
+	}
+
+	// only engines keeping keys in a map evict them, others would silently exceed the limit
+	switch cfg.Type {
+	case engine.InMemoryMVCCEngine, lsm.LSMEngine, bitcask.BitcaskEngine, tiered.TieredEngine, btree.BTreeEngine:
+		if maxMemory != 0 || (cfg.EvictionPolicy != "" && cfg.EvictionPolicy != engine.NoEviction) {
+			logger.Debug("setup server: engine [%s] does not support max memory and eviction policy", cfg.Type)
+			return nil, fmt.Errorf("max_memory and eviction_policy are not supported by engine type '%s'", cfg.Type)
+		}
+	}
+
+	switch cfg.Type {
+	case engine.InMemoryEngine:
+		logger.Debug("setup server: in-memory engine has been chosen")
+		dbEngine, err := engine.NewLimitedEngine(maxMemory, cfg.EvictionPolicy, logger)
+		if err != nil {
+			logger.Debug("setup server: in-memory engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	case engine.InMemoryShardedEngine:
+		logger.Debug("setup server: sharded in-memory engine has been chosen")
+		dbEngine, err := engine.NewShardedEngine(cfg.ShardsCount, maxMemory, cfg.EvictionPolicy, logger)
+		if err != nil {
+			logger.Debug("setup server: sharded in-memory engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	case engine.InMemoryOrderedEngine:
+		logger.Debug("setup server: ordered in-memory engine has been chosen")
+		dbEngine, err := engine.NewOrderedEngine(maxMemory, cfg.EvictionPolicy, logger)
+		if err != nil {
+			logger.Debug("setup server: ordered in-memory engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	case engine.InMemoryMVCCEngine:
+		logger.Debug("setup server: MVCC in-memory engine has been chosen")
+		dbEngine, err := engine.NewMVCCEngine(logger)
+		if err != nil {
+			logger.Debug("setup server: MVCC in-memory engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	case lsm.LSMEngine:
+		logger.Debug("setup server: LSM engine has been chosen")
+		memtableSize, err := parseOptionalSize(cfg.MemtableSize)
+		if err != nil {
+			logger.Debug("setup server: invalid engine memtable size [%s]", cfg.MemtableSize)
+			return nil, err
+		}
+
+		dbEngine, err := lsm.NewEngine(engineDirectory(cfg), memtableSize, logger)
+		if err != nil {
+			logger.Debug("setup server: LSM engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	case bitcask.BitcaskEngine:
+		logger.Debug("setup server: bitcask engine has been chosen")
+		dataFileSize, err := parseOptionalSize(cfg.DataFileSize)
+		if err != nil {
+			logger.Debug("setup server: invalid engine data file size [%s]", cfg.DataFileSize)
+			return nil, err
+		}
+
+		dbEngine, err := bitcask.NewEngine(engineDirectory(cfg), dataFileSize, logger)
+		if err != nil {
+			logger.Debug("setup server: bitcask engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	case tiered.TieredEngine:
+		logger.Debug("setup server: tiered engine has been chosen")
+		memoryBudget, err := parseOptionalSize(cfg.MemoryBudget)
+		if err != nil {
+			logger.Debug("setup server: invalid engine memory budget [%s]", cfg.MemoryBudget)
+			return nil, err
+		}
+
+		dataFileSize, err := parseOptionalSize(cfg.DataFileSize)
+		if err != nil {
+			logger.Debug("setup server: invalid engine data file size [%s]", cfg.DataFileSize)
+			return nil, err
+		}
+
+		dbEngine, err := tiered.NewEngine(engineDirectory(cfg), memoryBudget, dataFileSize, logger)
+		if err != nil {
+			logger.Debug("setup server: tiered engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	case btree.BTreeEngine:
+		logger.Debug("setup server: B+tree engine has been chosen")
+		dbEngine, err := btree.NewEngine(engineDirectory(cfg), logger)
+		if err != nil {
+			logger.Debug("setup server: B+tree engine cannot be set up")
+			return nil, err
+		}
+
+		return dbEngine, nil
+	}
+
+	logger.Debug("setup server: engine [%s] not supported", cfg.Type)
+	return nil, fmt.Errorf("engine type '%s' not supported", cfg.Type)
+}
+
+func newCompressor(cfg *common.EngineConfig, logger *common.Logger) (*compression.Compressor, error) {
+	threshold, err := parseOptionalSize(cfg.CompressionThreshold)
+	if err != nil {
+		logger.Debug("setup server: invalid compression threshold [%s]", cfg.CompressionThreshold)
+		return nil, err
+	}
+
+	compressor, err := compression.NewCompressor(cfg.Compression, threshold)
+	if err != nil {
+		logger.Debug("setup server: compression [%s] not supported", cfg.Compression)
+		return nil, err
+	}
+
+	return compressor, nil
+}
+
+// engineDirectory returns the directory of the disk-based engine, engines keep their files in subdirectories
+// of the data directory named by their types, an empty data directory means the default of the engine
+func engineDirectory(cfg *common.EngineConfig) string {
+	if cfg.DataDir == "" {
+		return ""
+	}
+
+	return filepath.Join(cfg.DataDir, cfg.Type)
+}
+
+// parseOptionalSize parses the size, an empty size means the default of the engine
+func parseOptionalSize(size string) (int, error) {
+	if size == "" {
+		return 0, nil
 	}
 
-	return server, nil
+	return common.ParseSize(size)
 }